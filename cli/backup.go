@@ -0,0 +1,130 @@
+package cli
+
+import (
+	"fmt"
+	"github.com/SchulteMarkus/sse-belmngr-hermine/hermine"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+var backupRetentionPolicyCliArgument hermine.BackupRetentionPolicy
+
+var backupCommand = &cobra.Command{
+	Use:   "backup",
+	Short: "Manages the backups of the BelegManager database created before each run",
+	Long: "Manages the backups of the BelegManager database created before each run, " +
+		"in --backup-directory or next to the database.",
+	Args: cobra.NoArgs,
+}
+
+var backupListCommand = &cobra.Command{
+	Use:     "list",
+	Short:   "Lists the backups, newest first, with size and BelegManager database version",
+	Args:    cobra.NoArgs,
+	PreRunE: validateBackupCliArguments,
+	RunE:    runBackupList,
+}
+
+var backupRestoreCommand = &cobra.Command{
+	Use:   "restore <timestamp>",
+	Short: "Replaces the BelegManager database by a backup",
+	Long: "Replaces the BelegManager database by the backup with the timestamp, as shown by 'backup list'. " +
+		"The database must not be in use, see --db-lock-wait and --force. The current database is backed up first.",
+	Args:    cobra.ExactArgs(1),
+	PreRunE: validateBackupCliArguments,
+	RunE:    runBackupRestore,
+}
+
+var backupPruneCommand = &cobra.Command{
+	Use:   "prune",
+	Short: "Removes the backups not kept by the retention policy",
+	Long: "Removes the backups not kept by the retention policy. A backup is kept if it is one of the newest " +
+		"--keep-last backups, or the newest backup of one of the last --keep-daily days or --keep-weekly weeks. " +
+		"Use --dry-run to preview.",
+	Args:    cobra.NoArgs,
+	PreRunE: validateBackupCliArguments,
+	RunE:    runBackupPrune,
+}
+
+func init() {
+	flags := backupPruneCommand.Flags()
+	flags.IntVar(&backupRetentionPolicyCliArgument.KeepLast, "keep-last", 10, "Number of newest backups to keep")
+	flags.IntVar(&backupRetentionPolicyCliArgument.KeepDaily, "keep-daily", 7, "Number of days to keep the newest backup of")
+	flags.IntVar(&backupRetentionPolicyCliArgument.KeepWeekly, "keep-weekly", 4, "Number of weeks to keep the newest backup of")
+	for _, flagName := range []string{"keep-last", "keep-daily", "keep-weekly"} {
+		flagConfigKeys[flagName] = "backup." + flagName
+	}
+
+	backupCommand.AddCommand(backupListCommand, backupRestoreCommand, backupPruneCommand)
+	Command.AddCommand(backupCommand)
+}
+
+func validateBackupCliArguments(_ *cobra.Command, _ []string) error {
+	initLogging(logLevelCliArgument)
+	return validateBelegManagerDatabase()
+}
+
+func runBackupList(cmd *cobra.Command, _ []string) error {
+	backups, listErr := hermine.ListBackups(absolutePathOfBelegManagerSqLiteDB, backupDirectoryCliArgument)
+	if listErr != nil {
+		log.WithError(listErr).Error("Failed to list backups")
+		return listErr
+	}
+	if len(backups) == 0 {
+		log.Info("No backups found")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "TIMESTAMP\tCREATED\tSIZE\tVERSION\tPATH")
+	for _, backup := range backups {
+		version := "unreadable"
+		if backup.SchemaVersion != 0 {
+			version = strconv.Itoa(backup.SchemaVersion)
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+			backup.ID, backup.Time.Format(time.DateTime), formatFileSize(backup.Size), version, backup.Path)
+	}
+
+	return w.Flush()
+}
+
+func runBackupRestore(_ *cobra.Command, args []string) error {
+	if restoreErr := hermine.RestoreBackup(absolutePathOfBelegManagerSqLiteDB, backupDirectoryCliArgument, args[0], databaseCliArgument.Lock); restoreErr != nil {
+		log.Error(restoreErr)
+		return restoreErr
+	}
+
+	return nil
+}
+
+func runBackupPrune(_ *cobra.Command, _ []string) error {
+	pruned, pruneErr := hermine.PruneBackups(
+		absolutePathOfBelegManagerSqLiteDB, backupDirectoryCliArgument, backupRetentionPolicyCliArgument, dryRunCliArgument)
+	if pruneErr != nil {
+		log.Error(pruneErr)
+		return pruneErr
+	}
+
+	if !dryRunCliArgument {
+		log.Infof("Removed %d backup(s)", len(pruned))
+	}
+	return nil
+}
+
+func formatFileSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
@@ -2,6 +2,7 @@ package cli
 
 import (
 	"fmt"
+	"github.com/SchulteMarkus/sse-belmngr-hermine/hermine"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
@@ -10,6 +11,7 @@ import (
 	"os/user"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 const shortCommandDescription = "Sse-BelMngr-Hermine analyzes local documents " +
@@ -19,7 +21,11 @@ const defaultBelegManagerImportPath = "BelegManager-Import"
 
 var cmdConfigFile string
 
+// flagConfigKeys maps flag names to configuration keys, if they differ, e.g. for flags configured in a section.
+var flagConfigKeys = map[string]string{}
+
 var Command = &cobra.Command{
+	Use:          "sse-belmngr-hermine",
 	Short:        shortCommandDescription,
 	SilenceUsage: true,
 	PersistentPreRunE: func(cmd *cobra.Command, _ []string) error {
@@ -54,15 +60,277 @@ func createApplicationFlags() error {
 
 	persistentFlags := Command.PersistentFlags()
 
+	// di-key and di-endpoint are required unless replaying or using Entra ID, see validateCliArguments
 	persistentFlags.StringVar(&diKeyCliArgument, "di-key", "", "Azure AI Document Intelligence key")
-	if err := Command.MarkPersistentFlagRequired("di-key"); err != nil {
+	persistentFlags.StringVar(&diEndpointCliArgument, "di-endpoint", "", "Azure AI Document Intelligence endpoint")
+
+	persistentFlags.StringVar(
+		&diModelCliArgument,
+		"di-model",
+		hermine.DIModelInvoice,
+		"Document Intelligence model, e.g. prebuilt-invoice, prebuilt-receipt or auto (invoice, falling back to receipt)",
+	)
+	persistentFlags.StringArrayVar(
+		&diModelGlobsCliArgument,
+		"di-model-glob",
+		nil,
+		"Model for files matching a glob pattern, as '<model>=<glob>', e.g. 'prebuilt-receipt=**/Kassenbons/**' (repeatable)",
+	)
+
+	persistentFlags.BoolVar(
+		&replayCliArgument,
+		"replay",
+		false,
+		"Import using saved analysis results from '<file>"+hermine.AnalysisSidecarFileEnding+"' sidecar files, without calling Azure",
+	)
+
+	persistentFlags.BoolVar(
+		&dryRunCliArgument,
+		"dry-run",
+		false,
+		"Analyze and map documents, print the planned changes, but neither change the database nor the data directory",
+	)
+	persistentFlags.StringVar(
+		&dryRunReportCliArgument,
+		"dry-run-report",
+		"",
+		"Path of a CSV file receiving the changes planned by a dry run",
+	)
+
+	persistentFlags.StringVar(
+		&duplicatePolicyCliArgument,
+		"duplicate-policy",
+		hermine.DuplicatePolicyCreate,
+		"Handling of new files matching an existing Beleg by number, amount, date and vendor ("+
+			strings.Join(hermine.ValidDuplicatePolicies(), ", ")+")",
+	)
+
+	persistentFlags.StringVar(
+		&customerConfiguration.Mode,
+		"customer-mode",
+		hermine.CustomerModeCategory,
+		"Linking the customer of a document to the Beleg as category or as person ("+
+			strings.Join(hermine.ValidCustomerModes(), ", ")+")",
+	)
+	persistentFlags.BoolVar(
+		&customerConfiguration.CreatePersons,
+		"create-persons",
+		false,
+		"Create a person for a customer matching no existing person, for 'customer-mode' 'person'",
+	)
+	flagConfigKeys["customer-mode"] = "customer.mode"
+	flagConfigKeys["create-persons"] = "customer.create-persons"
+
+	persistentFlags.StringSliceVar(
+		&labelOptionsCliArgument.Templates,
+		"label-templates",
+		[]string{"Hermine-Import", "Import {date}", "{model}"},
+		"Labels of imported Belege, '{date}' is replaced by the import date, '{model}' by the model, '{<field>}' by the field",
+	)
+	persistentFlags.Float64Var(
+		&labelOptionsCliArgument.LowConfidenceThreshold,
+		"label-low-confidence-threshold",
+		0.8,
+		"Confidence of the total below which Belege get the 'label-low-confidence' label (0 disables it)",
+	)
+	persistentFlags.StringVar(
+		&labelOptionsCliArgument.LowConfidence,
+		"label-low-confidence",
+		"low-confidence",
+		"Label of Belege whose total has a low confidence, or has not been recognized",
+	)
+	for _, flagName := range []string{"label-templates", "label-low-confidence-threshold", "label-low-confidence"} {
+		flagConfigKeys[flagName] = "label." + strings.TrimPrefix(flagName, "label-")
+	}
+
+	persistentFlags.Float64Var(
+		&vendorConfiguration.FuzzyThreshold,
+		"vendor-fuzzy-threshold",
+		0.9,
+		"Similarity, from 0 to 1, of a vendor to an existing category required to assign it (0 disables fuzzy matching)",
+	)
+	flagConfigKeys["vendor-fuzzy-threshold"] = "vendor.fuzzy-threshold"
+
+	persistentFlags.StringVar(
+		&rulesFileCliArgument,
+		"rules-file",
+		"",
+		"Path of a YAML file of rules assigning categories, labels, persons and Steuerfälle to Belege",
+	)
+
+	persistentFlags.StringSliceVar(
+		&afterImportCliArgument,
+		"after-import",
+		[]string{hermine.AfterImportKeep},
+		"Handling of processed files, e.g. 'move,move-failed' ("+strings.Join(hermine.ValidAfterImportPolicies(), ", ")+")",
+	)
+	persistentFlags.StringVar(
+		&processedDirectoryCliArgument,
+		"processed-directory",
+		"",
+		"Directory receiving imported files for 'after-import' 'move' (default: '<import directory>-processed')",
+	)
+	persistentFlags.StringVar(
+		&quarantineDirectoryCliArgument,
+		"quarantine-directory",
+		"",
+		"Directory receiving files failed to import for 'after-import' 'move-failed' (default: '<import directory>-failed')",
+	)
+
+	persistentFlags.IntVar(
+		&concurrencyCliArgument,
+		"concurrency",
+		4,
+		"Maximum number of files analyzed at the same time",
+	)
+	persistentFlags.IntVar(
+		&dbConcurrencyCliArgument,
+		"db-concurrency",
+		1,
+		"Maximum number of documents written into the database at the same time (1 serializes writes)",
+	)
+
+	persistentFlags.DurationVar(
+		&timeoutCliArgument,
+		"timeout",
+		0,
+		"Maximum duration of the whole run, e.g. 30m (0 means no limit)",
+	)
+	persistentFlags.DurationVar(
+		&documentTimeoutCliArgument,
+		"document-timeout",
+		10*time.Minute,
+		"Maximum duration of analyzing a single file (0 means no limit)",
+	)
+
+	if err := createAnalysisCacheFlags(); err != nil {
 		return err
 	}
 
-	persistentFlags.StringVar(&diEndpointCliArgument, "di-endpoint", "", "Azure AI Document Intelligence endpoint")
-	if err := Command.MarkPersistentFlagRequired("di-endpoint"); err != nil {
+	createRetryFlags()
+	createEntraIDFlags()
+	return nil
+}
+
+// createEntraIDFlags creates the flags of the "di-entra-id" section of the configuration file.
+func createEntraIDFlags() {
+	persistentFlags := Command.PersistentFlags()
+
+	persistentFlags.StringVar(
+		&diAuthCliArgument,
+		"di-auth",
+		diAuthKey,
+		"Authentication against Document Intelligence ("+diAuthKey+", "+diAuthEntraID+")",
+	)
+	persistentFlags.StringVar(
+		&entraIDConfigCliArgument.TenantID,
+		"di-entra-id-tenant-id",
+		"",
+		"Microsoft Entra ID tenant (directory) ID",
+	)
+	persistentFlags.StringVar(
+		&entraIDConfigCliArgument.ClientID,
+		"di-entra-id-client-id",
+		"",
+		"Client (application) ID of the Entra ID app registration",
+	)
+	persistentFlags.StringVar(
+		&entraIDConfigCliArgument.ClientSecret,
+		"di-entra-id-client-secret",
+		"",
+		"Client secret of the Entra ID app registration",
+	)
+	persistentFlags.StringVar(
+		&entraIDCertificateCliArgument,
+		"di-entra-id-certificate",
+		"",
+		"PEM file containing the certificate and RSA private key of the Entra ID app registration, instead of a secret",
+	)
+	persistentFlags.StringVar(
+		&entraIDConfigCliArgument.AuthorityHost,
+		"di-entra-id-authority-host",
+		hermine.DefaultEntraIDAuthorityHost,
+		"Entra ID authority host, e.g. for national clouds",
+	)
+
+	for _, flagName := range []string{
+		"di-entra-id-tenant-id",
+		"di-entra-id-client-id",
+		"di-entra-id-client-secret",
+		"di-entra-id-certificate",
+		"di-entra-id-authority-host",
+	} {
+		flagConfigKeys[flagName] = "di-entra-id." + strings.TrimPrefix(flagName, "di-entra-id-")
+	}
+}
+
+// createRetryFlags creates the flags of the "di-retry" section of the configuration file.
+func createRetryFlags() {
+	persistentFlags := Command.PersistentFlags()
+	defaultRetryPolicy := hermine.DefaultRetryPolicy()
+
+	persistentFlags.IntVar(
+		&retryPolicyCliArgument.MaxAttempts,
+		"di-retry-max-attempts",
+		defaultRetryPolicy.MaxAttempts,
+		"Maximum attempts per Document Intelligence request, including the first one",
+	)
+	persistentFlags.DurationVar(
+		&retryPolicyCliArgument.InitialBackoff,
+		"di-retry-initial-backoff",
+		defaultRetryPolicy.InitialBackoff,
+		"Delay before the first retry, doubled for each further retry",
+	)
+	persistentFlags.DurationVar(
+		&retryPolicyCliArgument.MaxBackoff,
+		"di-retry-max-backoff",
+		defaultRetryPolicy.MaxBackoff,
+		"Maximum delay between two attempts, unless demanded otherwise by 'Retry-After'",
+	)
+	persistentFlags.Float64Var(
+		&retryPolicyCliArgument.Jitter,
+		"di-retry-jitter",
+		defaultRetryPolicy.Jitter,
+		"Fraction randomizing retry delays, e.g. 0.2 for +/- 20%",
+	)
+
+	for _, flagName := range []string{"di-retry-max-attempts", "di-retry-initial-backoff", "di-retry-max-backoff", "di-retry-jitter"} {
+		flagConfigKeys[flagName] = "di-retry." + strings.TrimPrefix(flagName, "di-retry-")
+	}
+}
+
+func createAnalysisCacheFlags() error {
+	userCacheDir, err := os.UserCacheDir()
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "Error getting user cache directory: ", err)
 		return err
 	}
+	analysisCacheDefaultDirectory := filepath.Join(userCacheDir, "sse-belmngr-hermine", "di-analysis")
+
+	persistentFlags := Command.PersistentFlags()
+
+	persistentFlags.StringVar(
+		&analysisCacheDirectoryCliArgument,
+		"di-cache-directory",
+		analysisCacheDefaultDirectory,
+		"Directory caching Document Intelligence analysis results",
+	)
+	viper.SetDefault("di-cache-directory", analysisCacheDefaultDirectory)
+
+	persistentFlags.StringVar(
+		&analysisCacheModeCliArgument,
+		"di-cache",
+		hermine.AnalysisCacheModeUse,
+		"Usage of cached Document Intelligence analysis results (use, bypass, refresh)",
+	)
+	viper.SetDefault("di-cache", hermine.AnalysisCacheModeUse)
+
+	persistentFlags.DurationVar(
+		&analysisCachePruneOlderThanCliArgument,
+		"di-cache-prune-older-than",
+		0,
+		"Remove cached analysis results not used for this duration, e.g. 720h (0 disables pruning)",
+	)
 
 	return nil
 }
@@ -106,6 +374,39 @@ func createBelegManagerFlags() error {
 		)
 	viper.SetDefault("beleg-manager-data-directory", belegManagerDirectoryCliArgument)
 
+	Command.
+		PersistentFlags().
+		StringVar(
+			&backupDirectoryCliArgument,
+			"backup-directory",
+			"",
+			"Directory receiving the backups of the BelegManager database (default: the BelegManager data directory)",
+		)
+	Command.
+		PersistentFlags().
+		DurationVar(
+			&databaseCliArgument.Lock.Wait,
+			"db-lock-wait",
+			0,
+			"Maximum duration to wait for BelegManager or another process to release the database, e.g. 2m (0 fails immediately)",
+		)
+	Command.
+		PersistentFlags().
+		BoolVar(
+			&databaseCliArgument.Lock.Force,
+			"force",
+			false,
+			"Continue although the BelegManager database is in use, risking inconsistent data",
+		)
+	Command.
+		PersistentFlags().
+		BoolVar(
+			&databaseCliArgument.AllowUntestedSchema,
+			"allow-untested-schema",
+			false,
+			"Allow BelegManager database versions not tested, if their columns fit a tested version",
+		)
+
 	return nil
 }
 
@@ -123,7 +424,7 @@ func createLoggingFlags() {
 }
 
 func initConfiguration() error {
-	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
+	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_", ".", "_"))
 	viper.AutomaticEnv()
 
 	if cmdConfigFile == "" {
@@ -144,10 +445,19 @@ func initConfiguration() error {
 // Config value can be set via ENV, configFile or as command line argument.
 func bindFlags(cmd *cobra.Command) error {
 	cmd.Flags().VisitAll(func(f *pflag.Flag) {
-		_ = viper.BindPFlag(f.Name, cmd.PersistentFlags().Lookup(f.Name))
+		configKey := f.Name
+		if sectionKey, inSection := flagConfigKeys[f.Name]; inSection {
+			configKey = sectionKey
+		}
+		_ = viper.BindPFlag(configKey, f)
+
+		if !f.Changed && viper.IsSet(configKey) {
+			if sliceValue, isSlice := f.Value.(pflag.SliceValue); isSlice {
+				_ = sliceValue.Replace(viper.GetStringSlice(configKey))
+				return
+			}
 
-		if !f.Changed && viper.IsSet(f.Name) {
-			val := viper.Get(f.Name)
+			val := viper.Get(configKey)
 			if err := cmd.Flags().Set(f.Name, fmt.Sprintf("%v", val)); err != nil {
 				return
 			}
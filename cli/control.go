@@ -1,22 +1,166 @@
 package cli
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"github.com/SchulteMarkus/sse-belmngr-hermine/hermine"
 	"github.com/bmatcuk/doublestar/v4"
+	"github.com/jmoiron/sqlx"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"slices"
+	"syscall"
+	"time"
+)
+
+const (
+	diAuthKey     = "key"
+	diAuthEntraID = "entra-id"
 )
 
 var (
-	logLevelCliArgument                                            string
-	absolutePathOfBelegManagerSqLiteDB                             string
-	belegManagerDirectoryCliArgument, filesToImportGlobCliArgument string
-	diEndpointCliArgument, diKeyCliArgument                        string
+	logLevelCliArgument                                             string
+	absolutePathOfBelegManagerSqLiteDB                              string
+	belegManagerDirectoryCliArgument, filesToImportGlobCliArgument  string
+	backupDirectoryCliArgument                                      string
+	databaseCliArgument                                             hermine.DatabaseOptions
+	diEndpointCliArgument, diKeyCliArgument                         string
+	diModelCliArgument                                              string
+	diModelGlobsCliArgument                                         []string
+	modelGlobsCliArgument                                           []hermine.ModelGlob
+	fieldMappingsConfiguration                                      []hermine.FieldMapping
+	steuerfallConfiguration                                         hermine.SteuerfallOptions
+	customerConfiguration                                           hermine.CustomerOptions
+	labelOptionsCliArgument                                         hermine.LabelOptions
+	rulesFileCliArgument                                            string
+	rulesConfiguration                                              *hermine.Rules
+	vendorConfiguration                                             hermine.VendorOptions
+	analysisCacheDirectoryCliArgument, analysisCacheModeCliArgument string
+	analysisCachePruneOlderThanCliArgument                          time.Duration
+	timeoutCliArgument, documentTimeoutCliArgument                  time.Duration
+	replayCliArgument, dryRunCliArgument                            bool
+	dryRunReportCliArgument, duplicatePolicyCliArgument             string
+	retryPolicyCliArgument                                          hermine.RetryPolicy
+	diAuthCliArgument, entraIDCertificateCliArgument                string
+	entraIDConfigCliArgument                                        hermine.EntraIDConfig
+	concurrencyCliArgument, dbConcurrencyCliArgument                int
+	afterImportCliArgument                                          []string
+	processedDirectoryCliArgument, quarantineDirectoryCliArgument   string
+	afterImportOptions                                              hermine.AfterImportOptions
 )
 
 func validateCliArguments(_ *cobra.Command, _ []string) error {
+	if !replayCliArgument {
+		if err := validateDiAuthCliArguments(); err != nil {
+			log.Error(err)
+			return err
+		}
+	}
+
+	if concurrencyCliArgument < 1 || dbConcurrencyCliArgument < 1 {
+		err := errors.New(`"concurrency" and "db-concurrency" must be at least 1`)
+		log.Error(err)
+		return err
+	}
+
+	if !hermine.IsValidDuplicatePolicy(duplicatePolicyCliArgument) {
+		err := fmt.Errorf(`unknown "duplicate-policy" '%s'`, duplicatePolicyCliArgument)
+		log.Error(err)
+		return err
+	}
+
+	if !hermine.IsValidCustomerMode(customerConfiguration.Mode) {
+		err := fmt.Errorf(`unknown "customer-mode" '%s'`, customerConfiguration.Mode)
+		log.Error(err)
+		return err
+	}
+
+	if validationErr := labelOptionsCliArgument.Validate(); validationErr != nil {
+		err := fmt.Errorf(`invalid "label" configuration: %w`, validationErr)
+		log.Error(err)
+		return err
+	}
+
+	var afterImportErr error
+	afterImportOptions, afterImportErr = hermine.NewAfterImportOptions(
+		afterImportCliArgument, filesToImportGlobCliArgument, processedDirectoryCliArgument, quarantineDirectoryCliArgument)
+	if afterImportErr != nil {
+		log.Error(afterImportErr)
+		return afterImportErr
+	}
+
+	modelGlobsCliArgument = make([]hermine.ModelGlob, 0, len(diModelGlobsCliArgument))
+	for _, diModelGlob := range diModelGlobsCliArgument {
+		modelGlob, parseErr := hermine.ParseModelGlob(diModelGlob)
+		if parseErr != nil {
+			log.Error(parseErr)
+			return parseErr
+		}
+		modelGlobsCliArgument = append(modelGlobsCliArgument, modelGlob)
+	}
+
+	// Field mappings are too complex for flags, so they are configured in the configuration file only
+	if unmarshalErr := viper.UnmarshalKey("di-field-mappings", &fieldMappingsConfiguration); unmarshalErr != nil {
+		log.WithError(unmarshalErr).Error(`Invalid "di-field-mappings" configuration`)
+		return unmarshalErr
+	}
+	for _, fieldMapping := range fieldMappingsConfiguration {
+		if validationErr := fieldMapping.Validate(); validationErr != nil {
+			log.WithError(validationErr).Error(`Invalid "di-field-mappings" configuration`)
+			return validationErr
+		}
+	}
+
+	if unmarshalErr := viper.UnmarshalKey("steuerfall", &steuerfallConfiguration); unmarshalErr != nil {
+		log.WithError(unmarshalErr).Error(`Invalid "steuerfall" configuration`)
+		return unmarshalErr
+	}
+	for _, yearRule := range steuerfallConfiguration.YearRules {
+		if validationErr := yearRule.Validate(); validationErr != nil {
+			log.WithError(validationErr).Error(`Invalid "steuerfall" configuration`)
+			return validationErr
+		}
+	}
+
+	if unmarshalErr := viper.UnmarshalKey("customer.persons", &customerConfiguration.Persons); unmarshalErr != nil {
+		log.WithError(unmarshalErr).Error(`Invalid "customer" configuration`)
+		return unmarshalErr
+	}
+	for _, person := range customerConfiguration.Persons {
+		if validationErr := person.Validate(); validationErr != nil {
+			log.WithError(validationErr).Error(`Invalid "customer" configuration`)
+			return validationErr
+		}
+	}
+
+	if unmarshalErr := viper.UnmarshalKey("vendor.aliases", &vendorConfiguration.Aliases); unmarshalErr != nil {
+		log.WithError(unmarshalErr).Error(`Invalid "vendor" configuration`)
+		return unmarshalErr
+	}
+	if validationErr := vendorConfiguration.Validate(); validationErr != nil {
+		log.WithError(validationErr).Error(`Invalid "vendor" configuration`)
+		return validationErr
+	}
+
+	if rulesFileCliArgument != "" {
+		var loadErr error
+		if rulesConfiguration, loadErr = hermine.LoadRules(rulesFileCliArgument); loadErr != nil {
+			log.WithError(loadErr).Error(`Invalid "rules-file"`)
+			return loadErr
+		}
+		log.Debugf("%d rule(s) loaded from %s", len(rulesConfiguration.Rules), rulesFileCliArgument)
+	}
+
+	return validateBelegManagerDatabase()
+}
+
+// validateBelegManagerDatabase checks the BelegManager database file exists in "beleg-manager-data-directory".
+func validateBelegManagerDatabase() error {
 	absolutePathOfBelegManagerSqLiteDB =
 		filepath.Join(belegManagerDirectoryCliArgument, hermine.BelMngrSqLiteDatabaseFileName)
 	if _, err := os.Stat(absolutePathOfBelegManagerSqLiteDB); os.IsNotExist(err) {
@@ -31,15 +175,48 @@ func validateCliArguments(_ *cobra.Command, _ []string) error {
 	return nil
 }
 
-func run(_ *cobra.Command, _ []string) error {
-	initLogging(logLevelCliArgument)
+func validateDiAuthCliArguments() error {
+	if diEndpointCliArgument == "" {
+		return errors.New(`required flag "di-endpoint" not set, unless using "replay"`)
+	}
 
-	if bErr := hermine.BackupBelegManagerSqLiteDatabaseFile(absolutePathOfBelegManagerSqLiteDB); bErr != nil {
-		return bErr
+	switch diAuthCliArgument {
+	case diAuthKey:
+		if diKeyCliArgument == "" {
+			return errors.New(`required flag "di-key" not set, unless using "replay" or "di-auth" "` + diAuthEntraID + `"`)
+		}
+	case diAuthEntraID:
+		if entraIDConfigCliArgument.TenantID == "" || entraIDConfigCliArgument.ClientID == "" {
+			return errors.New(`flags "di-entra-id-tenant-id" and "di-entra-id-client-id" are required for Entra ID`)
+		}
+		if (entraIDConfigCliArgument.ClientSecret == "") == (entraIDCertificateCliArgument == "") {
+			return errors.New(`either flag "di-entra-id-client-secret" or "di-entra-id-certificate" is required for Entra ID`)
+		}
+		if entraIDCertificateCliArgument != "" {
+			certificate, loadErr := hermine.LoadEntraIDCertificate(entraIDCertificateCliArgument)
+			if loadErr != nil {
+				return loadErr
+			}
+			entraIDConfigCliArgument.Certificate = certificate
+		}
+	default:
+		return fmt.Errorf(`unknown "di-auth" '%s', expected "%s" or "%s"`, diAuthCliArgument, diAuthKey, diAuthEntraID)
 	}
 
-	sqLiteDB := hermine.StartBelegManagerSQLiteDB(absolutePathOfBelegManagerSqLiteDB)
-	defer hermine.CloseDB(sqLiteDB)
+	return nil
+}
+
+func run(cmd *cobra.Command, _ []string) error {
+	initLogging(logLevelCliArgument)
+
+	ctx, cancel := newRunContext(cmd.Context())
+	defer cancel()
+
+	env, envErr := openImportEnvironment()
+	if envErr != nil {
+		return envErr
+	}
+	defer env.close()
 
 	filesToImport, globErr := doublestar.FilepathGlob(filesToImportGlobCliArgument)
 	if globErr != nil {
@@ -48,20 +225,144 @@ func run(_ *cobra.Command, _ []string) error {
 			Errorf("Failed to get files to import from glob pattern")
 		return globErr
 	}
+	filesToImport = slices.DeleteFunc(filesToImport, hermine.IsAnalysisSidecarFile)
 	log.WithField("glob_pattern", filesToImportGlobCliArgument).
 		Debugf("Found %d file(s) for glob pattern", len(filesToImport))
 
-	belegManagerDirectory, err := os.Open(belegManagerDirectoryCliArgument)
-	if err != nil {
-		log.Panicf("Failed to open file: %v", err)
+	hermine.ProcessFiles(ctx, env.db, env.analyzer, env.belegManagerDirectory, filesToImport, newImportOptions())
+
+	return nil
+}
+
+// importEnvironment holds everything needed for importing, shared by the root and the "watch" command.
+type importEnvironment struct {
+	db                    *sqlx.DB
+	belegManagerDirectory *os.File
+	analyzer              hermine.DocumentAnalyzer
+}
+
+// openImportEnvironment opens and locks the BelegManager database, backs it up, unless in a dry run, and creates the
+// analyzer.
+func openImportEnvironment() (*importEnvironment, error) {
+	analyzer, analyzerErr := newDocumentAnalyzer()
+	if analyzerErr != nil {
+		return nil, analyzerErr
+	}
+
+	sqLiteDB, openDBErr := openBelegManagerDB()
+	if openDBErr != nil {
+		return nil, openDBErr
+	}
+
+	if dryRunCliArgument {
+		log.Info("Dry run, neither the BelegManager database nor its data directory will be changed")
+	} else if bErr := hermine.BackupBelegManagerSqLiteDatabaseFile(absolutePathOfBelegManagerSqLiteDB, backupDirectoryCliArgument); bErr != nil {
+		hermine.CloseDB(sqLiteDB)
+		return nil, bErr
+	}
+
+	belegManagerDirectory, openErr := os.Open(belegManagerDirectoryCliArgument)
+	if openErr != nil {
+		log.WithError(openErr).Errorf("Failed to open %s", belegManagerDirectoryCliArgument)
+		hermine.CloseDB(sqLiteDB)
+		return nil, openErr
+	}
+
+	return &importEnvironment{db: sqLiteDB, belegManagerDirectory: belegManagerDirectory, analyzer: analyzer}, nil
+}
+
+// openBelegManagerDB opens and locks the BelegManager database, logging why it cannot be used.
+func openBelegManagerDB() (*sqlx.DB, error) {
+	options := databaseCliArgument
+	options.Lock.DryRun = dryRunCliArgument
+	db, openErr := hermine.OpenBelegManagerDB(absolutePathOfBelegManagerSqLiteDB, options)
+	switch {
+	case errors.Is(openErr, hermine.ErrDatabaseLocked):
+		log.WithError(openErr).Error("Database in use, close BelegManager or use --db-lock-wait or --force")
+	case errors.Is(openErr, hermine.ErrUnsupportedSchemaVersion) && !databaseCliArgument.AllowUntestedSchema:
+		log.WithError(openErr).Errorf("Tested BelegManager database versions: %v, use --allow-untested-schema to try another one",
+			hermine.TestedSchemaVersions())
+	case openErr != nil:
+		log.WithError(openErr).Error("Failed to open the BelegManager database")
+	}
+
+	return db, openErr
+}
+
+func (e *importEnvironment) close() {
+	if closeDirErr := e.belegManagerDirectory.Close(); closeDirErr != nil {
+		log.WithError(closeDirErr).Debugf("Failed to close: %v", e.belegManagerDirectory)
+	}
+	hermine.CloseDB(e.db)
+}
+
+func newImportOptions() hermine.ImportOptions {
+	return hermine.ImportOptions{
+		DryRun:              dryRunCliArgument,
+		DryRunReportPath:    dryRunReportCliArgument,
+		AnalysisConcurrency: concurrencyCliArgument,
+		ImportConcurrency:   dbConcurrencyCliArgument,
+		DocumentTimeout:     documentTimeoutCliArgument,
+		DuplicatePolicy:     duplicatePolicyCliArgument,
+		FieldMappings:       fieldMappingsConfiguration,
+		AfterImport:         afterImportOptions,
+		Steuerfall:          steuerfallConfiguration,
+		Customer:            customerConfiguration,
+		Labels:              labelOptionsCliArgument,
+		Rules:               rulesConfiguration,
+		Vendor:              vendorConfiguration,
+	}
+}
+
+func newDocumentAnalyzer() (hermine.DocumentAnalyzer, error) {
+	if replayCliArgument {
+		log.Info("Replaying saved analysis results, Azure is not called")
+		return hermine.NewReplayDocumentAnalyzer(), nil
 	}
-	defer func() {
-		if closeDirErr := belegManagerDirectory.Close(); closeDirErr != nil {
-			log.WithError(closeDirErr).Debugf("Failed to close: %v", belegManagerDirectory)
+
+	if analysisCachePruneOlderThanCliArgument > 0 {
+		if _, pruneErr := hermine.PruneAnalysisCache(analysisCacheDirectoryCliArgument, analysisCachePruneOlderThanCliArgument); pruneErr != nil {
+			return nil, pruneErr
 		}
+	}
+
+	var entraIDConfig *hermine.EntraIDConfig
+	if diAuthCliArgument == diAuthEntraID {
+		entraIDConfig = &entraIDConfigCliArgument
+	}
+	azureAnalyzer := hermine.NewAzureDocumentAnalyzer(hermine.AzureDocumentAnalyzerConfig{
+		Endpoint:    diEndpointCliArgument,
+		Key:         diKeyCliArgument,
+		EntraID:     entraIDConfig,
+		Model:       diModelCliArgument,
+		ModelGlobs:  modelGlobsCliArgument,
+		RetryPolicy: retryPolicyCliArgument,
+	})
+	cachingAnalyzer, cacheErr :=
+		hermine.NewCachingDocumentAnalyzer(azureAnalyzer, analysisCacheDirectoryCliArgument, analysisCacheModeCliArgument)
+	if cacheErr != nil {
+		return nil, cacheErr
+	}
+
+	return cachingAnalyzer, nil
+}
+
+// newRunContext returns a context, which is done on Ctrl+C, SIGTERM or when "timeout" is exceeded.
+// After the first signal, default signal handling is restored, so a second Ctrl+C aborts immediately.
+func newRunContext(parent context.Context) (context.Context, context.CancelFunc) {
+	signalCtx, stopSignalHandling := signal.NotifyContext(parent, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signalCtx.Done()
+		stopSignalHandling()
 	}()
 
-	hermine.ProcessFiles(sqLiteDB, diEndpointCliArgument, diKeyCliArgument, belegManagerDirectory, filesToImport)
+	if timeoutCliArgument <= 0 {
+		return signalCtx, stopSignalHandling
+	}
 
-	return nil
+	timeoutCtx, cancelTimeout := context.WithTimeout(signalCtx, timeoutCliArgument)
+	return timeoutCtx, func() {
+		cancelTimeout()
+		stopSignalHandling()
+	}
 }
@@ -0,0 +1,60 @@
+package cli
+
+import (
+	"github.com/SchulteMarkus/sse-belmngr-hermine/hermine"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var undoCommand = &cobra.Command{
+	Use:   "undo <run-id>",
+	Short: "Reverts all changes of an import run",
+	Long: "Reverts all changes of an import run, as recorded in its journal '_import-journal-<run-id>.jsonl' in " +
+		"--beleg-manager-data-directory: Belege, assets, links and categories created are deleted, updated Belege get " +
+		"their previous values back, and copied files are removed. Changes made in BelegManager since are kept, " +
+		"except for links to deleted Belege. Use --dry-run to preview the changes.",
+	Args: cobra.ExactArgs(1),
+	PreRunE: func(_ *cobra.Command, _ []string) error {
+		return validateBelegManagerDatabase()
+	},
+	RunE: runUndo,
+}
+
+func init() {
+	Command.AddCommand(undoCommand)
+}
+
+func runUndo(_ *cobra.Command, args []string) error {
+	initLogging(logLevelCliArgument)
+
+	db, openDBErr := openBelegManagerDB()
+	if openDBErr != nil {
+		return openDBErr
+	}
+	defer hermine.CloseDB(db)
+
+	if dryRunCliArgument {
+		log.Info("Dry run, neither the BelegManager database nor its data directory will be changed")
+	} else if bErr := hermine.BackupBelegManagerSqLiteDatabaseFile(absolutePathOfBelegManagerSqLiteDB, backupDirectoryCliArgument); bErr != nil {
+		return bErr
+	}
+
+	belegManagerDirectory, openErr := os.Open(belegManagerDirectoryCliArgument)
+	if openErr != nil {
+		log.WithError(openErr).Errorf("Failed to open %s", belegManagerDirectoryCliArgument)
+		return openErr
+	}
+	defer func() {
+		if closeErr := belegManagerDirectory.Close(); closeErr != nil {
+			log.WithError(closeErr).Debugf("Failed to close: %v", belegManagerDirectory)
+		}
+	}()
+
+	if undoErr := hermine.UndoRun(db, belegManagerDirectory, args[0], dryRunCliArgument); undoErr != nil {
+		log.Error(undoErr)
+		return undoErr
+	}
+
+	return nil
+}
@@ -0,0 +1,64 @@
+package cli
+
+import (
+	"github.com/SchulteMarkus/sse-belmngr-hermine/hermine"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var watchOptionsCliArgument hermine.WatchOptions
+
+var watchCommand = &cobra.Command{
+	Use:   "watch",
+	Short: "Watches the directories behind --files-to-import-glob and imports new files as they appear",
+	Long: "Watches the directories behind --files-to-import-glob and imports new or changed files, " +
+		"once they have not changed for --watch-debounce. Files existing when starting are not imported. " +
+		"Stops on Ctrl+C, finishing running imports and writing the CSV log.",
+	Args:    cobra.NoArgs,
+	PreRunE: validateCliArguments,
+	RunE:    runWatch,
+}
+
+func init() {
+	defaultWatchOptions := hermine.DefaultWatchOptions()
+	flags := watchCommand.Flags()
+
+	flags.DurationVar(
+		&watchOptionsCliArgument.Debounce,
+		"watch-debounce",
+		defaultWatchOptions.Debounce,
+		"Duration a file has to stay unchanged before it is imported",
+	)
+	flags.DurationVar(
+		&watchOptionsCliArgument.LogRotationInterval,
+		"watch-log-rotation",
+		defaultWatchOptions.LogRotationInterval,
+		"Interval of writing a new CSV log (0 writes a single CSV log on shutdown)",
+	)
+	flagConfigKeys["watch-debounce"] = "watch.debounce"
+	flagConfigKeys["watch-log-rotation"] = "watch.log-rotation"
+
+	Command.AddCommand(watchCommand)
+}
+
+func runWatch(cmd *cobra.Command, _ []string) error {
+	initLogging(logLevelCliArgument)
+
+	ctx, cancel := newRunContext(cmd.Context())
+	defer cancel()
+
+	env, envErr := openImportEnvironment()
+	if envErr != nil {
+		return envErr
+	}
+	defer env.close()
+
+	watchErr := hermine.WatchFiles(
+		ctx, env.db, env.analyzer, env.belegManagerDirectory, filesToImportGlobCliArgument, newImportOptions(), watchOptionsCliArgument)
+	if watchErr != nil {
+		return watchErr
+	}
+
+	log.Info("Stopped watching")
+	return nil
+}
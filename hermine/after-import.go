@@ -0,0 +1,188 @@
+package hermine
+
+import (
+	"errors"
+	"fmt"
+	"github.com/bmatcuk/doublestar/v4"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// After-import policies decide what happens to a file to import, once its documents are imported or failed to.
+const (
+	// AfterImportKeep leaves files where they are, the default.
+	AfterImportKeep = "keep"
+	// AfterImportMove moves imported files into the processed directory, mirroring the source tree.
+	AfterImportMove = "move"
+	// AfterImportMoveFailed moves files, which failed to be imported, into the quarantine directory.
+	AfterImportMoveFailed = "move-failed"
+	// AfterImportDelete deletes imported files.
+	AfterImportDelete = "delete"
+)
+
+func ValidAfterImportPolicies() []string {
+	return []string{AfterImportKeep, AfterImportMove, AfterImportMoveFailed, AfterImportDelete}
+}
+
+// AfterImportOptions configures the handling of files to import, once processed. The zero value keeps all files.
+type AfterImportOptions struct {
+	// Imported is AfterImportKeep, AfterImportMove or AfterImportDelete.
+	Imported string
+	// MoveFailed moves files, which failed to be imported, into QuarantineDirectory.
+	MoveFailed bool
+	// SourceDirectory is the base directory of the files to import, moved files keep their path relative to it.
+	SourceDirectory     string
+	ProcessedDirectory  string
+	QuarantineDirectory string
+}
+
+// NewAfterImportOptions combines after-import policies, e.g. "move" and "move-failed". Empty directories default to
+// siblings of the base directory of filesToImportGlob, suffixed "-processed" and "-failed".
+func NewAfterImportOptions(policies []string, filesToImportGlob, processedDirectory, quarantineDirectory string) (AfterImportOptions, error) {
+	options := AfterImportOptions{Imported: AfterImportKeep}
+	for _, policy := range policies {
+		switch policy {
+		case AfterImportKeep:
+		case AfterImportMove, AfterImportDelete:
+			if options.Imported != AfterImportKeep && options.Imported != policy {
+				return options, fmt.Errorf("after-import policies '%s' and '%s' exclude each other", options.Imported, policy)
+			}
+			options.Imported = policy
+		case AfterImportMoveFailed:
+			options.MoveFailed = true
+		default:
+			return options, fmt.Errorf("unknown after-import policy '%s', expected one of %s",
+				policy, strings.Join(ValidAfterImportPolicies(), ", "))
+		}
+	}
+	if slices.Contains(policies, AfterImportKeep) && (options.Imported != AfterImportKeep || options.MoveFailed) {
+		return options, errors.New("after-import policy 'keep' cannot be combined with other policies")
+	}
+
+	baseDirectory, _ := doublestar.SplitPattern(filepath.ToSlash(filesToImportGlob))
+	sourceDirectory, absErr := filepath.Abs(filepath.FromSlash(baseDirectory))
+	if absErr != nil {
+		return options, absErr
+	}
+	options.SourceDirectory = sourceDirectory
+	if processedDirectory == "" {
+		processedDirectory = sourceDirectory + "-processed"
+	}
+	if quarantineDirectory == "" {
+		quarantineDirectory = sourceDirectory + "-failed"
+	}
+
+	var err error
+	if options.ProcessedDirectory, err = outsideOfDirectory(processedDirectory, sourceDirectory); err != nil {
+		return options, err
+	}
+	if options.QuarantineDirectory, err = outsideOfDirectory(quarantineDirectory, sourceDirectory); err != nil {
+		return options, err
+	}
+
+	return options, nil
+}
+
+// outsideOfDirectory returns the absolute path of directory, if it is not inside parent. Otherwise, moved files would
+// be imported again.
+func outsideOfDirectory(directory, parent string) (string, error) {
+	absoluteDirectory, absErr := filepath.Abs(directory)
+	if absErr != nil {
+		return "", absErr
+	}
+	if relativePath, relErr := filepath.Rel(parent, absoluteDirectory); relErr == nil && filepath.IsLocal(relativePath) {
+		return "", fmt.Errorf("'%s' must not be inside the directory of the files to import '%s'", directory, parent)
+	}
+
+	return absoluteDirectory, nil
+}
+
+// handleProcessedFile applies the after-import policy to a file, whose documents have been imported or failed to,
+// i.e. after the transactions of its documents have been committed. Its analysis sidecar file is handled alike.
+// Returns a description of what happened to the file, empty if it was kept.
+func (o AfterImportOptions) handleProcessedFile(logger *log.Entry, pathOfFileToImport string, imported bool) string {
+	var action, targetDirectory string
+	switch {
+	case imported && o.Imported == AfterImportDelete:
+		action = "deleted"
+	case imported && o.Imported == AfterImportMove:
+		action, targetDirectory = "moved", o.ProcessedDirectory
+	case !imported && o.MoveFailed:
+		action, targetDirectory = "quarantined", o.QuarantineDirectory
+	default:
+		return ""
+	}
+
+	sidecarFilePath := AnalysisSidecarFilePath(pathOfFileToImport)
+	_, sidecarStatErr := os.Stat(sidecarFilePath)
+	hasSidecarFile := sidecarStatErr == nil
+
+	if targetDirectory == "" {
+		if removeErr := os.Remove(pathOfFileToImport); removeErr != nil {
+			logger.WithError(removeErr).Warnf("Failed to delete imported file %s", pathOfFileToImport)
+			return ""
+		}
+		if hasSidecarFile {
+			if removeErr := os.Remove(sidecarFilePath); removeErr != nil {
+				logger.WithError(removeErr).Warnf("Failed to delete analysis sidecar file %s", sidecarFilePath)
+			}
+		}
+		logger.Infof("Deleted imported file %s", pathOfFileToImport)
+		return action
+	}
+
+	targetPath, moveErr := o.moveIntoDirectory(logger, pathOfFileToImport, targetDirectory)
+	if moveErr != nil {
+		logger.WithError(moveErr).Warnf("Failed to move %s into %s", pathOfFileToImport, targetDirectory)
+		return ""
+	}
+	if hasSidecarFile {
+		if sidecarMoveErr := moveFile(logger, sidecarFilePath, AnalysisSidecarFilePath(targetPath)); sidecarMoveErr != nil {
+			logger.WithError(sidecarMoveErr).Warnf("Failed to move analysis sidecar file %s", sidecarFilePath)
+		}
+	}
+	logger.Infof("Moved %s to %s", pathOfFileToImport, targetPath)
+
+	return fmt.Sprintf("%s: %s", action, targetPath)
+}
+
+// moveIntoDirectory moves a file into the directory, keeping its path relative to the source directory. Existing
+// files are not overwritten, but the moved file gets a time suffix.
+func (o AfterImportOptions) moveIntoDirectory(logger *log.Entry, filePath, directory string) (string, error) {
+	relativePath := filepath.Base(filePath)
+	if absoluteFilePath, absErr := filepath.Abs(filePath); absErr == nil {
+		if rel, relErr := filepath.Rel(o.SourceDirectory, absoluteFilePath); relErr == nil && filepath.IsLocal(rel) {
+			relativePath = rel
+		}
+	}
+
+	targetDirectory := filepath.Join(directory, filepath.Dir(relativePath))
+	if mkdirErr := os.MkdirAll(targetDirectory, 0o755); mkdirErr != nil {
+		return "", mkdirErr
+	}
+	targetFileName, targetErr := targetFileNameInDirectory(filePath, targetDirectory)
+	if targetErr != nil {
+		return "", targetErr
+	}
+	targetPath := filepath.Join(targetDirectory, targetFileName)
+
+	return targetPath, moveFile(logger, filePath, targetPath)
+}
+
+// moveFile renames a file, or copies and removes it, if renaming fails, e.g. across file systems.
+func moveFile(logger *log.Entry, filePath, targetPath string) error {
+	renameErr := os.Rename(filePath, targetPath)
+	if renameErr == nil {
+		return nil
+	}
+
+	logger.WithError(renameErr).Debugf("Failed to rename %s, copying it", filePath)
+	if copyErr := copyFileToTargetIfTargetDoesNotExist(logger, filePath, targetPath); copyErr != nil {
+		return copyErr
+	}
+
+	return os.Remove(filePath)
+}
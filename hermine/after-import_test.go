@@ -0,0 +1,160 @@
+package hermine
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_NewAfterImportOptions(t *testing.T) {
+	t.Parallel()
+
+	sourceDirectory := filepath.Join(t.TempDir(), "BelegManager-Import")
+	glob := filepath.Join(sourceDirectory, "**", "*.pdf")
+
+	tests := []struct {
+		name               string
+		policies           []string
+		processedDirectory string
+		wantImported       string
+		wantMoveFailed     bool
+		wantErr            bool
+	}{
+		{name: "default", policies: nil, wantImported: AfterImportKeep},
+		{name: "keep", policies: []string{AfterImportKeep}, wantImported: AfterImportKeep},
+		{name: "move", policies: []string{AfterImportMove}, wantImported: AfterImportMove},
+		{name: "delete", policies: []string{AfterImportDelete}, wantImported: AfterImportDelete},
+		{name: "move failed only", policies: []string{AfterImportMoveFailed}, wantImported: AfterImportKeep, wantMoveFailed: true},
+		{name: "move and move failed", policies: []string{AfterImportMove, AfterImportMoveFailed}, wantImported: AfterImportMove, wantMoveFailed: true},
+		{name: "move and delete", policies: []string{AfterImportMove, AfterImportDelete}, wantErr: true},
+		{name: "keep and move", policies: []string{AfterImportKeep, AfterImportMove}, wantErr: true},
+		{name: "unknown", policies: []string{"archive"}, wantErr: true},
+		{
+			name:               "processed directory inside import directory",
+			policies:           []string{AfterImportMove},
+			processedDirectory: filepath.Join(sourceDirectory, "done"),
+			wantErr:            true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			// when
+			options, err := NewAfterImportOptions(tt.policies, glob, tt.processedDirectory, "")
+
+			// then
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantImported, options.Imported)
+			assert.Equal(t, tt.wantMoveFailed, options.MoveFailed)
+			assert.Equal(t, sourceDirectory, options.SourceDirectory)
+			assert.Equal(t, sourceDirectory+"-processed", options.ProcessedDirectory)
+			assert.Equal(t, sourceDirectory+"-failed", options.QuarantineDirectory)
+		})
+	}
+}
+
+func Test_AfterImportOptions_handleProcessedFile(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name                 string
+		policies             []string
+		imported             bool
+		wantSourceRemains    bool
+		wantInDirectoryNamed string
+	}{
+		{name: "keep imported", policies: []string{AfterImportKeep}, imported: true, wantSourceRemains: true},
+		{name: "move imported", policies: []string{AfterImportMove}, imported: true, wantInDirectoryNamed: "processed"},
+		{name: "move keeps failed", policies: []string{AfterImportMove}, imported: false, wantSourceRemains: true},
+		{name: "quarantine failed", policies: []string{AfterImportMoveFailed}, imported: false, wantInDirectoryNamed: "failed"},
+		{name: "quarantine keeps imported", policies: []string{AfterImportMoveFailed}, imported: true, wantSourceRemains: true},
+		{name: "delete imported", policies: []string{AfterImportDelete}, imported: true},
+		{name: "delete keeps failed", policies: []string{AfterImportDelete}, imported: false, wantSourceRemains: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			// given
+			testLogger, _ := newDebuggingNullLogger(t)
+			testLoggerEntry := testLogger.WithField("test", t.Name())
+
+			tempDir := t.TempDir()
+			sourceDirectory := filepath.Join(tempDir, "import")
+			require.NoError(t, os.MkdirAll(filepath.Join(sourceDirectory, "2024"), 0o755))
+			fileToImport := filepath.Join(sourceDirectory, "2024", "invoice.pdf")
+			require.NoError(t, os.WriteFile(fileToImport, []byte("invoice"), 0o600))
+			require.NoError(t, os.WriteFile(AnalysisSidecarFilePath(fileToImport), []byte("{}"), 0o600))
+
+			options, optionsErr := NewAfterImportOptions(
+				tt.policies,
+				filepath.Join(sourceDirectory, "**", "*.pdf"),
+				filepath.Join(tempDir, "processed"),
+				filepath.Join(tempDir, "failed"),
+			)
+			require.NoError(t, optionsErr)
+
+			// when
+			afterImport := options.handleProcessedFile(testLoggerEntry, fileToImport, tt.imported)
+
+			// then
+			if tt.wantSourceRemains {
+				assert.FileExists(t, fileToImport)
+				assert.FileExists(t, AnalysisSidecarFilePath(fileToImport))
+				assert.Empty(t, afterImport)
+			} else {
+				assert.NoFileExists(t, fileToImport)
+				assert.NoFileExists(t, AnalysisSidecarFilePath(fileToImport))
+				assert.NotEmpty(t, afterImport)
+			}
+
+			if tt.wantInDirectoryNamed != "" {
+				movedFile := filepath.Join(tempDir, tt.wantInDirectoryNamed, "2024", "invoice.pdf")
+				assert.FileExists(t, movedFile)
+				assert.FileExists(t, AnalysisSidecarFilePath(movedFile))
+				assert.Contains(t, afterImport, movedFile)
+			}
+		})
+	}
+}
+
+func Test_AfterImportOptions_handleProcessedFile_targetAlreadyPresent(t *testing.T) {
+	t.Parallel()
+
+	// given
+	testLogger, _ := newDebuggingNullLogger(t)
+	testLoggerEntry := testLogger.WithField("test", t.Name())
+
+	tempDir := t.TempDir()
+	sourceDirectory := filepath.Join(tempDir, "import")
+	require.NoError(t, os.MkdirAll(sourceDirectory, 0o755))
+	fileToImport := filepath.Join(sourceDirectory, "invoice.pdf")
+	require.NoError(t, os.WriteFile(fileToImport, []byte("new"), 0o600))
+
+	options, optionsErr := NewAfterImportOptions([]string{AfterImportMove}, filepath.Join(sourceDirectory, "*.pdf"), "", "")
+	require.NoError(t, optionsErr)
+	require.NoError(t, os.MkdirAll(options.ProcessedDirectory, 0o755))
+	existingFile := filepath.Join(options.ProcessedDirectory, "invoice.pdf")
+	require.NoError(t, os.WriteFile(existingFile, []byte("old"), 0o600))
+
+	// when
+	afterImport := options.handleProcessedFile(testLoggerEntry, fileToImport, true)
+
+	// then
+	assert.NoFileExists(t, fileToImport)
+	existingContent, readErr := os.ReadFile(existingFile)
+	require.NoError(t, readErr)
+	assert.Equal(t, "old", string(existingContent))
+
+	dirEntries, readDirErr := os.ReadDir(options.ProcessedDirectory)
+	require.NoError(t, readDirErr)
+	assert.Len(t, dirEntries, 2)
+	assert.NotEmpty(t, afterImport)
+}
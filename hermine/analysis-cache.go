@@ -0,0 +1,195 @@
+package hermine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	AnalysisCacheModeUse     = "use"
+	AnalysisCacheModeBypass  = "bypass"
+	AnalysisCacheModeRefresh = "refresh"
+
+	analysisCacheFileEnding = ".json"
+)
+
+// analysisModelIdentifier is implemented by DocumentAnalyzers, whose results depend on a DI model.
+type analysisModelIdentifier interface {
+	modelID(pathOfFileToImport string) string
+}
+
+// CachingDocumentAnalyzer wraps a DocumentAnalyzer and stores its results in a directory, keyed by
+// the SHA-256 of the analyzed file, the DI model ID and the DI API version.
+type CachingDocumentAnalyzer struct {
+	analyzer  DocumentAnalyzer
+	directory string
+	mode      string
+}
+
+func NewCachingDocumentAnalyzer(analyzer DocumentAnalyzer, directory, mode string) (*CachingDocumentAnalyzer, error) {
+	switch mode {
+	case AnalysisCacheModeUse, AnalysisCacheModeBypass, AnalysisCacheModeRefresh:
+	default:
+		return nil, fmt.Errorf("unknown analysis cache mode '%s'", mode)
+	}
+
+	if mode != AnalysisCacheModeBypass {
+		if mkdirErr := os.MkdirAll(directory, 0o700); mkdirErr != nil {
+			log.WithError(mkdirErr).Warnf("Failed to create analysis cache directory %s", directory)
+			return nil, mkdirErr
+		}
+	}
+
+	return &CachingDocumentAnalyzer{analyzer: analyzer, directory: directory, mode: mode}, nil
+}
+
+func (c *CachingDocumentAnalyzer) Analyze(ctx context.Context, logger *log.Entry, pathOfFileToImport string) (*diAnalyzeResult, error) {
+	if c.mode == AnalysisCacheModeBypass {
+		return c.analyzer.Analyze(ctx, logger, pathOfFileToImport)
+	}
+
+	cacheFilePath, cacheKeyErr := c.cacheFilePath(pathOfFileToImport)
+	if cacheKeyErr != nil {
+		logger.WithError(cacheKeyErr).Warn("Failed to compute analysis cache key, not using cache")
+		return c.analyzer.Analyze(ctx, logger, pathOfFileToImport)
+	}
+	cacheLogger := logger.WithField("analysis_cache_file", cacheFilePath)
+
+	if c.mode == AnalysisCacheModeUse {
+		if cachedResult := readCachedAnalysis(cacheLogger, cacheFilePath); cachedResult != nil {
+			cacheLogger.Debug("Using cached analysis result")
+			return cachedResult, nil
+		}
+	}
+
+	result, analysisErr := c.analyzer.Analyze(ctx, logger, pathOfFileToImport)
+	if analysisErr != nil {
+		return nil, analysisErr
+	}
+	writeCachedAnalysis(cacheLogger, cacheFilePath, result)
+
+	return result, nil
+}
+
+func (c *CachingDocumentAnalyzer) modelID(pathOfFileToImport string) string {
+	if mi, ok := c.analyzer.(analysisModelIdentifier); ok {
+		return mi.modelID(pathOfFileToImport)
+	}
+
+	return DIModelInvoice
+}
+
+func (c *CachingDocumentAnalyzer) cacheFilePath(pathOfFileToImport string) (string, error) {
+	fileHash, hashErr := fileSHA256(pathOfFileToImport)
+	if hashErr != nil {
+		return "", hashErr
+	}
+
+	cacheFileName := fmt.Sprintf("%s_%s_%s%s", fileHash, c.modelID(pathOfFileToImport), diAPIVersion, analysisCacheFileEnding)
+	return filepath.Join(c.directory, cacheFileName), nil
+}
+
+func readCachedAnalysis(logger *log.Entry, cacheFilePath string) *diAnalyzeResult {
+	content, readErr := os.ReadFile(cacheFilePath)
+	if os.IsNotExist(readErr) {
+		logger.Trace("No cached analysis result")
+		return nil
+	} else if readErr != nil {
+		logger.WithError(readErr).Warn("Failed to read cached analysis result")
+		return nil
+	}
+
+	var analysisStatus diAnalysisStatus
+	if unmarshalErr := json.Unmarshal(content, &analysisStatus); unmarshalErr != nil {
+		logger.WithError(unmarshalErr).Warn("Failed to parse cached analysis result, ignoring it")
+		return nil
+	}
+	if !analysisStatus.isStatusSucceeded() || analysisStatus.AnalyzeResult == nil {
+		logger.Warnf("Cached analysis has status '%s' without result, ignoring it", analysisStatus.Status)
+		return nil
+	}
+
+	// Used entries are touched, so pruning removes entries which have not been used for a long time
+	now := time.Now()
+	if touchErr := os.Chtimes(cacheFilePath, now, now); touchErr != nil {
+		logger.WithError(touchErr).Debug("Failed to touch cached analysis result")
+	}
+
+	return analysisStatus.AnalyzeResult
+}
+
+// writeCachedAnalysis stores the response of the Document Intelligence API the result has been parsed from verbatim,
+// so fields not known to Hermine are kept. Results not received from the API are not cached.
+func writeCachedAnalysis(logger *log.Entry, cacheFilePath string, result *diAnalyzeResult) {
+	content := result.rawAnalysisStatus
+	if len(content) == 0 {
+		logger.Debug("Analysis result without API response, not caching it")
+		return
+	}
+
+	// Writing to a temporary file first, so concurrent readers never see partial entries. Its name is unique, as
+	// concurrent imports of files with identical content share the cache file.
+	tmpFile, createErr := os.CreateTemp(filepath.Dir(cacheFilePath), "*.tmp")
+	if createErr != nil {
+		logger.WithError(createErr).Warn("Failed to write analysis result to cache")
+		return
+	}
+	_, writeErr := tmpFile.Write(content)
+	if closeErr := tmpFile.Close(); writeErr == nil {
+		writeErr = closeErr
+	}
+	if writeErr != nil {
+		logger.WithError(writeErr).Warn("Failed to write analysis result to cache")
+		_ = os.Remove(tmpFile.Name())
+		return
+	}
+	if renameErr := os.Rename(tmpFile.Name(), cacheFilePath); renameErr != nil {
+		_ = os.Remove(tmpFile.Name())
+		logger.WithError(renameErr).Warn("Failed to write analysis result to cache")
+		return
+	}
+
+	logger.Debug("Stored analysis result in cache")
+}
+
+// PruneAnalysisCache removes cached analysis results, which have not been used for longer than maxAge.
+func PruneAnalysisCache(directory string, maxAge time.Duration) (int, error) {
+	dirEntries, readDirErr := os.ReadDir(directory)
+	if os.IsNotExist(readDirErr) {
+		return 0, nil
+	} else if readDirErr != nil {
+		log.WithError(readDirErr).Warnf("Failed to read analysis cache directory %s", directory)
+		return 0, readDirErr
+	}
+
+	pruned := 0
+	oldestToKeep := time.Now().Add(-maxAge)
+	for _, dirEntry := range dirEntries {
+		if dirEntry.IsDir() || !strings.HasSuffix(dirEntry.Name(), analysisCacheFileEnding) {
+			continue
+		}
+		cacheFilePath := filepath.Join(directory, dirEntry.Name())
+		fileInfo, infoErr := dirEntry.Info()
+		if infoErr != nil {
+			log.WithError(infoErr).Debugf("Failed to stat %s", cacheFilePath)
+			continue
+		}
+		if fileInfo.ModTime().After(oldestToKeep) {
+			continue
+		}
+		if removeErr := os.Remove(cacheFilePath); removeErr != nil {
+			log.WithError(removeErr).Warnf("Failed to prune %s", cacheFilePath)
+			continue
+		}
+		pruned++
+	}
+
+	log.WithField("analysis_cache_directory", directory).Debugf("Pruned %d cached analysis result(s)", pruned)
+	return pruned, nil
+}
@@ -0,0 +1,129 @@
+package hermine
+
+import (
+	"context"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type countingDocumentAnalyzer struct {
+	result *diAnalyzeResult
+	calls  atomic.Int32
+}
+
+func (a *countingDocumentAnalyzer) Analyze(_ context.Context, _ *log.Entry, _ string) (*diAnalyzeResult, error) {
+	a.calls.Add(1)
+	return a.result, nil
+}
+
+func Test_CachingDocumentAnalyzer_Analyze(t *testing.T) {
+	tests := []struct {
+		name                  string
+		mode                  string
+		expectedCalls         int32
+		expectedCacheFileSize int
+	}{
+		{name: "Use", mode: AnalysisCacheModeUse, expectedCalls: 1, expectedCacheFileSize: 1},
+		{name: "Refresh", mode: AnalysisCacheModeRefresh, expectedCalls: 2, expectedCacheFileSize: 1},
+		{name: "Bypass", mode: AnalysisCacheModeBypass, expectedCalls: 2, expectedCacheFileSize: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// given
+			testLogger, _ := newDebuggingNullLogger(t)
+			testLoggerEntry := testLogger.WithField("test", t.Name())
+
+			invoiceFilePath, diAr := getDiResultFixture(t)
+			rawAnalysisStatus, readFixtureErr := os.ReadFile(filepath.Join(testDataDirectoryName, documentAnalysisExampleResultFileName))
+			require.NoError(t, readFixtureErr)
+			diAr.AnalyzeResult.rawAnalysisStatus = rawAnalysisStatus
+			inner := &countingDocumentAnalyzer{result: diAr.AnalyzeResult}
+			cacheDirectory := t.TempDir()
+			analyzer, newErr := NewCachingDocumentAnalyzer(inner, cacheDirectory, tt.mode)
+			require.NoError(t, newErr)
+
+			// when
+			firstResult, firstErr := analyzer.Analyze(context.Background(), testLoggerEntry, invoiceFilePath)
+			secondResult, secondErr := analyzer.Analyze(context.Background(), testLoggerEntry, invoiceFilePath)
+
+			// then
+			require.NoError(t, firstErr)
+			require.NoError(t, secondErr)
+			assert.Equal(t, tt.expectedCalls, inner.calls.Load())
+			assert.Equal(t, firstResult.Content, secondResult.Content)
+			require.Len(t, secondResult.Documents, 1)
+			assert.Equal(t, "654123", secondResult.Documents[0].Fields["InvoiceId"].Content)
+
+			dirEntries, readDirErr := os.ReadDir(cacheDirectory)
+			require.NoError(t, readDirErr)
+			require.Len(t, dirEntries, tt.expectedCacheFileSize)
+			for _, dirEntry := range dirEntries {
+				cachedContent, readCacheErr := os.ReadFile(filepath.Join(cacheDirectory, dirEntry.Name()))
+				require.NoError(t, readCacheErr)
+				assert.Equal(t, rawAnalysisStatus, cachedContent, "the API response is cached verbatim")
+			}
+		})
+	}
+}
+
+func Test_CachingDocumentAnalyzer_Analyze_withoutAPIResponse(t *testing.T) {
+	// given
+	testLoggerEntry := newDummyLogEntry(t)
+	invoiceFilePath, diAr := getDiResultFixture(t)
+	cacheDirectory := t.TempDir()
+	analyzer, newErr := NewCachingDocumentAnalyzer(&countingDocumentAnalyzer{result: diAr.AnalyzeResult}, cacheDirectory, AnalysisCacheModeUse)
+	require.NoError(t, newErr)
+
+	// when
+	result, analysisErr := analyzer.Analyze(context.Background(), testLoggerEntry, invoiceFilePath)
+
+	// then
+	require.NoError(t, analysisErr)
+	assert.NotNil(t, result)
+	dirEntries, readDirErr := os.ReadDir(cacheDirectory)
+	require.NoError(t, readDirErr)
+	assert.Empty(t, dirEntries, "no status is made up for results not received from the API")
+}
+
+func Test_NewCachingDocumentAnalyzer_unknownMode(t *testing.T) {
+	_, newErr := NewCachingDocumentAnalyzer(&countingDocumentAnalyzer{}, t.TempDir(), "sometimes")
+	require.Error(t, newErr)
+}
+
+func Test_PruneAnalysisCache(t *testing.T) {
+	// given
+	cacheDirectory := t.TempDir()
+	oldEntry := filepath.Join(cacheDirectory, "old"+analysisCacheFileEnding)
+	newEntry := filepath.Join(cacheDirectory, "new"+analysisCacheFileEnding)
+	unrelatedFile := filepath.Join(cacheDirectory, "unrelated.txt")
+	for _, p := range []string{oldEntry, newEntry, unrelatedFile} {
+		require.NoError(t, os.WriteFile(p, []byte("{}"), 0o600))
+	}
+	twoDaysAgo := time.Now().Add(-48 * time.Hour)
+	require.NoError(t, os.Chtimes(oldEntry, twoDaysAgo, twoDaysAgo))
+	require.NoError(t, os.Chtimes(unrelatedFile, twoDaysAgo, twoDaysAgo))
+
+	// when
+	pruned, pruneErr := PruneAnalysisCache(cacheDirectory, 24*time.Hour)
+
+	// then
+	require.NoError(t, pruneErr)
+	assert.Equal(t, 1, pruned)
+	assert.NoFileExists(t, oldEntry)
+	assert.FileExists(t, newEntry)
+	assert.FileExists(t, unrelatedFile)
+}
+
+func Test_PruneAnalysisCache_missingDirectory(t *testing.T) {
+	pruned, pruneErr := PruneAnalysisCache(filepath.Join(t.TempDir(), "missing"), time.Hour)
+
+	require.NoError(t, pruneErr)
+	assert.Zero(t, pruned)
+}
@@ -0,0 +1,58 @@
+package hermine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"strings"
+)
+
+// AnalysisSidecarFileEnding is appended to a document's path to get its saved analysis, e.g. "invoice.pdf.di.json".
+const AnalysisSidecarFileEnding = ".di.json"
+
+// ReplayDocumentAnalyzer is a DocumentAnalyzer reading saved analysis results from sidecar files, instead of
+// analyzing documents. A sidecar file contains the JSON returned by the Document Intelligence "Get Analyze Result" API.
+type ReplayDocumentAnalyzer struct{}
+
+func NewReplayDocumentAnalyzer() *ReplayDocumentAnalyzer {
+	return &ReplayDocumentAnalyzer{}
+}
+
+func (a *ReplayDocumentAnalyzer) Analyze(ctx context.Context, logger *log.Entry, pathOfFileToImport string) (*diAnalyzeResult, error) {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, ctxErr
+	}
+
+	sidecarFilePath := AnalysisSidecarFilePath(pathOfFileToImport)
+	sidecarLogger := logger.WithField("analysis_sidecar_file", sidecarFilePath)
+
+	content, readErr := os.ReadFile(sidecarFilePath)
+	if readErr != nil {
+		sidecarLogger.WithError(readErr).Warn("Failed to read saved analysis")
+		return nil, readErr
+	}
+
+	var analysisStatus diAnalysisStatus
+	if unmarshalErr := json.Unmarshal(content, &analysisStatus); unmarshalErr != nil {
+		sidecarLogger.WithError(unmarshalErr).Warn("Failed to parse saved analysis into structured JSON")
+		return nil, unmarshalErr
+	}
+	if !analysisStatus.isStatusSucceeded() || analysisStatus.AnalyzeResult == nil {
+		noResultErr := fmt.Errorf("saved analysis has status '%s' without result", analysisStatus.Status)
+		sidecarLogger.WithError(noResultErr).Warn()
+		return nil, noResultErr
+	}
+	sidecarLogger.Debugf("Replaying saved analysis, contains %d document(s)", len(analysisStatus.AnalyzeResult.Documents))
+
+	return analysisStatus.AnalyzeResult, nil
+}
+
+func AnalysisSidecarFilePath(pathOfFileToImport string) string {
+	return pathOfFileToImport + AnalysisSidecarFileEnding
+}
+
+func IsAnalysisSidecarFile(path string) bool {
+	return strings.HasSuffix(path, AnalysisSidecarFileEnding)
+}
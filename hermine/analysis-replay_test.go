@@ -0,0 +1,62 @@
+package hermine
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_ReplayDocumentAnalyzer_Analyze(t *testing.T) {
+	// given
+	testLogger, _ := newDebuggingNullLogger(t)
+	testLoggerEntry := testLogger.WithField("test", t.Name())
+
+	pathOfFileToImport := filepath.Join(t.TempDir(), invoiceExampleFileName)
+	sidecarContent, readErr := os.ReadFile(filepath.Join(testDataDirectoryName, documentAnalysisExampleResultFileName))
+	require.NoError(t, readErr)
+	require.NoError(t, os.WriteFile(AnalysisSidecarFilePath(pathOfFileToImport), sidecarContent, 0o600))
+
+	// when
+	diAr, analysisErr := NewReplayDocumentAnalyzer().Analyze(context.Background(), testLoggerEntry, pathOfFileToImport)
+
+	// then
+	require.NoError(t, analysisErr)
+	require.Len(t, diAr.Documents, 1)
+	assert.Equal(t, "654123", diAr.Documents[0].Fields["InvoiceId"].Content)
+}
+
+func Test_ReplayDocumentAnalyzer_Analyze_invalidSidecar(t *testing.T) {
+	tests := []struct {
+		name           string
+		sidecarContent *string
+	}{
+		{name: "Missing sidecar"},
+		{name: "Invalid JSON", sidecarContent: func() *string { s := "{"; return &s }()},
+		{name: "Analysis not succeeded", sidecarContent: func() *string { s := `{"status": "failed"}`; return &s }()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testLogger, _ := newDebuggingNullLogger(t)
+			testLoggerEntry := testLogger.WithField("test", t.Name())
+
+			pathOfFileToImport := filepath.Join(t.TempDir(), "document.pdf")
+			if tt.sidecarContent != nil {
+				require.NoError(t, os.WriteFile(AnalysisSidecarFilePath(pathOfFileToImport), []byte(*tt.sidecarContent), 0o600))
+			}
+
+			diAr, analysisErr := NewReplayDocumentAnalyzer().Analyze(context.Background(), testLoggerEntry, pathOfFileToImport)
+
+			require.Error(t, analysisErr)
+			assert.Nil(t, diAr)
+		})
+	}
+}
+
+func Test_IsAnalysisSidecarFile(t *testing.T) {
+	assert.True(t, IsAnalysisSidecarFile("invoice.pdf.di.json"))
+	assert.False(t, IsAnalysisSidecarFile("invoice.pdf"))
+}
@@ -0,0 +1,98 @@
+package hermine
+
+import (
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const selectActiveBmDocAssetsQuery = "SELECT " + bmDocAssetColumns + " FROM BmDoc_Asset WHERE internalPath IS NOT NULL AND (deleteState IS NULL OR deleteState = 0)"
+
+// assetFingerprintIndex maps the SHA-256 fingerprints of the asset files in the BelegManager data directory to the
+// internal paths of their assets. It is built once by newImportRun, before any transaction begins, as hashing a large
+// data directory takes long, and shared by all files of a run.
+type assetFingerprintIndex struct {
+	mu            sync.Mutex
+	internalPaths map[string]string
+	// buildErr is the error building the index, returned by findAsset
+	buildErr error
+}
+
+// newAssetFingerprintIndex builds the index of the asset files in the data directory.
+func newAssetFingerprintIndex(logger *log.Entry, q sqlxSelecter, belegManagerDirectory string) *assetFingerprintIndex {
+	i := &assetFingerprintIndex{}
+	i.buildErr = i.build(logger, q, belegManagerDirectory)
+
+	return i
+}
+
+// findAsset returns the active asset whose file has the fingerprint, or nil.
+func (i *assetFingerprintIndex) findAsset(logger *log.Entry, q sqlxSelecter, fingerprint string) (*bmDocAsset, error) {
+	if i.buildErr != nil {
+		return nil, i.buildErr
+	}
+	i.mu.Lock()
+	internalPath, indexed := i.internalPaths[fingerprint]
+	i.mu.Unlock()
+	if !indexed {
+		return nil, nil
+	}
+
+	// The asset of an indexed path may be missing, e.g. as its transaction was rolled back
+	bmDocAssets := make([]*bmDocAsset, 0)
+	if selectAssetErr := q.Select(&bmDocAssets, selectBmDocAssetByInternalPathQuery, internalPath); selectAssetErr != nil {
+		logger.WithError(selectAssetErr).Warnf("Error when searching BmDoc_Asset-internalPath: %s", internalPath)
+		return nil, selectAssetErr
+	}
+	for _, asset := range bmDocAssets {
+		if !asset.isDeleted() {
+			logger.
+				WithField("asset_id", asset.ID).
+				WithField("asset_internal_path", internalPath).
+				Debug("Found asset with equal content")
+			return asset, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// add indexes the file of a new asset.
+func (i *assetFingerprintIndex) add(fingerprint, internalPath string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.internalPaths != nil {
+		i.internalPaths[fingerprint] = internalPath
+	}
+}
+
+func (i *assetFingerprintIndex) build(logger *log.Entry, q sqlxSelecter, belegManagerDirectory string) error {
+	bmDocAssets := make([]*bmDocAsset, 0)
+	if selectAssetsErr := q.Select(&bmDocAssets, selectActiveBmDocAssetsQuery); selectAssetsErr != nil {
+		logger.WithError(selectAssetsErr).Warn("Error when searching BmDoc_Assets")
+		return selectAssetsErr
+	}
+
+	i.internalPaths = make(map[string]string, len(bmDocAssets))
+	for _, asset := range bmDocAssets {
+		assetFilePath := filepath.Join(belegManagerDirectory, *asset.InternalPath)
+		fingerprint, hashErr := fileSHA256(assetFilePath)
+		if os.IsNotExist(hashErr) {
+			logger.WithField("asset_id", asset.ID).Debugf("File of asset does not exist: %s", assetFilePath)
+			continue
+		} else if hashErr != nil {
+			logger.WithField("asset_id", asset.ID).WithError(hashErr).Warnf("Failed to fingerprint %s", assetFilePath)
+			continue
+		}
+
+		// Keep the first asset of files with equal content
+		if _, indexed := i.internalPaths[fingerprint]; !indexed {
+			i.internalPaths[fingerprint] = *asset.InternalPath
+		}
+	}
+	logger.Debugf("Fingerprinted %d asset file(s)", len(i.internalPaths))
+
+	return nil
+}
@@ -0,0 +1,362 @@
+package hermine
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+)
+
+// backupFileInfix separates the database name and the timestamp in backup file names,
+// e.g. "BelegManager-schultedevbackup-20250127095523.db4".
+const backupFileInfix = "-schultedevbackup-"
+
+// belegManagerProcessName is the name of the BelegManager process, which must not run while restoring a backup.
+const belegManagerProcessName = "BelegManager"
+
+// Backup is a timestamped copy of the BelegManager database.
+type Backup struct {
+	// ID is the timestamp of the backup as in its file name, e.g. "20250127095523".
+	ID   string
+	Path string
+	Time time.Time
+	Size int64
+	// SchemaVersion is the BelegManager database version, 0 if it could not be read.
+	SchemaVersion int
+}
+
+// BackupRetentionPolicy decides which backups PruneBackups keeps. A backup is kept if any of the rules keeps it.
+type BackupRetentionPolicy struct {
+	// KeepLast keeps the newest backups.
+	KeepLast int
+	// KeepDaily keeps the newest backup of each of the last days having backups.
+	KeepDaily int
+	// KeepWeekly keeps the newest backup of each of the last ISO weeks having backups.
+	KeepWeekly int
+}
+
+func (p BackupRetentionPolicy) keepsNothing() bool {
+	return p.KeepLast <= 0 && p.KeepDaily <= 0 && p.KeepWeekly <= 0
+}
+
+// ErrInvalidBackup is returned if a backup fails the SQLite integrity check.
+var ErrInvalidBackup = errors.New("backup failed the database integrity check")
+
+// backupBusyTimeout is the time to wait for other processes writing the database, before backing it up.
+const backupBusyTimeout = 30 * time.Second
+
+// BackupBelegManagerSqLiteDatabaseFile backs up the database into backupDirectory, next to the database if empty.
+// The backup is created by SQLite using "VACUUM INTO", so it is consistent even if another process is writing the
+// database meanwhile. Backups failing "PRAGMA integrity_check" are removed, and ErrInvalidBackup is returned.
+func BackupBelegManagerSqLiteDatabaseFile(dbFilePath, backupDirectory string) error {
+	backupDirectory = backupDirectoryOrDefault(dbFilePath, backupDirectory)
+	if mkdirErr := os.MkdirAll(backupDirectory, 0o755); mkdirErr != nil {
+		log.WithError(mkdirErr).Errorf("Failed to create backup directory %s", backupDirectory)
+		return mkdirErr
+	}
+
+	backupPath := filepath.Join(backupDirectory, backupFileName(dbFilePath, time.Now().Format(flatDateTime)))
+	backupLogger := log.WithField("db_file", dbFilePath).WithField("backup", backupPath)
+	if vacuumErr := vacuumInto(dbFilePath, backupPath); vacuumErr != nil {
+		backupLogger.WithError(vacuumErr).Error("Failed to back up the BelegManager database")
+		return vacuumErr
+	}
+
+	if integrityErr := checkDatabaseIntegrity(backupPath); integrityErr != nil {
+		backupLogger.WithError(integrityErr).Error("Backup of the BelegManager database is not valid, removing it")
+		if removeErr := os.Remove(backupPath); removeErr != nil {
+			backupLogger.WithError(removeErr).Warn("Failed to remove invalid backup")
+		}
+		return integrityErr
+	}
+
+	backupLogger.Debug("Backed up and verified database")
+	return nil
+}
+
+// vacuumInto writes a consistent copy of the database into a new file, waiting for running writes to finish.
+func vacuumInto(dbFilePath, backupPath string) error {
+	dsn := fmt.Sprintf("file:%s?mode=ro&_pragma=busy_timeout(%d)", dbFilePath, backupBusyTimeout.Milliseconds())
+	db, openErr := sqlx.Open("sqlite", dsn)
+	if openErr != nil {
+		return openErr
+	}
+	defer CloseDB(db)
+
+	_, vacuumErr := db.Exec("VACUUM INTO ?", backupPath)
+	return vacuumErr
+}
+
+// checkDatabaseIntegrity runs "PRAGMA integrity_check", wrapping ErrInvalidBackup with the problems found, if any.
+func checkDatabaseIntegrity(dbFilePath string) error {
+	db, openErr := sqlx.Open("sqlite", fmt.Sprintf("file:%s?mode=ro", dbFilePath))
+	if openErr != nil {
+		return openErr
+	}
+	defer CloseDB(db)
+
+	var problems []string
+	if checkErr := db.Select(&problems, "PRAGMA integrity_check"); checkErr != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidBackup, checkErr)
+	}
+	if len(problems) != 1 || problems[0] != "ok" {
+		return fmt.Errorf("%w: %s", ErrInvalidBackup, strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+// copyBelegManagerSqLiteDatabaseFile backs up the database by copying its file, which is consistent only if no other
+// process uses the database. Unlike BackupBelegManagerSqLiteDatabaseFile, it copies damaged databases as well.
+func copyBelegManagerSqLiteDatabaseFile(dbFilePath, backupDirectory string) error {
+	backupPath := filepath.Join(
+		backupDirectoryOrDefault(dbFilePath, backupDirectory), backupFileName(dbFilePath, time.Now().Format(flatDateTime)))
+	dummyLogEntry := log.WithField("dummy", true)
+
+	return copyFileToTargetIfTargetDoesNotExist(dummyLogEntry, dbFilePath, backupPath)
+}
+
+func backupDirectoryOrDefault(dbFilePath, backupDirectory string) string {
+	if backupDirectory == "" {
+		return filepath.Dir(dbFilePath)
+	}
+
+	return backupDirectory
+}
+
+func backupFileName(dbFilePath, id string) string {
+	dbFileBaseName := strings.TrimSuffix(filepath.Base(dbFilePath), BelMngrSqLiteDatabaseFileEnding)
+	return dbFileBaseName + backupFileInfix + id + BelMngrSqLiteDatabaseFileEnding
+}
+
+// ListBackups returns the backups of the database in backupDirectory, newest first.
+func ListBackups(dbFilePath, backupDirectory string) ([]Backup, error) {
+	backupDirectory = backupDirectoryOrDefault(dbFilePath, backupDirectory)
+	dirEntries, readDirErr := os.ReadDir(backupDirectory)
+	if os.IsNotExist(readDirErr) {
+		return nil, nil
+	} else if readDirErr != nil {
+		return nil, readDirErr
+	}
+
+	dbFileBaseName := strings.TrimSuffix(filepath.Base(dbFilePath), BelMngrSqLiteDatabaseFileEnding)
+	backupFileNamePattern := regexp.MustCompile(
+		"^" + regexp.QuoteMeta(dbFileBaseName+backupFileInfix) + `(\d{14})` + regexp.QuoteMeta(BelMngrSqLiteDatabaseFileEnding) + "$")
+	backups := make([]Backup, 0)
+	for _, dirEntry := range dirEntries {
+		match := backupFileNamePattern.FindStringSubmatch(dirEntry.Name())
+		if dirEntry.IsDir() || match == nil {
+			continue
+		}
+		backupTime, parseErr := time.ParseInLocation(flatDateTime, match[1], time.Local)
+		if parseErr != nil {
+			continue
+		}
+		info, infoErr := dirEntry.Info()
+		if infoErr != nil {
+			return nil, infoErr
+		}
+
+		backupPath := filepath.Join(backupDirectory, dirEntry.Name())
+		backups = append(backups, Backup{
+			ID:            match[1],
+			Path:          backupPath,
+			Time:          backupTime,
+			Size:          info.Size(),
+			SchemaVersion: readSchemaVersion(backupPath),
+		})
+	}
+	slices.SortFunc(backups, func(a, b Backup) int {
+		return b.Time.Compare(a.Time)
+	})
+
+	return backups, nil
+}
+
+// readSchemaVersion returns the BelegManager database version of the file, or 0 if it cannot be read.
+func readSchemaVersion(dbFilePath string) int {
+	dbFileLogger := log.WithField("db_file", dbFilePath)
+	db, openErr := sqlx.Open("sqlite", fmt.Sprintf("file:%s?mode=ro", dbFilePath))
+	if openErr != nil {
+		dbFileLogger.WithError(openErr).Debug("Failed to open database")
+		return 0
+	}
+	defer CloseDB(db)
+
+	var version int
+	if queryErr := db.QueryRow(sseBelMngrDBVersionQuery).Scan(&version); queryErr != nil {
+		dbFileLogger.WithError(queryErr).Debug("Failed to read database version")
+		return 0
+	}
+
+	return version
+}
+
+// RestoreBackup replaces the database by the backup with the ID. The database must not be in use, see
+// DatabaseLockOptions, and the current database is backed up first, so restoring can be undone by restoring that
+// backup.
+func RestoreBackup(dbFilePath, backupDirectory, id string, lockOptions DatabaseLockOptions) error {
+	backups, listErr := ListBackups(dbFilePath, backupDirectory)
+	if listErr != nil {
+		return listErr
+	}
+	backupIndex := slices.IndexFunc(backups, func(b Backup) bool {
+		return b.ID == id
+	})
+	if backupIndex < 0 {
+		return fmt.Errorf("no backup '%s' found in %s", id, backupDirectoryOrDefault(dbFilePath, backupDirectory))
+	}
+	backup := backups[backupIndex]
+	if backup.SchemaVersion == 0 {
+		return fmt.Errorf("backup %s is not a readable BelegManager database", backup.Path)
+	}
+	if integrityErr := checkDatabaseIntegrity(backup.Path); integrityErr != nil {
+		return integrityErr
+	}
+
+	// Like importing, restoring requires the database not to be in use, e.g. by BelegManager or a running watch command
+	db, openErr := sqlx.Open("sqlite", fmt.Sprintf("file:%s?mode=rw", dbFilePath))
+	if openErr != nil {
+		return openErr
+	}
+	defer CloseDB(db)
+	lockOptions.DryRun, lockOptions.strictProcessCheck = false, true
+	if lockErr := lockBelegManagerDB(db, dbFilePath, lockOptions); lockErr != nil {
+		return lockErr
+	}
+	// Keeping the Hermine lock file, but not the connection, as open files cannot be replaced on Windows
+	if closeErr := db.Close(); closeErr != nil {
+		log.WithError(closeErr).Debug("Failed to close database")
+	}
+
+	// Changes not yet written into the database file would be applied onto the restored database
+	for _, suffix := range []string{"-journal", "-wal"} {
+		if _, statErr := os.Stat(dbFilePath + suffix); statErr == nil {
+			return fmt.Errorf("database has pending changes in %s, start and close BelegManager first", dbFilePath+suffix)
+		}
+	}
+
+	// BelegManager is closed, so copying the file is consistent, even if the database is damaged
+	if backupErr := copyBelegManagerSqLiteDatabaseFile(dbFilePath, backupDirectory); backupErr != nil {
+		log.WithError(backupErr).Error("Failed to back up the current database, not restoring")
+		return backupErr
+	}
+
+	restoringPath := dbFilePath + ".restoring"
+	dummyLogEntry := log.WithField("dummy", true)
+	if copyErr := copyFileToTargetIfTargetDoesNotExist(dummyLogEntry, backup.Path, restoringPath); copyErr != nil {
+		return copyErr
+	}
+	if renameErr := os.Rename(restoringPath, dbFilePath); renameErr != nil {
+		if removeErr := os.Remove(restoringPath); removeErr != nil {
+			log.WithError(removeErr).Debugf("Failed to remove %s", restoringPath)
+		}
+		return renameErr
+	}
+
+	log.WithField("backup", backup.Path).Infof("Restored backup %s from %s", backup.ID, backup.Time.Format(time.DateTime))
+	return nil
+}
+
+// checkBelegManagerNotRunning fails if a BelegManager process is found. If processes cannot be listed, it fails if
+// strict, else it only warns.
+func checkBelegManagerNotRunning(strict bool) error {
+	var listProcesses *exec.Cmd
+	if runtime.GOOS == "windows" {
+		listProcesses = exec.Command("tasklist", "/NH", "/FI", "IMAGENAME eq "+belegManagerProcessName+".exe")
+	} else {
+		listProcesses = exec.Command("pgrep", "-x", belegManagerProcessName)
+	}
+
+	output, runErr := listProcesses.Output()
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(runErr, &exitErr) && runtime.GOOS != "windows":
+		// pgrep exits with 1, if no process matches
+		return nil
+	case runErr != nil && strict:
+		return fmt.Errorf("%w: failed to check whether BelegManager is running: %w", ErrDatabaseLocked, runErr)
+	case runErr != nil:
+		log.WithError(runErr).Warn("Failed to check whether BelegManager is running, make sure it is closed")
+		return nil
+	case runtime.GOOS == "windows" && !strings.Contains(string(output), belegManagerProcessName):
+		return nil
+	}
+
+	return fmt.Errorf("%w: BelegManager is running, close it first", ErrDatabaseLocked)
+}
+
+// PruneBackups removes the backups not kept by the retention policy and returns them. In a dry run, nothing is
+// removed.
+func PruneBackups(dbFilePath, backupDirectory string, policy BackupRetentionPolicy, dryRun bool) ([]Backup, error) {
+	if policy.KeepLast < 0 || policy.KeepDaily < 0 || policy.KeepWeekly < 0 {
+		return nil, errors.New("retention policy must not keep a negative number of backups")
+	}
+	if policy.keepsNothing() {
+		return nil, errors.New("retention policy would remove all backups, keep at least one")
+	}
+
+	backups, listErr := ListBackups(dbFilePath, backupDirectory)
+	if listErr != nil {
+		return nil, listErr
+	}
+
+	pruned := make([]Backup, 0)
+	for i, keep := range backupsToKeep(backups, policy) {
+		if keep {
+			continue
+		}
+		pruned = append(pruned, backups[i])
+		if dryRun {
+			log.Infof("Dry run, would remove backup %s", backups[i].Path)
+			continue
+		}
+		if removeErr := os.Remove(backups[i].Path); removeErr != nil {
+			log.WithError(removeErr).Warnf("Failed to remove backup %s", backups[i].Path)
+			return pruned, removeErr
+		}
+		log.Debugf("Removed backup %s", backups[i].Path)
+	}
+
+	return pruned, nil
+}
+
+// backupsToKeep applies the retention policy to backups sorted newest first.
+func backupsToKeep(backups []Backup, policy BackupRetentionPolicy) []bool {
+	keep := make([]bool, len(backups))
+	for i := range min(policy.KeepLast, len(backups)) {
+		keep[i] = true
+	}
+
+	keepNewestPerPeriod := func(periods int, period func(time.Time) string) {
+		seen := make(map[string]bool)
+		for i, backup := range backups {
+			p := period(backup.Time)
+			if seen[p] {
+				continue
+			}
+			if len(seen) == periods {
+				return
+			}
+			seen[p] = true
+			keep[i] = true
+		}
+	}
+	keepNewestPerPeriod(policy.KeepDaily, func(t time.Time) string {
+		return t.Format(time.DateOnly)
+	})
+	keepNewestPerPeriod(policy.KeepWeekly, func(t time.Time) string {
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-%d", year, week)
+	})
+
+	return keep
+}
@@ -0,0 +1,308 @@
+package hermine
+
+import (
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_BackupBelegManagerSqLiteDatabaseFile_backupDirectory(t *testing.T) {
+	t.Parallel()
+
+	// given
+	dbFilePath := copyDatabaseFixtureIntoDirectory(t, t.TempDir())
+	backupDirectory := filepath.Join(t.TempDir(), "backups")
+
+	// when
+	backupErr := BackupBelegManagerSqLiteDatabaseFile(dbFilePath, backupDirectory)
+
+	// then
+	require.NoError(t, backupErr)
+	backups, listErr := ListBackups(dbFilePath, backupDirectory)
+	require.NoError(t, listErr)
+	require.Len(t, backups, 1)
+	assert.Equal(t, backupDirectory, filepath.Dir(backups[0].Path))
+	assert.Equal(t, belMngrSupportedDBVersion, backups[0].SchemaVersion)
+	assert.Positive(t, backups[0].Size)
+	assert.WithinDuration(t, time.Now(), backups[0].Time, time.Minute)
+
+	sameDirectoryBackups, listSameDirectoryErr := ListBackups(dbFilePath, "")
+	require.NoError(t, listSameDirectoryErr)
+	assert.Empty(t, sameDirectoryBackups)
+}
+
+func Test_BackupBelegManagerSqLiteDatabaseFile_whileWriting(t *testing.T) {
+	t.Parallel()
+
+	// given
+	dbFilePath := copyDatabaseFixtureIntoDirectory(t, t.TempDir())
+	db := sqlx.MustOpen("sqlite", "file:"+dbFilePath)
+	t.Cleanup(func() {
+		CloseDB(db)
+	})
+	tx, beginErr := db.Beginx()
+	require.NoError(t, beginErr)
+	t.Cleanup(func() {
+		_ = tx.Rollback()
+	})
+	_, insertErr := tx.Exec("INSERT INTO BmDoc_Kategorie (uuid, name, docType) VALUES ('{uncommitted}', 'Uncommitted', 1)")
+	require.NoError(t, insertErr)
+
+	// when
+	backupErr := BackupBelegManagerSqLiteDatabaseFile(dbFilePath, "")
+
+	// then
+	require.NoError(t, backupErr)
+	backups, listErr := ListBackups(dbFilePath, "")
+	require.NoError(t, listErr)
+	require.Len(t, backups, 1)
+	require.NoError(t, checkDatabaseIntegrity(backups[0].Path))
+
+	backupDB := sqlx.MustOpen("sqlite", "file:"+backups[0].Path+"?mode=ro")
+	t.Cleanup(func() {
+		CloseDB(backupDB)
+	})
+	var uncommittedCategories int
+	require.NoError(t, backupDB.Get(&uncommittedCategories, "SELECT COUNT(*) FROM BmDoc_Kategorie WHERE uuid = '{uncommitted}'"))
+	assert.Zero(t, uncommittedCategories)
+}
+
+func Test_BackupBelegManagerSqLiteDatabaseFile_noDatabase(t *testing.T) {
+	t.Parallel()
+
+	// given
+	directory := t.TempDir()
+	dbFilePath := filepath.Join(directory, BelMngrSqLiteDatabaseFileName)
+	require.NoError(t, os.WriteFile(dbFilePath, []byte("no database"), 0o600))
+
+	// when
+	backupErr := BackupBelegManagerSqLiteDatabaseFile(dbFilePath, "")
+
+	// then
+	require.Error(t, backupErr)
+	backups, listErr := ListBackups(dbFilePath, "")
+	require.NoError(t, listErr)
+	assert.Empty(t, backups)
+}
+
+func Test_checkDatabaseIntegrity_damaged(t *testing.T) {
+	t.Parallel()
+
+	// given
+	dbFilePath := copyDatabaseFixtureIntoDirectory(t, t.TempDir())
+	content, readErr := os.ReadFile(dbFilePath)
+	require.NoError(t, readErr)
+	// Overwrite all pages but the first one, keeping the header valid
+	pageSize := int(content[16])<<8 | int(content[17])
+	for i := pageSize; i < len(content); i++ {
+		content[i] = 0xA5
+	}
+	require.NoError(t, os.WriteFile(dbFilePath, content, 0o600))
+
+	// when
+	integrityErr := checkDatabaseIntegrity(dbFilePath)
+
+	// then
+	assert.ErrorIs(t, integrityErr, ErrInvalidBackup)
+}
+
+func Test_ListBackups(t *testing.T) {
+	t.Parallel()
+
+	// given
+	directory := t.TempDir()
+	dbFilePath := copyDatabaseFixtureIntoDirectory(t, directory)
+	for _, fileName := range []string{
+		"BelegManager-schultedevbackup-20250101100000.db4",
+		"BelegManager-schultedevbackup-20250301100000.db4",
+		"BelegManager-schultedevbackup-invalid.db4",
+		"Other-schultedevbackup-20250201100000.db4",
+	} {
+		require.NoError(t, os.WriteFile(filepath.Join(directory, fileName), []byte("no database"), 0o600))
+	}
+
+	// when
+	backups, listErr := ListBackups(dbFilePath, "")
+
+	// then
+	require.NoError(t, listErr)
+	require.Len(t, backups, 2)
+	assert.Equal(t, "20250301100000", backups[0].ID, "newest first")
+	assert.Equal(t, "20250101100000", backups[1].ID)
+	assert.Zero(t, backups[0].SchemaVersion, "not a database")
+}
+
+func Test_RestoreBackup(t *testing.T) {
+	t.Parallel()
+
+	// given
+	directory := t.TempDir()
+	dbFilePath := copyDatabaseFixtureIntoDirectory(t, directory)
+	backupPath := filepath.Join(directory, "BelegManager-schultedevbackup-20250101100000.db4")
+	dummyLogEntry := newDummyLogEntry(t)
+	require.NoError(t, copyFileToTargetIfTargetDoesNotExist(dummyLogEntry, dbFilePath, backupPath))
+	require.NoError(t, os.WriteFile(dbFilePath, []byte("damaged"), 0o600))
+
+	// when
+	restoreErr := RestoreBackup(dbFilePath, "", "20250101100000", DatabaseLockOptions{})
+
+	// then
+	require.NoError(t, restoreErr)
+	assert.Equal(t, belMngrSupportedDBVersion, readSchemaVersion(dbFilePath))
+
+	backups, listErr := ListBackups(dbFilePath, "")
+	require.NoError(t, listErr)
+	require.Len(t, backups, 2, "safety copy of the replaced database")
+	safetyCopy, readErr := os.ReadFile(backups[0].Path)
+	require.NoError(t, readErr)
+	assert.Equal(t, "damaged", string(safetyCopy))
+}
+
+func Test_RestoreBackup_unknownOrInvalid(t *testing.T) {
+	t.Parallel()
+
+	// given
+	directory := t.TempDir()
+	dbFilePath := copyDatabaseFixtureIntoDirectory(t, directory)
+	invalidBackupPath := filepath.Join(directory, "BelegManager-schultedevbackup-20250101100000.db4")
+	require.NoError(t, os.WriteFile(invalidBackupPath, []byte("no database"), 0o600))
+
+	// when, then
+	assert.Error(t, RestoreBackup(dbFilePath, "", "20240101100000", DatabaseLockOptions{}))
+	assert.Error(t, RestoreBackup(dbFilePath, "", "20250101100000", DatabaseLockOptions{}))
+	assert.Equal(t, belMngrSupportedDBVersion, readSchemaVersion(dbFilePath))
+}
+
+func Test_RestoreBackup_databaseInUse(t *testing.T) {
+	t.Parallel()
+
+	// given
+	directory := t.TempDir()
+	dbFilePath := copyDatabaseFixtureIntoDirectory(t, directory)
+	backupPath := filepath.Join(directory, "BelegManager-schultedevbackup-20250101100000.db4")
+	require.NoError(t, copyFileToTargetIfTargetDoesNotExist(newDummyLogEntry(t), dbFilePath, backupPath))
+	otherDB := openPlainDatabase(t, dbFilePath)
+	require.NoError(t, lockBelegManagerDB(otherDB, dbFilePath, DatabaseLockOptions{}))
+
+	// when
+	restoreErr := RestoreBackup(dbFilePath, "", "20250101100000", DatabaseLockOptions{})
+	forcedRestoreErr := RestoreBackup(dbFilePath, "", "20250101100000", DatabaseLockOptions{Force: true})
+
+	// then
+	require.ErrorIs(t, restoreErr, ErrDatabaseLocked)
+	require.NoError(t, forcedRestoreErr)
+	backups, listErr := ListBackups(dbFilePath, "")
+	require.NoError(t, listErr)
+	assert.Len(t, backups, 2, "only the forced restore replaced the database")
+}
+
+// Not parallel, as it changes PATH
+func Test_RestoreBackup_processCheckFailed(t *testing.T) {
+	// given
+	directory := t.TempDir()
+	dbFilePath := copyDatabaseFixtureIntoDirectory(t, directory)
+	backupPath := filepath.Join(directory, "BelegManager-schultedevbackup-20250101100000.db4")
+	require.NoError(t, copyFileToTargetIfTargetDoesNotExist(newDummyLogEntry(t), dbFilePath, backupPath))
+	t.Setenv("PATH", t.TempDir())
+
+	// when
+	restoreErr := RestoreBackup(dbFilePath, "", "20250101100000", DatabaseLockOptions{})
+	forcedRestoreErr := RestoreBackup(dbFilePath, "", "20250101100000", DatabaseLockOptions{Force: true})
+
+	// then
+	require.ErrorIs(t, restoreErr, ErrDatabaseLocked)
+	require.NoError(t, forcedRestoreErr)
+}
+
+func Test_backupsToKeep(t *testing.T) {
+	t.Parallel()
+
+	backupAt := func(dateTime string) Backup {
+		backupTime, parseErr := time.ParseInLocation(time.DateTime, dateTime, time.Local)
+		require.NoError(t, parseErr)
+		return Backup{Time: backupTime}
+	}
+	// Newest first, 2025-03-03 is a Monday
+	backups := []Backup{
+		backupAt("2025-03-04 18:00:00"),
+		backupAt("2025-03-04 08:00:00"),
+		backupAt("2025-03-03 08:00:00"),
+		backupAt("2025-03-02 08:00:00"),
+		backupAt("2025-02-20 08:00:00"),
+		backupAt("2025-01-01 08:00:00"),
+	}
+
+	tests := []struct {
+		name   string
+		policy BackupRetentionPolicy
+		want   []bool
+	}{
+		{name: "keep last", policy: BackupRetentionPolicy{KeepLast: 2}, want: []bool{true, true, false, false, false, false}},
+		{name: "keep daily", policy: BackupRetentionPolicy{KeepDaily: 3}, want: []bool{true, false, true, true, false, false}},
+		{name: "keep weekly", policy: BackupRetentionPolicy{KeepWeekly: 3}, want: []bool{true, false, false, true, true, false}},
+		{name: "combined", policy: BackupRetentionPolicy{KeepLast: 1, KeepDaily: 1, KeepWeekly: 4}, want: []bool{true, false, false, true, true, true}},
+		{name: "more than available", policy: BackupRetentionPolicy{KeepLast: 10}, want: []bool{true, true, true, true, true, true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tt.want, backupsToKeep(backups, tt.policy))
+		})
+	}
+}
+
+func Test_PruneBackups(t *testing.T) {
+	t.Parallel()
+
+	// given
+	directory := t.TempDir()
+	dbFilePath := copyDatabaseFixtureIntoDirectory(t, directory)
+	for _, id := range []string{"20250101100000", "20250102100000", "20250103100000"} {
+		require.NoError(t, os.WriteFile(filepath.Join(directory, backupFileName(dbFilePath, id)), nil, 0o600))
+	}
+
+	// when
+	_, keepNothingErr := PruneBackups(dbFilePath, "", BackupRetentionPolicy{}, false)
+	_, negativeErr := PruneBackups(dbFilePath, "", BackupRetentionPolicy{KeepLast: 1, KeepDaily: -1}, false)
+	dryRunPruned, dryRunErr := PruneBackups(dbFilePath, "", BackupRetentionPolicy{KeepLast: 1}, true)
+	pruned, pruneErr := PruneBackups(dbFilePath, "", BackupRetentionPolicy{KeepLast: 1}, false)
+
+	// then
+	assert.Error(t, keepNothingErr)
+	assert.Error(t, negativeErr)
+	require.NoError(t, dryRunErr)
+	assert.Len(t, dryRunPruned, 2)
+	require.NoError(t, pruneErr)
+	assert.Len(t, pruned, 2)
+
+	backups, listErr := ListBackups(dbFilePath, "")
+	require.NoError(t, listErr)
+	require.Len(t, backups, 1)
+	assert.Equal(t, "20250103100000", backups[0].ID)
+	assert.FileExists(t, dbFilePath)
+}
+
+// copyDatabaseFixtureIntoDirectory copies the empty BelegManager database into the directory, named like the original.
+func copyDatabaseFixtureIntoDirectory(t *testing.T, directory string) string {
+	t.Helper()
+
+	dbFilePath := filepath.Join(directory, BelMngrSqLiteDatabaseFileName)
+	copyErr := copyFileToTargetIfTargetDoesNotExist(
+		newDummyLogEntry(t), filepath.Join(testDataDirectoryName, belMngrEmptySqLiteDatabaseFileName), dbFilePath)
+	require.NoError(t, copyErr)
+
+	return dbFilePath
+}
+
+func newDummyLogEntry(t *testing.T) *log.Entry {
+	t.Helper()
+
+	testLogger, _ := newDebuggingNullLogger(t)
+	return testLogger.WithField("test", t.Name())
+}
@@ -2,83 +2,201 @@ package hermine
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 	log "github.com/sirupsen/logrus"
 	_ "modernc.org/sqlite" // Imported for usage with sqlx, which relies on a database driver
+	"os"
+	"slices"
 	"strings"
-	"time"
+	"sync"
 )
 
 const (
 	BelMngrSqLiteDatabaseFileEnding = ".db4"
 	BelMngrSqLiteDatabaseFileName   = "BelegManager" + BelMngrSqLiteDatabaseFileEnding
-	belMngrSupportedDBVersion       = 22003
+	belMngrSupportedDBVersion       = 22003 // The newest version tested, see schemaAdapters
 	flatDateTime                    = "20060102150405"
-	sseBelMngrTablesExistQuery      = "SELECT name FROM sqlite_master WHERE type = 'table' AND name IN ('BmDoc_Asset', 'BmDoc_Beleg', 'BmDoc_Kategorie', 'BmDoc_LinkTable', 'BmDoc_Person','BmDoc_Steuerfall')"
+	sseBelMngrTablesQuery           = "SELECT name FROM sqlite_master WHERE type = 'table'"
 	sseBelMngrDBVersionQuery        = "SELECT propertyValue FROM Property WHERE propertyKey = 'databaseVersion'"
 )
 
-func StartBelegManagerSQLiteDB(dbFilePath string) *sqlx.DB {
-	db := mustOpenSqLiteDB(dbFilePath)
-	mustBeSupportedBelegManagerDBVersion(db)
-	mustHavePresupposedTables(db)
+var (
+	// ErrUnsupportedSchemaVersion is matched by a SchemaVersionError using errors.Is.
+	ErrUnsupportedSchemaVersion = errors.New("BelegManager database version not supported")
+	// ErrMissingTables is matched by a MissingTablesError using errors.Is.
+	ErrMissingTables = errors.New("SSE BelegManager tables not present")
+)
+
+// belMngrPresupposedTables are the tables Hermine reads or writes.
+var belMngrPresupposedTables = []string{
+	"BmDoc_Asset", "BmDoc_Beleg", "BmDoc_Kategorie", "BmDoc_Label", "BmDoc_LinkTable", "BmDoc_Person", "BmDoc_Steuerfall",
+}
+
+// SchemaVersionError reports a BelegManager database version Hermine does not support.
+type SchemaVersionError struct {
+	Found, Expected int
+}
+
+func (e *SchemaVersionError) Error() string {
+	return fmt.Sprintf("BelegManager database version %d not supported, supported: %d", e.Found, e.Expected)
+}
+
+func (e *SchemaVersionError) Is(target error) bool {
+	return target == ErrUnsupportedSchemaVersion
+}
+
+// MissingTablesError reports the tables missing in the BelegManager database.
+type MissingTablesError struct {
+	Tables []string
+}
+
+func (e *MissingTablesError) Error() string {
+	return fmt.Sprintf("SSE BelegManager tables not present: %s", strings.Join(e.Tables, ", "))
+}
+
+func (e *MissingTablesError) Is(target error) bool {
+	return target == ErrMissingTables
+}
+
+// DatabaseOptions decide how the BelegManager database is opened.
+type DatabaseOptions struct {
+	Lock DatabaseLockOptions
+	// AllowUntestedSchema allows database versions Hermine has not been tested with, if their columns fit the
+	// adapter of a tested version.
+	AllowUntestedSchema bool
+}
+
+// belegManagerDBState is the state of a database opened by OpenBelegManagerDB, dropped by CloseDB.
+type belegManagerDBState struct {
+	schema       *schemaAdapter
+	lockFilePath string
+}
+
+var belegManagerDBStates = struct {
+	sync.Mutex
+	states map[*sqlx.DB]*belegManagerDBState
+}{states: make(map[*sqlx.DB]*belegManagerDBState)}
+
+// OpenBelegManagerDB opens the database, checks it is a supported BelegManager database and locks it, see
+// DatabaseOptions. CloseDB releases the lock. The errors returned can be inspected using errors.Is and errors.As,
+// e.g. for ErrUnsupportedSchemaVersion, ErrMissingTables or ErrDatabaseLocked.
+func OpenBelegManagerDB(dbFilePath string, options DatabaseOptions) (*sqlx.DB, error) {
+	db, openErr := openSqLiteDB(dbFilePath)
+	if openErr != nil {
+		return nil, openErr
+	}
+
+	checkErr := checkPresupposedTables(db)
+	if checkErr == nil {
+		checkErr = selectBelegManagerSchema(db, options.AllowUntestedSchema)
+	}
+	if checkErr == nil {
+		checkErr = lockBelegManagerDB(db, dbFilePath, options.Lock)
+	}
+	if checkErr != nil {
+		CloseDB(db)
+		return nil, checkErr
+	}
 
-	return db
+	return db, nil
 }
 
-func mustOpenSqLiteDB(dbFilePath string) *sqlx.DB {
+func openSqLiteDB(dbFilePath string) (*sqlx.DB, error) {
 	dbFileLogger := log.WithField("db_file", dbFilePath)
 
+	// SQLite would create a missing database
+	if _, statErr := os.Stat(dbFilePath); statErr != nil {
+		return nil, statErr
+	}
+
 	dsn := fmt.Sprintf("file:%s?cache=shared", dbFilePath)
-	sqliteDB := sqlx.MustOpen("sqlite", dsn)
+	sqliteDB, openErr := sqlx.Open("sqlite", dsn)
+	if openErr != nil {
+		return nil, openErr
+	}
 	dbFileLogger.Debugf("Opened SQLite database")
 	if pingErr := sqliteDB.Ping(); pingErr != nil {
-		dbFileLogger.Panicf("Failed to connect to the database: %v", pingErr)
+		CloseDB(sqliteDB)
+		return nil, fmt.Errorf("failed to connect to the database %s: %w", dbFilePath, pingErr)
 	}
 
-	return sqliteDB
+	return sqliteDB, nil
 }
 
-func mustBeSupportedBelegManagerDBVersion(db *sqlx.DB) {
-	var v uint16
+// selectBelegManagerSchema selects the schemaAdapter for the version of the database.
+func selectBelegManagerSchema(db *sqlx.DB, allowUntested bool) error {
+	var v int
 	if queryErr := db.QueryRow(sseBelMngrDBVersionQuery).Scan(&v); queryErr != nil {
-		log.WithError(queryErr).Panic()
+		return fmt.Errorf("failed to read BelegManager database version: %w", queryErr)
 	}
 	log.Tracef("BelegManager database schema version: %d", v)
 
-	if v != belMngrSupportedDBVersion {
-		log.Panicf("BelegManager database version %d not supported, supported: %d", v, belMngrSupportedDBVersion)
+	adapter, selectErr := selectSchemaAdapter(db, v, allowUntested)
+	if selectErr != nil {
+		return selectErr
 	}
+	updateBelegManagerDBState(db, func(state *belegManagerDBState) {
+		state.schema = adapter
+	})
+	return nil
 }
 
-func mustHavePresupposedTables(db *sqlx.DB) {
-	var presupposedTables []string
-	queryErr := db.Select(&presupposedTables, sseBelMngrTablesExistQuery)
-	if queryErr != nil {
-		log.WithError(queryErr).Panic()
+func updateBelegManagerDBState(db *sqlx.DB, update func(state *belegManagerDBState)) {
+	belegManagerDBStates.Lock()
+	defer belegManagerDBStates.Unlock()
+
+	state, exists := belegManagerDBStates.states[db]
+	if !exists {
+		state = &belegManagerDBState{}
+		belegManagerDBStates.states[db] = state
 	}
-	if len(presupposedTables) != 6 {
-		log.Panicf("SSE BelegManager tables not present, there are: %s", presupposedTables)
+	update(state)
+}
+
+// schemaAdapterOf returns the adapter selected when opening the database, or the latest one for databases not opened
+// by OpenBelegManagerDB.
+func schemaAdapterOf(db *sqlx.DB) *schemaAdapter {
+	belegManagerDBStates.Lock()
+	defer belegManagerDBStates.Unlock()
+
+	if state, exists := belegManagerDBStates.states[db]; exists && state.schema != nil {
+		return state.schema
 	}
+	return latestSchemaAdapter()
+}
+
+func checkPresupposedTables(db *sqlx.DB) error {
+	var tables []string
+	if queryErr := db.Select(&tables, sseBelMngrTablesQuery); queryErr != nil {
+		return fmt.Errorf("failed to list database tables: %w", queryErr)
+	}
+
+	var missingTables []string
+	for _, presupposedTable := range belMngrPresupposedTables {
+		if !slices.Contains(tables, presupposedTable) {
+			missingTables = append(missingTables, presupposedTable)
+		}
+	}
+	if len(missingTables) > 0 {
+		return &MissingTablesError{Tables: missingTables}
+	}
+	return nil
 }
 
 func CloseDB(db *sqlx.DB) {
+	releaseHermineLockFile(db)
+	belegManagerDBStates.Lock()
+	delete(belegManagerDBStates.states, db)
+	belegManagerDBStates.Unlock()
 	if err := db.Close(); err != nil {
 		log.WithError(err).Debug("Failed to close database")
 	}
 }
 
-func BackupBelegManagerSqLiteDatabaseFile(dbFilePath string) error {
-	dummyLogEntry := log.WithField("dummy", true)
-	fpWithoutFileEnding := strings.TrimSuffix(dbFilePath, BelMngrSqLiteDatabaseFileEnding)
-	backupPath := fmt.Sprintf("%s-schultedevbackup-%s.db4", fpWithoutFileEnding, time.Now().Format(flatDateTime))
-
-	return copyFileToTargetIfTargetDoesNotExist(dummyLogEntry, dbFilePath, backupPath)
-}
-
-func beginTransaction(db *sqlx.DB) (*sqlx.Tx, error) {
+func beginTransaction(db *sqlx.DB, dryRun bool) (*importTx, error) {
 	tx, beginTxErr := db.BeginTxx(context.Background(), nil)
 	if beginTxErr != nil {
 		log.WithError(beginTxErr).Warn("Failed to begin database transaction")
@@ -86,11 +204,12 @@ func beginTransaction(db *sqlx.DB) (*sqlx.Tx, error) {
 	}
 
 	log.Debug("Transaction begun")
-	return tx, nil
+	return &importTx{Tx: tx, schema: schemaAdapterOf(db), dryRun: dryRun}, nil
 }
 
-func finishTransaction(tx *sqlx.Tx) {
-	p := recover()
+// finishTransaction commits the transaction, or rolls it back in a dry run. It has to be deferred passing recover(),
+// so a panic rolls back the transaction before panicking again.
+func finishTransaction(tx *importTx, p any) error {
 	if p != nil {
 		log.WithField("panic", p).Warn("Panic during transaction, rolling back")
 		if rollbackErr := tx.Rollback(); rollbackErr != nil {
@@ -99,14 +218,26 @@ func finishTransaction(tx *sqlx.Tx) {
 		panic(p)
 	}
 
-	if commitErr := tx.Commit(); commitErr == nil {
-		log.Debug("Transaction committed")
-	} else {
+	if tx.dryRun {
+		if rollbackErr := tx.Rollback(); rollbackErr == nil {
+			log.Debug("Dry run, transaction rolled back")
+		} else {
+			log.WithError(rollbackErr).Warn("Failed to rollback dry run transaction")
+		}
+		return nil
+	}
+
+	commitErr := tx.Commit()
+	if commitErr != nil {
 		log.WithError(commitErr).Warn("Failed to commit transaction")
 		if rollbackErr := tx.Rollback(); rollbackErr != nil {
 			log.WithError(rollbackErr).Warn("Failed to rollback transaction after commit error")
 		}
+		return commitErr
 	}
+
+	log.Debug("Transaction committed")
+	return nil
 }
 
 func newBmDocUUID() string {
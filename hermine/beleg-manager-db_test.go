@@ -1,8 +1,11 @@
 package hermine
 
 import (
+	"errors"
+	"github.com/jmoiron/sqlx"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"os"
 	"path/filepath"
 	"regexp"
 	"testing"
@@ -10,10 +13,11 @@ import (
 
 const belMngrEmptySqLiteDatabaseFileName = "BelegManager_empty.db4"
 
-func Test_startBelegManagerSQLiteDb(t *testing.T) {
+func Test_OpenBelegManagerDB(t *testing.T) {
 	belegManagerSqLiteDBAbsoluteFilePath := filepath.Join("testdata", belMngrEmptySqLiteDatabaseFileName)
 
-	db := StartBelegManagerSQLiteDB(belegManagerSqLiteDBAbsoluteFilePath)
+	db, openErr := OpenBelegManagerDB(belegManagerSqLiteDBAbsoluteFilePath, DatabaseOptions{})
+	require.NoError(t, openErr)
 	require.NotNil(t, db)
 
 	CloseDB(db)
@@ -21,6 +25,102 @@ func Test_startBelegManagerSQLiteDb(t *testing.T) {
 	assert.Error(t, pingErr)
 }
 
+func Test_OpenBelegManagerDB_errors(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		alterQuery string
+		wantErr    error
+	}{
+		{name: "unsupported version", alterQuery: "UPDATE Property SET propertyValue = 21000 WHERE propertyKey = 'databaseVersion'", wantErr: ErrUnsupportedSchemaVersion},
+		{name: "missing tables", alterQuery: "DROP TABLE BmDoc_Person", wantErr: ErrMissingTables},
+		{name: "no BelegManager database", alterQuery: "DROP TABLE Property", wantErr: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			// given
+			dbFilePath := copyDatabaseFixtureIntoDirectory(t, t.TempDir())
+			alteringDB := sqlx.MustOpen("sqlite", "file:"+dbFilePath)
+			_, alterErr := alteringDB.Exec(tt.alterQuery)
+			require.NoError(t, alterErr)
+			CloseDB(alteringDB)
+
+			// when
+			db, openErr := OpenBelegManagerDB(dbFilePath, DatabaseOptions{})
+
+			// then
+			require.Error(t, openErr)
+			assert.Nil(t, db)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, openErr, tt.wantErr)
+			}
+			assert.NoFileExists(t, dbFilePath+hermineLockFileEnding)
+		})
+	}
+}
+
+func Test_OpenBelegManagerDB_errorDetails(t *testing.T) {
+	t.Parallel()
+
+	// given
+	dbFilePath := copyDatabaseFixtureIntoDirectory(t, t.TempDir())
+	alteringDB := sqlx.MustOpen("sqlite", "file:"+dbFilePath)
+	_, alterErr := alteringDB.Exec("DROP TABLE BmDoc_Person; DROP TABLE BmDoc_Steuerfall")
+	require.NoError(t, alterErr)
+	CloseDB(alteringDB)
+
+	// when
+	_, openErr := OpenBelegManagerDB(dbFilePath, DatabaseOptions{})
+	_, missingFileErr := OpenBelegManagerDB(filepath.Join(t.TempDir(), BelMngrSqLiteDatabaseFileName), DatabaseOptions{})
+
+	// then
+	var missingTablesErr *MissingTablesError
+	require.ErrorAs(t, openErr, &missingTablesErr)
+	assert.Equal(t, []string{"BmDoc_Person", "BmDoc_Steuerfall"}, missingTablesErr.Tables)
+	assert.ErrorIs(t, missingFileErr, os.ErrNotExist, "SQLite must not create a missing database")
+}
+
+func Test_OpenBelegManagerDB_locked(t *testing.T) {
+	t.Parallel()
+
+	// given
+	dbFilePath := copyDatabaseFixtureIntoDirectory(t, t.TempDir())
+	db, openErr := OpenBelegManagerDB(dbFilePath, DatabaseOptions{})
+	require.NoError(t, openErr)
+	t.Cleanup(func() {
+		CloseDB(db)
+	})
+
+	// when
+	otherDB, otherOpenErr := OpenBelegManagerDB(dbFilePath, DatabaseOptions{})
+
+	// then
+	assert.Nil(t, otherDB)
+	assert.ErrorIs(t, otherOpenErr, ErrDatabaseLocked)
+	assert.FileExists(t, dbFilePath+hermineLockFileEnding, "still held by the first database")
+}
+
+func Test_SchemaVersionError(t *testing.T) {
+	t.Parallel()
+
+	// given
+	var err error = &SchemaVersionError{Found: 21000, Expected: belMngrSupportedDBVersion}
+
+	// when
+	var versionErr *SchemaVersionError
+	isVersionErr := errors.As(err, &versionErr)
+
+	// then
+	require.True(t, isVersionErr)
+	assert.Equal(t, 21000, versionErr.Found)
+	assert.ErrorIs(t, err, ErrUnsupportedSchemaVersion)
+	assert.NotErrorIs(t, err, ErrMissingTables)
+	assert.EqualError(t, err, "BelegManager database version 21000 not supported, supported: 22003")
+}
+
 func Test_newBmDocUuid(t *testing.T) {
 	bmDocUUID := newBmDocUUID()
 
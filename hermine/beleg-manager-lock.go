@@ -0,0 +1,209 @@
+package hermine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// hermineLockFileEnding names the lock file preventing several Hermine processes from importing into the same
+// database, e.g. "watch" and a manual run.
+const hermineLockFileEnding = ".hermine.lock"
+
+// lockPollInterval is the interval of checking again, whether the database is still in use, while waiting.
+const lockPollInterval = 500 * time.Millisecond
+
+// ErrDatabaseLocked is returned if the BelegManager database is in use by BelegManager or another process.
+var ErrDatabaseLocked = errors.New("BelegManager database is in use")
+
+// DatabaseLockOptions decide what happens, if the BelegManager database is in use.
+type DatabaseLockOptions struct {
+	// Wait is the maximum duration to wait for the database to be released, 0 fails immediately.
+	Wait time.Duration
+	// Force continues although the database is in use, risking corruption and sync conflicts.
+	Force bool
+	// DryRun skips creating the Hermine lock file, as a dry run does not change the database.
+	DryRun bool
+
+	// strictProcessCheck considers the database in use, if it cannot be checked whether BelegManager is running
+	strictProcessCheck bool
+}
+
+// lockBelegManagerDB makes sure no one else uses the database: BelegManager must not run, no other process may hold
+// a write lock, checked using "BEGIN IMMEDIATE", and no other Hermine process may hold the Hermine lock file, which
+// is created then, except for dry runs. Depending on the options, it waits for the database to be released, or
+// continues anyway.
+func lockBelegManagerDB(db *sqlx.DB, dbFilePath string, options DatabaseLockOptions) error {
+	ctx, cancel := context.WithTimeout(context.Background(), options.Wait)
+	defer cancel()
+	dbFileLogger := log.WithField("db_file", dbFilePath)
+
+	checks := []struct {
+		name  string
+		check func(ctx context.Context) error
+	}{
+		{name: "BelegManager process", check: waitUntil(func() error {
+			return checkBelegManagerNotRunning(options.strictProcessCheck)
+		})},
+		{name: "Hermine lock file", check: waitUntil(func() error {
+			if options.DryRun {
+				return nil
+			}
+			return createHermineLockFile(db, dbFilePath)
+		})},
+		{name: "database write lock", check: func(ctx context.Context) error {
+			return checkNoWriteLock(ctx, db)
+		}},
+	}
+	for _, c := range checks {
+		checkErr := c.check(ctx)
+		if checkErr == nil {
+			continue
+		}
+		if !errors.Is(checkErr, ErrDatabaseLocked) || !options.Force {
+			releaseHermineLockFile(db)
+			return checkErr
+		}
+		dbFileLogger.WithError(checkErr).Warnf("Continuing despite %s, as forced", c.name)
+	}
+
+	return nil
+}
+
+// waitUntil returns a check, which repeats the check while it reports ErrDatabaseLocked, until ctx is done.
+func waitUntil(check func() error) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		for loggedWaiting := false; ; loggedWaiting = true {
+			checkErr := check()
+			if !errors.Is(checkErr, ErrDatabaseLocked) {
+				return checkErr
+			}
+
+			if _, hasDeadline := ctx.Deadline(); hasDeadline && !loggedWaiting && ctx.Err() == nil {
+				log.WithError(checkErr).Info("Waiting for the BelegManager database to be released...")
+			}
+			select {
+			case <-ctx.Done():
+				return checkErr
+			case <-time.After(lockPollInterval):
+			}
+		}
+	}
+}
+
+// checkNoWriteLock fails, if another process writes the database, waiting up to the deadline of ctx.
+func checkNoWriteLock(ctx context.Context, db *sqlx.DB) error {
+	conn, connErr := db.Connx(context.Background())
+	if connErr != nil {
+		return connErr
+	}
+	defer func() {
+		if closeErr := conn.Close(); closeErr != nil {
+			log.WithError(closeErr).Debug("Failed to close database connection")
+		}
+	}()
+
+	var busyTimeout time.Duration
+	if deadline, hasDeadline := ctx.Deadline(); hasDeadline {
+		busyTimeout = max(time.Until(deadline), 0)
+	}
+	if _, pragmaErr := conn.ExecContext(context.Background(), fmt.Sprintf("PRAGMA busy_timeout = %d", busyTimeout.Milliseconds())); pragmaErr != nil {
+		return pragmaErr
+	}
+	defer func() {
+		if _, pragmaErr := conn.ExecContext(context.Background(), "PRAGMA busy_timeout = 0"); pragmaErr != nil {
+			log.WithError(pragmaErr).Debug("Failed to reset busy timeout")
+		}
+	}()
+
+	if _, beginErr := conn.ExecContext(context.Background(), "BEGIN IMMEDIATE"); beginErr != nil {
+		if strings.Contains(beginErr.Error(), "SQLITE_BUSY") || strings.Contains(beginErr.Error(), "database is locked") {
+			return fmt.Errorf("%w: another process is writing it: %w", ErrDatabaseLocked, beginErr)
+		}
+		if strings.Contains(beginErr.Error(), "file is not a database") {
+			// E.g. a damaged database to be restored, which no other process can write either
+			log.WithError(beginErr).Debug("Not a database, so not write locked")
+			return nil
+		}
+		return beginErr
+	}
+	_, rollbackErr := conn.ExecContext(context.Background(), "ROLLBACK")
+	return rollbackErr
+}
+
+// createHermineLockFile creates the lock file containing the process ID, replacing lock files of processes not
+// running anymore.
+func createHermineLockFile(db *sqlx.DB, dbFilePath string) error {
+	lockFilePath := dbFilePath + hermineLockFileEnding
+	for attempt := 0; attempt < 2; attempt++ {
+		lockFile, createErr := os.OpenFile(lockFilePath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if createErr == nil {
+			_, writeErr := lockFile.WriteString(strconv.Itoa(os.Getpid()))
+			closeErr := lockFile.Close()
+			if writeErr = errors.Join(writeErr, closeErr); writeErr != nil {
+				return writeErr
+			}
+
+			updateBelegManagerDBState(db, func(state *belegManagerDBState) {
+				state.lockFilePath = lockFilePath
+			})
+			return nil
+		}
+		if !os.IsExist(createErr) {
+			return createErr
+		}
+
+		content, readErr := os.ReadFile(lockFilePath)
+		if readErr != nil {
+			return readErr
+		}
+		pid, parseErr := strconv.Atoi(strings.TrimSpace(string(content)))
+		if parseErr == nil && processExists(pid) {
+			return fmt.Errorf("%w: another Hermine process (%d) is importing, see %s", ErrDatabaseLocked, pid, lockFilePath)
+		}
+		log.Infof("Removing stale lock file %s", lockFilePath)
+		if removeErr := os.Remove(lockFilePath); removeErr != nil && !os.IsNotExist(removeErr) {
+			return removeErr
+		}
+	}
+
+	return fmt.Errorf("%w: failed to create %s", ErrDatabaseLocked, lockFilePath)
+}
+
+func releaseHermineLockFile(db *sqlx.DB) {
+	var lockFilePath string
+	updateBelegManagerDBState(db, func(state *belegManagerDBState) {
+		lockFilePath, state.lockFilePath = state.lockFilePath, ""
+	})
+
+	if lockFilePath != "" {
+		if removeErr := os.Remove(lockFilePath); removeErr != nil {
+			log.WithError(removeErr).Warnf("Failed to remove lock file %s", lockFilePath)
+		}
+	}
+}
+
+func processExists(pid int) bool {
+	if pid == os.Getpid() {
+		return true
+	}
+	process, findErr := os.FindProcess(pid)
+	if findErr != nil {
+		return false
+	}
+	if runtime.GOOS == "windows" {
+		// FindProcess fails on Windows, if the process does not exist
+		return true
+	}
+
+	signalErr := process.Signal(syscall.Signal(0))
+	return signalErr == nil || errors.Is(signalErr, syscall.EPERM)
+}
@@ -0,0 +1,135 @@
+package hermine
+
+import (
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func Test_lockBelegManagerDB_lockFile(t *testing.T) {
+	t.Parallel()
+
+	// given
+	dbFilePath := copyDatabaseFixtureIntoDirectory(t, t.TempDir())
+	db := openPlainDatabase(t, dbFilePath)
+	otherDB := openPlainDatabase(t, dbFilePath)
+
+	// when
+	lockErr := lockBelegManagerDB(db, dbFilePath, DatabaseLockOptions{})
+	otherLockErr := lockBelegManagerDB(otherDB, dbFilePath, DatabaseLockOptions{})
+	forcedLockErr := lockBelegManagerDB(otherDB, dbFilePath, DatabaseLockOptions{Force: true})
+
+	// then
+	require.NoError(t, lockErr)
+	assert.FileExists(t, dbFilePath+hermineLockFileEnding)
+	assert.ErrorIs(t, otherLockErr, ErrDatabaseLocked)
+	require.NoError(t, forcedLockErr)
+
+	CloseDB(otherDB)
+	assert.FileExists(t, dbFilePath+hermineLockFileEnding, "held by the first database only")
+	CloseDB(db)
+	assert.NoFileExists(t, dbFilePath+hermineLockFileEnding)
+}
+
+func Test_lockBelegManagerDB_dryRun(t *testing.T) {
+	t.Parallel()
+
+	// given
+	dbFilePath := copyDatabaseFixtureIntoDirectory(t, t.TempDir())
+	db := openPlainDatabase(t, dbFilePath)
+	otherDB := openPlainDatabase(t, dbFilePath)
+
+	// when
+	dryRunLockErr := lockBelegManagerDB(db, dbFilePath, DatabaseLockOptions{DryRun: true})
+	otherLockErr := lockBelegManagerDB(otherDB, dbFilePath, DatabaseLockOptions{})
+
+	// then
+	require.NoError(t, dryRunLockErr)
+	require.NoError(t, otherLockErr, "a dry run does not hold the lock file")
+	CloseDB(db)
+	assert.FileExists(t, dbFilePath+hermineLockFileEnding, "held by the other database")
+	CloseDB(otherDB)
+	assert.NoFileExists(t, dbFilePath+hermineLockFileEnding)
+}
+
+func Test_lockBelegManagerDB_staleLockFile(t *testing.T) {
+	t.Parallel()
+
+	// given
+	dbFilePath := copyDatabaseFixtureIntoDirectory(t, t.TempDir())
+	require.NoError(t, os.WriteFile(dbFilePath+hermineLockFileEnding, []byte("999999999"), 0o600))
+	db := openPlainDatabase(t, dbFilePath)
+
+	// when
+	lockErr := lockBelegManagerDB(db, dbFilePath, DatabaseLockOptions{})
+
+	// then
+	require.NoError(t, lockErr)
+	content, readErr := os.ReadFile(dbFilePath + hermineLockFileEnding)
+	require.NoError(t, readErr)
+	assert.Equal(t, strconv.Itoa(os.Getpid()), string(content))
+}
+
+func Test_lockBelegManagerDB_writeLock(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		options DatabaseLockOptions
+		// releaseAfter is when the competing write lock is released, zero holds it until the test is done
+		releaseAfter time.Duration
+		wantErr      bool
+	}{
+		{name: "fail fast", options: DatabaseLockOptions{}, wantErr: true},
+		{name: "wait", options: DatabaseLockOptions{Wait: time.Minute}, releaseAfter: 200 * time.Millisecond, wantErr: false},
+		{name: "force", options: DatabaseLockOptions{Force: true}, wantErr: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			// given
+			dbFilePath := copyDatabaseFixtureIntoDirectory(t, t.TempDir())
+			writingDB := openPlainDatabase(t, dbFilePath)
+			tx, beginErr := writingDB.Beginx()
+			require.NoError(t, beginErr)
+			_, insertErr := tx.Exec("INSERT INTO BmDoc_Kategorie (uuid, name, docType) VALUES ('{writing}', 'Writing', 1)")
+			require.NoError(t, insertErr)
+			if tt.releaseAfter > 0 {
+				time.AfterFunc(tt.releaseAfter, func() {
+					_ = tx.Rollback()
+				})
+			} else {
+				t.Cleanup(func() {
+					_ = tx.Rollback()
+				})
+			}
+			db := openPlainDatabase(t, dbFilePath)
+
+			// when
+			lockErr := lockBelegManagerDB(db, dbFilePath, tt.options)
+
+			// then
+			if tt.wantErr {
+				require.ErrorIs(t, lockErr, ErrDatabaseLocked)
+				assert.NoFileExists(t, dbFilePath+hermineLockFileEnding, "released on failure")
+			} else {
+				require.NoError(t, lockErr)
+			}
+		})
+	}
+}
+
+func openPlainDatabase(t *testing.T, dbFilePath string) *sqlx.DB {
+	t.Helper()
+
+	db := sqlx.MustOpen("sqlite", "file:"+dbFilePath)
+	t.Cleanup(func() {
+		CloseDB(db)
+	})
+	return db
+}
@@ -1,90 +1,127 @@
 package hermine
 
 import (
+	"database/sql"
 	"fmt"
-	"github.com/jmoiron/sqlx"
 	log "github.com/sirupsen/logrus"
 	"os"
 	"path/filepath"
 	"time"
 )
 
+// Queries of all schema versions, the version-dependent ones are owned by the schemaAdapter of the transaction.
 const (
-	insertBmDocAssetQuery               = "INSERT OR IGNORE INTO BmDoc_Asset (uuid, name, docType, deleteState, docDate, timestampCreated, sync, needUpSync, needDownSync, targetDocType, ocrState, internalPath, fileSyncState) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?)"
-	selectBmDocAssetByIDQuery           = "SELECT * FROM BmDoc_Asset WHERE id = ?"
-	selectBmDocAssetByInternalPathQuery = "SELECT * FROM BmDoc_Asset WHERE internalPath = ?"
+	selectBmDocAssetByIDQuery           = "SELECT " + bmDocAssetColumns + " FROM BmDoc_Asset WHERE id = ?"
+	selectBmDocAssetByInternalPathQuery = "SELECT " + bmDocAssetColumns + " FROM BmDoc_Asset WHERE internalPath = ?"
 
-	insertBmDocBelegQuery       = "INSERT OR IGNORE INTO BmDoc_Beleg (uuid, name, docType, deleteState, docDate, timestampCreated, sync, needUpSync, needDownSync, number, amount, netto, vat, comment, belegDate) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)"
-	updateBmDocBelegQuery       = "UPDATE BmDoc_Beleg SET name = ?, docDate = ?, number = ?, amount = ?, netto = ?, vat = ?, comment = ?, belegDate = ? WHERE id = ?"
-	selectBmDocBelegByUUIDQuery = "SELECT * FROM BmDoc_Beleg WHERE uuid = ?"
-	selectBmDocBelegByIDQuery   = "SELECT * FROM BmDoc_Beleg WHERE id = ?"
+	selectBmDocBelegByUUIDQuery = "SELECT " + bmDocBelegColumns + " FROM BmDoc_Beleg WHERE uuid = ?"
+	selectBmDocBelegByIDQuery   = "SELECT " + bmDocBelegColumns + " FROM BmDoc_Beleg WHERE id = ?"
 
-	insertBmDocCategoryQuery       = "INSERT OR IGNORE INTO BmDoc_Kategorie (uuid, name, docType, deleteState, docDate, timestampCreated, sync, needUpSync, needDownSync) VALUES (?,?,?,?,?,?,?,?,?)"
-	selectBmDocCategoryByNameQuery = "SELECT * FROM BmDoc_Kategorie WHERE name = ?"
+	selectBmDocCategoryByNameQuery = "SELECT " + bmDocCategoryColumns + " FROM BmDoc_Kategorie WHERE name = ?"
 
-	insertOrIgnoreBmDocLinkTableQuery     = "INSERT OR IGNORE INTO BmDoc_LinkTable (sourceUuid, targetUuid) VALUES (?,?)"
-	selectBmDocLinkTableBySourceUUIDQuery = "SELECT * FROM BmDoc_LinkTable WHERE sourceUuid = ?"
-	selectBmDocLinkTableByTargetUUIDQuery = "SELECT * FROM BmDoc_LinkTable WHERE targetUuid = ?"
+	countBmDocLinkTableQuery              = "SELECT COUNT(*) FROM BmDoc_LinkTable WHERE sourceUuid = ? AND targetUuid = ?"
+	selectBmDocLinkTableBySourceUUIDQuery = "SELECT " + bmDocLinkColumns + " FROM BmDoc_LinkTable WHERE sourceUuid = ?"
+	selectBmDocLinkTableByTargetUUIDQuery = "SELECT " + bmDocLinkColumns + " FROM BmDoc_LinkTable WHERE targetUuid = ?"
 )
 
-func createBmDocBelegWithLinkedAsset(logger *log.Entry, tx *sqlx.Tx, belegManagerDirectory *os.File, pathOfFileToImport string, documentFromAnalysis diDocument) (*bmDocBeleg, error) {
-	internalFileToImportPath, createCopyErr := copyFileIntoDirectoryIfTargetDoesNotExist(logger, pathOfFileToImport, belegManagerDirectory.Name())
-	if createCopyErr != nil {
-		return nil, createCopyErr
-	}
-
-	fileBaseName := filepath.Base(pathOfFileToImport)
-	newAsset, createAssetErr := createBmDocAsset(logger, tx, fileBaseName, internalFileToImportPath)
+// createBmDocBelegWithLinkedAsset creates a new Beleg and its asset. The reviewNote is appended to its comment, if set.
+func createBmDocBelegWithLinkedAsset(logger *log.Entry, tx *importTx, belegManagerDirectory *os.File, pathOfFileToImport string, documentFromAnalysis diDocument, reviewNote string) (*bmDocBeleg, *bmDocAsset, error) {
+	newAsset, createAssetErr := createBmDocAssetFromFile(logger, tx, belegManagerDirectory, pathOfFileToImport)
 	if createAssetErr != nil {
-		return nil, createAssetErr
-	}
-	if newAsset == nil {
-		newAssetNotFoundErr := fmt.Errorf("expected new BmDoc_Asset-internalPath for %s", pathOfFileToImport)
-		logger.WithError(newAssetNotFoundErr).Warn()
-		return nil, newAssetNotFoundErr
+		return nil, nil, createAssetErr
 	}
 
-	beleg, createBelegErr := createBmDocBeleg(logger, tx, documentFromAnalysis)
+	beleg, createBelegErr := createBmDocBeleg(logger, tx, documentFromAnalysis, reviewNote)
 	if createBelegErr != nil {
-		return nil, createBelegErr
+		return nil, nil, createBelegErr
 	}
 	if beleg == nil {
 		noDocumentFoundError := fmt.Errorf("no BmDoc_Beleg found for asset %d though expected", newAsset.ID)
 		logger.WithError(noDocumentFoundError).Warn()
-		return nil, noDocumentFoundError
+		return nil, nil, noDocumentFoundError
 	}
 
 	if createLinkErr := createIgnoreBmDocLink(logger, tx, newAsset.UUID, beleg.UUID); createLinkErr != nil {
-		return nil, createLinkErr
+		return nil, nil, createLinkErr
 	}
 
 	logger.
 		WithField("beleg_id", beleg.ID).
 		WithField("beleg_name", beleg.Name).
 		Info("New Beleg created")
-	return beleg, nil
+	return beleg, newAsset, nil
 }
 
-func createBmDocBeleg(logger *log.Entry, tx *sqlx.Tx, documentFromAnalysis diDocument) (*bmDocBeleg, error) {
-	fields := documentFromAnalysis.Fields
+// linkNewAssetToBmDocBeleg adds the file as an additional asset to an existing Beleg.
+func linkNewAssetToBmDocBeleg(logger *log.Entry, tx *importTx, belegManagerDirectory *os.File, pathOfFileToImport string, beleg *bmDocBeleg) (*bmDocAsset, error) {
+	newAsset, createAssetErr := createBmDocAssetFromFile(logger, tx, belegManagerDirectory, pathOfFileToImport)
+	if createAssetErr != nil {
+		return nil, createAssetErr
+	}
+
+	if createLinkErr := createIgnoreBmDocLink(logger, tx, newAsset.UUID, beleg.UUID); createLinkErr != nil {
+		return nil, createLinkErr
+	}
+
+	logger.
+		WithField("beleg_id", beleg.ID).
+		WithField("beleg_name", beleg.Name).
+		Info("Asset added to existing Beleg")
+	return newAsset, nil
+}
+
+func createBmDocAssetFromFile(logger *log.Entry, tx *importTx, belegManagerDirectory *os.File, pathOfFileToImport string) (*bmDocAsset, error) {
+	internalFileToImportPath, createCopyErr := copyFileIntoBelegManagerDirectory(logger, tx, pathOfFileToImport, belegManagerDirectory)
+	if createCopyErr != nil {
+		return nil, createCopyErr
+	}
+
+	fileBaseName := filepath.Base(pathOfFileToImport)
+	newAsset, createAssetErr := createBmDocAsset(logger, tx, fileBaseName, internalFileToImportPath)
+	if createAssetErr != nil {
+		return nil, createAssetErr
+	}
+	if newAsset == nil {
+		newAssetNotFoundErr := fmt.Errorf("expected new BmDoc_Asset-internalPath for %s", pathOfFileToImport)
+		logger.WithError(newAssetNotFoundErr).Warn()
+		return nil, newAssetNotFoundErr
+	}
+
+	return newAsset, nil
+}
 
+func createBmDocBeleg(logger *log.Entry, tx *importTx, documentFromAnalysis diDocument, reviewNote string) (*bmDocBeleg, error) {
 	bmDocUUID := newBmDocUUID()
-	invoiceID := fields["InvoiceId"].Content
-	invoiceDate := fields["InvoiceDate"].ValueDate
+	invoiceID := documentFromAnalysis.getNumber()
+	invoiceDate := documentFromAnalysis.getBelegDate()
 	name := documentFromAnalysis.createInvoiceName()
 	now := time.Now().Format(bmDocRFC3339Milli)
 	vat := documentFromAnalysis.getVat()
 	gross := documentFromAnalysis.getGross()
 	comment := documentFromAnalysis.createComment()
-	if _, insertErr := tx.Exec(insertBmDocBelegQuery, bmDocUUID, name, 3, 0, now, now, 1, 1, 0, invoiceID, gross, 0, vat, comment, invoiceDate); insertErr != nil {
+	if reviewNote != "" {
+		comment += "\n\n" + reviewNote
+	}
+	insertQuery, args := tx.schema.insertQuery("BmDoc_Beleg", map[string]any{
+		"uuid": bmDocUUID, "name": name, "docDate": now, "timestampCreated": now,
+		"number": invoiceID, "amount": gross, "vat": vat, "comment": comment, "belegDate": invoiceDate,
+	})
+	result, insertErr := tx.Exec(insertQuery, args...)
+	if insertErr != nil {
 		logger.WithError(insertErr).Warnf("Error when inserting new BmDoc_Beleg")
 		return nil, insertErr
 	}
 
-	return findBmDocBelegByUUID(logger, tx, &bmDocUUID)
+	beleg, findBelegErr := findBmDocBelegByUUID(logger, tx, &bmDocUUID)
+	if findBelegErr != nil {
+		return nil, findBelegErr
+	}
+	recordInsert(logger, tx, "BmDoc_Beleg", result, bmDocUUID, belegChangeValues(beleg))
+
+	return beleg, nil
 }
 
-func updateBmDocBeleg(logger *log.Entry, tx *sqlx.Tx, documentFromAnalysis diDocument, existingAsset *bmDocAsset) (*bmDocBeleg, error) {
+func updateBmDocBeleg(logger *log.Entry, tx *importTx, documentFromAnalysis diDocument, existingAsset *bmDocAsset) (*bmDocBeleg, error) {
 	beleg, findBelegErr := findBmDocBelegByAsset(logger, tx, existingAsset)
 	if findBelegErr != nil {
 		return nil, findBelegErr
@@ -96,15 +133,15 @@ func updateBmDocBeleg(logger *log.Entry, tx *sqlx.Tx, documentFromAnalysis diDoc
 	}
 	belegLogger := logger.WithField("beleg_id", beleg.ID).WithField("beleg_name", beleg.Name)
 
-	fields := documentFromAnalysis.Fields
-	invoiceID := fields["InvoiceId"].Content
-	invoiceDate := fields["InvoiceDate"].ValueDate
+	invoiceID := documentFromAnalysis.getNumber()
+	invoiceDate := documentFromAnalysis.getBelegDate()
 	name := documentFromAnalysis.createInvoiceName()
 	now := time.Now().Format(bmDocRFC3339Milli)
 	vat := documentFromAnalysis.getVat()
 	gross := documentFromAnalysis.getGross()
 	comment := documentFromAnalysis.createComment()
-	if _, err := tx.Exec(updateBmDocBelegQuery, name, now, invoiceID, gross, 0, vat, comment, invoiceDate, beleg.ID); err != nil {
+	netto := tx.schema.columnDefault("BmDoc_Beleg", "netto")
+	if _, err := tx.Exec(tx.schema.updateBmDocBelegQuery, name, now, invoiceID, gross, netto, vat, comment, invoiceDate, beleg.ID); err != nil {
 		belegLogger.WithError(err).Warnf("Error when updating BmDoc_Beleg %d", beleg.ID)
 		return nil, err
 	}
@@ -118,6 +155,14 @@ func updateBmDocBeleg(logger *log.Entry, tx *sqlx.Tx, documentFromAnalysis diDoc
 		belegLogger.WithError(noDocumentFoundError).Warn()
 		return nil, noDocumentFoundError
 	}
+	tx.recordChange(belegLogger, importChange{
+		Operation: importChangeUpdate,
+		Table:     "BmDoc_Beleg",
+		ID:        int64(updatedBeleg.ID),
+		UUID:      updatedBeleg.UUID,
+		Values:    belegChangeValues(updatedBeleg),
+		Previous:  belegPreviousValues(beleg),
+	})
 
 	belegLogger.Info("Beleg updated")
 	return updatedBeleg, nil
@@ -133,7 +178,7 @@ func findBmDocBelegByID(logger *log.Entry, q sqlxGetter, id uint32) (*bmDocBeleg
 	return &doc, nil
 }
 
-func findBmDocBelegByUUID(logger *log.Entry, tx *sqlx.Tx, uuid *string) (*bmDocBeleg, error) {
+func findBmDocBelegByUUID(logger *log.Entry, tx *importTx, uuid *string) (*bmDocBeleg, error) {
 	doc := bmDocBeleg{}
 	if err := tx.Get(&doc, selectBmDocBelegByUUIDQuery, uuid); err != nil {
 		logger.WithError(err).Warnf("Error when searching BmDoc_Beleg for uuid %s", *uuid)
@@ -143,7 +188,7 @@ func findBmDocBelegByUUID(logger *log.Entry, tx *sqlx.Tx, uuid *string) (*bmDocB
 	return &doc, nil
 }
 
-func findBmDocBelegByAsset(logger *log.Entry, tx *sqlx.Tx, asset *bmDocAsset) (*bmDocBeleg, error) {
+func findBmDocBelegByAsset(logger *log.Entry, tx *importTx, asset *bmDocAsset) (*bmDocBeleg, error) {
 	link, findLinkErr := findBmDocLinkByAssetAsSource(logger, tx, asset)
 	if findLinkErr != nil {
 		return nil, findLinkErr
@@ -156,16 +201,31 @@ func findBmDocBelegByAsset(logger *log.Entry, tx *sqlx.Tx, asset *bmDocAsset) (*
 	return findBmDocBelegByUUID(logger, tx, &link.TargetUUID)
 }
 
-func createIgnoreBmDocLink(logger *log.Entry, tx *sqlx.Tx, sourceUUID, targetUUID string) error {
-	if _, err := tx.Exec(insertOrIgnoreBmDocLinkTableQuery, sourceUUID, targetUUID); err != nil {
+// createIgnoreBmDocLink links source and target, unless already linked. BmDoc_LinkTable has no unique constraint
+// on sourceUuid and targetUuid, so "INSERT OR IGNORE" on its own does not prevent duplicates.
+func createIgnoreBmDocLink(logger *log.Entry, tx *importTx, sourceUUID, targetUUID string) error {
+	var existingLinks int
+	if countErr := tx.Get(&existingLinks, countBmDocLinkTableQuery, sourceUUID, targetUUID); countErr != nil {
+		logger.WithError(countErr).Warnf("Error when searching BmDoc_LinkTable for %s and %s", sourceUUID, targetUUID)
+		return countErr
+	}
+	if existingLinks > 0 {
+		logger.Tracef("%s and %s already linked", sourceUUID, targetUUID)
+		return nil
+	}
+
+	insertQuery, args := tx.schema.insertQuery("BmDoc_LinkTable", map[string]any{"sourceUuid": sourceUUID, "targetUuid": targetUUID})
+	result, err := tx.Exec(insertQuery, args...)
+	if err != nil {
 		logger.WithError(err).Warnf("Error when linking %s and %s as BmDoc_LinkTable", sourceUUID, targetUUID)
 		return err
 	}
 
+	recordInsert(logger, tx, "BmDoc_LinkTable", result, "", map[string]any{"sourceUuid": sourceUUID, "targetUuid": targetUUID})
 	return nil
 }
 
-func findBmDocLinkByAssetAsSource(logger *log.Entry, tx *sqlx.Tx, asset *bmDocAsset) (*bmDocLink, error) {
+func findBmDocLinkByAssetAsSource(logger *log.Entry, tx *importTx, asset *bmDocAsset) (*bmDocLink, error) {
 	assetUUID := asset.UUID
 	result := make([]*bmDocLink, 0)
 	if err := tx.Select(&result, selectBmDocLinkTableBySourceUUIDQuery, assetUUID); err != nil {
@@ -196,10 +256,13 @@ func findBmDocLinkByBelegAsTarget(logger *log.Entry, q sqlxSelecter, beleg *bmDo
 	return result, nil
 }
 
-func createBmDocAsset(logger *log.Entry, tx *sqlx.Tx, fileName, internalPath string) (*bmDocAsset, error) {
+func createBmDocAsset(logger *log.Entry, tx *importTx, fileName, internalPath string) (*bmDocAsset, error) {
 	bmDocUUID := newBmDocUUID()
 	now := time.Now().Format(bmDocRFC3339Milli)
-	result, execErr := tx.Exec(insertBmDocAssetQuery, bmDocUUID, fileName, 4, 0, now, now, 1, 1, 0, 3, 0, internalPath, 2)
+	insertQuery, args := tx.schema.insertQuery("BmDoc_Asset", map[string]any{
+		"uuid": bmDocUUID, "name": fileName, "docDate": now, "timestampCreated": now, "internalPath": internalPath,
+	})
+	result, execErr := tx.Exec(insertQuery, args...)
 	if execErr != nil {
 		logger.WithError(execErr).Warnf("Error when inserting %s/%s as new BmDoc_Asset", fileName, internalPath)
 		return nil, execErr
@@ -211,6 +274,7 @@ func createBmDocAsset(logger *log.Entry, tx *sqlx.Tx, fileName, internalPath str
 		return nil, lastInsertIDErr
 	}
 	logger.WithField("bmdoc_asset_id", newID).Debug("Created new BmDoc_Asset")
+	recordInsert(logger, tx, "BmDoc_Asset", result, bmDocUUID, map[string]any{"name": fileName, "internalPath": internalPath})
 
 	newAsset, newAssetErr := findBmDocAssetByID(logger, tx, newID)
 	if newAssetErr != nil {
@@ -231,49 +295,35 @@ func findBmDocAssetByID(logger *log.Entry, q sqlxGetter, id int64) (*bmDocAsset,
 	return &asset, nil
 }
 
-func findBmDocAssets(logger *log.Entry, q sqlxSelecter, belegManagerDirectory *os.File, pathOfFileToImport string) ([]*bmDocAsset, os.FileInfo, error) {
-	fileBaseName := filepath.Base(pathOfFileToImport)
-
-	belegManagerFilePath := filepath.Join(belegManagerDirectory.Name(), fileBaseName)
-	fileStatInfo, fileStatErr := os.Stat(belegManagerFilePath)
-	if fileStatErr != nil && !os.IsNotExist(fileStatErr) {
-		logger.WithError(fileStatErr).Warnf("Error checking for file %s ", belegManagerFilePath)
-		return nil, nil, fileStatErr
-	}
-
-	bmDocAssets := make([]*bmDocAsset, 0)
-	if selectAssetErr := q.Select(&bmDocAssets, selectBmDocAssetByInternalPathQuery, fileBaseName); selectAssetErr != nil {
-		logger.WithError(selectAssetErr).Warnf("Error when searching BmDoc_Asset-internalPath: %s", fileBaseName)
-		return nil, nil, selectAssetErr
-	}
-
-	return bmDocAssets, fileStatInfo, nil
-}
-
-func findOrCreateBmDocCategory(logger *log.Entry, tx *sqlx.Tx, documentFromAnalysis diDocument, fieldName string) (*bmDocCategory, error) {
+func findOrCreateBmDocCategory(logger *log.Entry, tx *importTx, documentFromAnalysis diDocument, fieldName string) (*bmDocCategory, error) {
 	cat, catErr := findBmDocCategoryFromAnalysis(logger, tx, documentFromAnalysis, fieldName)
 	if cat != nil || catErr != nil {
 		return cat, catErr
 	}
 
-	if err := createBmDocCategory(logger, tx, documentFromAnalysis, fieldName); err != nil {
+	if err := createBmDocCategory(logger, tx, documentFromAnalysis.getContentFieldCommaSeperated(fieldName)); err != nil {
 		return nil, err
 	}
 	return findBmDocCategoryFromAnalysis(logger, tx, documentFromAnalysis, fieldName)
 }
 
-func createBmDocCategory(logger *log.Entry, tx *sqlx.Tx, documentFromAnalysis diDocument, fieldName string) error {
+func createBmDocCategory(logger *log.Entry, tx *importTx, categoryName string) error {
 	bmDocUUID := newBmDocUUID()
-	categoryName := documentFromAnalysis.getContentFieldCommaSeperated(fieldName)
 	now := time.Now().Format(bmDocRFC3339Milli)
-	if _, err := tx.Exec(insertBmDocCategoryQuery, bmDocUUID, categoryName, 1, 0, now, now, 1, 1, 0); err != nil {
-		logger.WithError(err).Warnf("Error when inserting %s as new BmDoc_Kategorie '%s': %s", fieldName, categoryName, err)
+	insertQuery, args := tx.schema.insertQuery("BmDoc_Kategorie", map[string]any{
+		"uuid": bmDocUUID, "name": categoryName, "docDate": now, "timestampCreated": now,
+	})
+	result, err := tx.Exec(insertQuery, args...)
+	if err != nil {
+		logger.WithError(err).Warnf("Error when inserting new BmDoc_Kategorie '%s': %s", categoryName, err)
 		return err
 	}
+
+	recordInsert(logger, tx, "BmDoc_Kategorie", result, bmDocUUID, map[string]any{"name": categoryName})
 	return nil
 }
 
-func findBmDocCategoryFromAnalysis(logger *log.Entry, tx *sqlx.Tx, documentFromAnalysis diDocument, fieldName string) (*bmDocCategory, error) {
+func findBmDocCategoryFromAnalysis(logger *log.Entry, tx *importTx, documentFromAnalysis diDocument, fieldName string) (*bmDocCategory, error) {
 	categoryName := documentFromAnalysis.getContentFieldCommaSeperated(fieldName)
 	return findBmDocCategoryByName(logger, tx, categoryName)
 }
@@ -300,3 +350,57 @@ func findBmDocCategoryByName(logger *log.Entry, q sqlxSelecter, categoryName str
 	}
 	return nil, nil
 }
+
+func copyFileIntoBelegManagerDirectory(logger *log.Entry, tx *importTx, pathOfFileToImport string, belegManagerDirectory *os.File) (string, error) {
+	var internalPath string
+	var copyErr error
+	if tx.dryRun {
+		internalPath, copyErr = targetFileNameInDirectory(pathOfFileToImport, belegManagerDirectory.Name())
+	} else {
+		internalPath, copyErr = copyFileIntoDirectoryIfTargetDoesNotExist(logger, pathOfFileToImport, belegManagerDirectory.Name())
+	}
+	if copyErr != nil {
+		return "", copyErr
+	}
+
+	tx.recordChange(logger, importChange{
+		Operation:  importChangeCopy,
+		SourcePath: pathOfFileToImport,
+		TargetPath: filepath.Join(belegManagerDirectory.Name(), internalPath),
+	})
+	return internalPath, nil
+}
+
+// recordInsert records an insert, unless it has been ignored due to "INSERT OR IGNORE".
+func recordInsert(logger *log.Entry, tx *importTx, table string, result sql.Result, bmDocUUID string, values map[string]any) {
+	if rowsAffected, rowsAffectedErr := result.RowsAffected(); rowsAffectedErr == nil && rowsAffected == 0 {
+		return
+	}
+
+	id, lastInsertIDErr := result.LastInsertId()
+	if lastInsertIDErr != nil {
+		logger.WithError(lastInsertIDErr).Debugf("Error retrieving last inserted ID of %s", table)
+	}
+
+	tx.recordChange(logger, importChange{Operation: importChangeInsert, Table: table, ID: id, UUID: bmDocUUID, Values: values})
+}
+
+func belegChangeValues(beleg *bmDocBeleg) map[string]any {
+	return map[string]any{
+		"name":      beleg.Name,
+		"number":    beleg.Number,
+		"amount":    beleg.Amount,
+		"vat":       beleg.VAT,
+		"comment":   beleg.Comment,
+		"belegDate": beleg.BelegDate,
+	}
+}
+
+// belegPreviousValues returns all values overwritten by the updateBmDocBelegQuery of the schemaAdapter.
+func belegPreviousValues(beleg *bmDocBeleg) map[string]any {
+	values := belegChangeValues(beleg)
+	values["docDate"] = beleg.DocDate
+	values["netto"] = beleg.Netto
+
+	return values
+}
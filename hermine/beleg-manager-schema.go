@@ -0,0 +1,186 @@
+package hermine
+
+import (
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+	"maps"
+	"slices"
+	"strings"
+)
+
+const selectTableInfoQuery = `SELECT name, "notnull", dflt_value IS NOT NULL AS hasDefault, pk FROM pragma_table_info(?)`
+
+// schemaAdapter writes into a BelegManager database of a schema version, as found in Property.databaseVersion. It owns
+// the queries depending on the version and the values of the columns not set from the analysis. Reading is done
+// using the columns of the bmDoc types, which every version has to provide, see bmDocTableColumns.
+type schemaAdapter struct {
+	version int
+	// columnDefaults are inserted into the columns not set from the analysis, per table.
+	columnDefaults         map[string]map[string]any
+	updateBmDocBelegQuery  string
+	restoreBmDocBelegQuery string
+}
+
+// schemaAdapters is the compatibility matrix, listing the BelegManager database versions Hermine has been tested
+// with, oldest first.
+var schemaAdapters = []*schemaAdapter{
+	schemaAdapter22003,
+}
+
+var schemaAdapter22003 = &schemaAdapter{
+	version: 22003,
+	columnDefaults: map[string]map[string]any{
+		"BmDoc_Asset": {
+			"docType": 4, "deleteState": 0, "sync": 1, "needUpSync": 1, "needDownSync": 0,
+			"targetDocType": 3, "ocrState": 0, "fileSyncState": 2,
+		},
+		"BmDoc_Beleg": {
+			"docType": 3, "deleteState": 0, "sync": 1, "needUpSync": 1, "needDownSync": 0,
+			"netto": 0,
+		},
+		"BmDoc_Kategorie": {
+			"docType": 1, "deleteState": 0, "sync": 1, "needUpSync": 1, "needDownSync": 0,
+		},
+		"BmDoc_Label": {
+			"docType": 5, "deleteState": 0, "sync": 1, "needUpSync": 1, "needDownSync": 0,
+		},
+		"BmDoc_LinkTable": {},
+		"BmDoc_Person": {
+			"docType": 2, "deleteState": 0, "sync": 1, "needUpSync": 1, "needDownSync": 0,
+		},
+	},
+	updateBmDocBelegQuery:  "UPDATE BmDoc_Beleg SET name = ?, docDate = ?, number = ?, amount = ?, netto = ?, vat = ?, comment = ?, belegDate = ? WHERE id = ?",
+	restoreBmDocBelegQuery: "UPDATE BmDoc_Beleg SET name = ?, docDate = ?, number = ?, amount = ?, netto = ?, vat = ?, comment = ?, belegDate = ? WHERE id = ? AND uuid = ?",
+}
+
+// bmDocTableColumns are the columns read from each table, see the bmDoc types.
+var bmDocTableColumns = map[string]string{
+	"BmDoc_Asset":      bmDocAssetColumns,
+	"BmDoc_Beleg":      bmDocBelegColumns,
+	"BmDoc_Kategorie":  bmDocCategoryColumns,
+	"BmDoc_Label":      bmDocLabelColumns,
+	"BmDoc_LinkTable":  bmDocLinkColumns,
+	"BmDoc_Person":     bmDocPersonColumns,
+	"BmDoc_Steuerfall": bmDocSteuerfallColumns,
+}
+
+// tableColumn is a row of "PRAGMA table_info".
+type tableColumn struct {
+	Name       string `db:"name"`
+	NotNull    bool   `db:"notnull"`
+	HasDefault bool   `db:"hasDefault"`
+	PK         int    `db:"pk"`
+}
+
+// latestSchemaAdapter is used for databases not opened by OpenBelegManagerDB.
+func latestSchemaAdapter() *schemaAdapter {
+	return schemaAdapters[len(schemaAdapters)-1]
+}
+
+// findSchemaAdapter returns the adapter of the version. For an untested version, it returns the adapter of the newest
+// older tested version, or the oldest adapter, if allowed, else a SchemaVersionError.
+func findSchemaAdapter(version int, allowUntested bool) (adapter *schemaAdapter, tested bool, err error) {
+	fallback := schemaAdapters[0]
+	for _, a := range schemaAdapters {
+		if a.version == version {
+			return a, true, nil
+		}
+		if a.version < version {
+			fallback = a
+		}
+	}
+
+	if !allowUntested {
+		return nil, false, &SchemaVersionError{Found: version, Expected: latestSchemaAdapter().version}
+	}
+	return fallback, false, nil
+}
+
+// TestedSchemaVersions returns the BelegManager database versions Hermine has been tested with.
+func TestedSchemaVersions() []int {
+	versions := make([]int, 0, len(schemaAdapters))
+	for _, a := range schemaAdapters {
+		versions = append(versions, a.version)
+	}
+	return versions
+}
+
+// insertQuery returns the query inserting the values and the column defaults into the table, and its arguments.
+func (a *schemaAdapter) insertQuery(table string, values map[string]any) (string, []any) {
+	row := maps.Clone(a.columnDefaults[table])
+	if row == nil {
+		row = make(map[string]any, len(values))
+	}
+	maps.Copy(row, values)
+
+	columns := slices.Sorted(maps.Keys(row))
+	args := make([]any, 0, len(columns))
+	for _, column := range columns {
+		args = append(args, row[column])
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(columns)), ",")
+	query := fmt.Sprintf("INSERT OR IGNORE INTO %s (%s) VALUES (%s)", table, strings.Join(columns, ", "), placeholders)
+	return query, args
+}
+
+func (a *schemaAdapter) columnDefault(table, column string) any {
+	return a.columnDefaults[table][column]
+}
+
+// checkColumnLayout checks the tables of the database fit the adapter: each column read or written has to exist,
+// and each column not known to the adapter must be nullable or have a default, so inserting succeeds.
+func checkColumnLayout(db *sqlx.DB, adapter *schemaAdapter) error {
+	var problems []string
+	for _, table := range slices.Sorted(maps.Keys(bmDocTableColumns)) {
+		var tableColumns []tableColumn
+		if queryErr := db.Select(&tableColumns, selectTableInfoQuery, table); queryErr != nil {
+			return fmt.Errorf("failed to read the columns of %s: %w", table, queryErr)
+		}
+
+		knownColumns := strings.Split(bmDocTableColumns[table], ", ")
+		for column := range adapter.columnDefaults[table] {
+			if !slices.Contains(knownColumns, column) {
+				knownColumns = append(knownColumns, column)
+			}
+		}
+		for _, column := range knownColumns {
+			if !slices.ContainsFunc(tableColumns, func(c tableColumn) bool { return c.Name == column }) {
+				problems = append(problems, fmt.Sprintf("%s.%s missing", table, column))
+			}
+		}
+		for _, column := range tableColumns {
+			if column.NotNull && !column.HasDefault && column.PK == 0 && !slices.Contains(knownColumns, column.Name) {
+				problems = append(problems, fmt.Sprintf("%s.%s required, but unknown", table, column.Name))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: columns incompatible with version %d: %s",
+			ErrUnsupportedSchemaVersion, adapter.version, strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+// selectSchemaAdapter selects the adapter for the version of the database, checking the column layout of untested
+// versions.
+func selectSchemaAdapter(db *sqlx.DB, version int, allowUntested bool) (*schemaAdapter, error) {
+	adapter, tested, findErr := findSchemaAdapter(version, allowUntested)
+	if findErr != nil {
+		return nil, findErr
+	}
+	if tested {
+		return adapter, nil
+	}
+
+	if layoutErr := checkColumnLayout(db, adapter); layoutErr != nil {
+		return nil, layoutErr
+	}
+	log.
+		WithField("version", version).
+		WithField("adapter_version", adapter.version).
+		Warn("BelegManager database version not tested, its columns fit the adapter of a tested version")
+	return adapter, nil
+}
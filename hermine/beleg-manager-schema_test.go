@@ -0,0 +1,133 @@
+package hermine
+
+import (
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func Test_schemaAdapter_insertQuery(t *testing.T) {
+	t.Parallel()
+
+	// when
+	query, args := schemaAdapter22003.insertQuery("BmDoc_Kategorie", map[string]any{"uuid": "{u}", "name": "Name", "sync": 0})
+
+	// then
+	assert.Equal(t,
+		"INSERT OR IGNORE INTO BmDoc_Kategorie (deleteState, docType, name, needDownSync, needUpSync, sync, uuid) VALUES (?,?,?,?,?,?,?)",
+		query)
+	assert.Equal(t, []any{0, 1, "Name", 0, 1, 0, "{u}"}, args, "values override column defaults")
+}
+
+func Test_findSchemaAdapter(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name          string
+		version       int
+		allowUntested bool
+		wantAdapter   *schemaAdapter
+		wantTested    bool
+		wantErr       bool
+	}{
+		{name: "tested", version: 22003, wantAdapter: schemaAdapter22003, wantTested: true},
+		{name: "untested", version: 22100, wantErr: true},
+		{name: "untested newer allowed", version: 22100, allowUntested: true, wantAdapter: schemaAdapter22003},
+		{name: "untested older allowed", version: 21000, allowUntested: true, wantAdapter: schemaAdapter22003},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			// when
+			adapter, tested, findErr := findSchemaAdapter(tt.version, tt.allowUntested)
+
+			// then
+			if tt.wantErr {
+				var versionErr *SchemaVersionError
+				require.ErrorAs(t, findErr, &versionErr)
+				assert.Equal(t, tt.version, versionErr.Found)
+				assert.Equal(t, belMngrSupportedDBVersion, versionErr.Expected)
+				return
+			}
+			require.NoError(t, findErr)
+			assert.Same(t, tt.wantAdapter, adapter)
+			assert.Equal(t, tt.wantTested, tested)
+		})
+	}
+}
+
+func Test_OpenBelegManagerDB_allowUntestedSchema(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		alterQuery  string
+		wantErrText string
+	}{
+		{name: "added nullable column", alterQuery: "ALTER TABLE BmDoc_Kategorie ADD COLUMN color TEXT"},
+		{name: "added column with default", alterQuery: "ALTER TABLE BmDoc_Kategorie ADD COLUMN color TEXT NOT NULL DEFAULT 'red'"},
+		{name: "removed column", alterQuery: "ALTER TABLE BmDoc_Beleg DROP COLUMN comment", wantErrText: "BmDoc_Beleg.comment missing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			// given
+			dbFilePath := copyDatabaseFixtureIntoDirectory(t, t.TempDir())
+			alteringDB := sqlx.MustOpen("sqlite", "file:"+dbFilePath)
+			_, alterErr := alteringDB.Exec("UPDATE Property SET propertyValue = 22100 WHERE propertyKey = 'databaseVersion'; " + tt.alterQuery)
+			require.NoError(t, alterErr)
+			CloseDB(alteringDB)
+
+			// when
+			_, notAllowedErr := OpenBelegManagerDB(dbFilePath, DatabaseOptions{})
+			db, openErr := OpenBelegManagerDB(dbFilePath, DatabaseOptions{AllowUntestedSchema: true})
+
+			// then
+			require.ErrorIs(t, notAllowedErr, ErrUnsupportedSchemaVersion)
+			if tt.wantErrText != "" {
+				require.ErrorIs(t, openErr, ErrUnsupportedSchemaVersion)
+				assert.ErrorContains(t, openErr, tt.wantErrText)
+				return
+			}
+			require.NoError(t, openErr)
+			t.Cleanup(func() {
+				CloseDB(db)
+			})
+			assert.Same(t, schemaAdapter22003, schemaAdapterOf(db))
+
+			tx, beginErr := beginTransaction(db, false)
+			require.NoError(t, beginErr)
+			insertQuery, args := tx.schema.insertQuery("BmDoc_Kategorie", map[string]any{"uuid": newBmDocUUID(), "name": "Untested"})
+			_, insertErr := tx.Exec(insertQuery, args...)
+			require.NoError(t, insertErr)
+			category, findErr := findBmDocCategoryByName(newDummyLogEntry(t), tx, "Untested")
+			require.NoError(t, findErr)
+			assert.NotNil(t, category)
+			require.NoError(t, tx.Commit())
+		})
+	}
+}
+
+func Test_checkColumnLayout_requiredUnknownColumn(t *testing.T) {
+	t.Parallel()
+
+	// given
+	dbFilePath := copyDatabaseFixtureIntoDirectory(t, t.TempDir())
+	db := openPlainDatabase(t, dbFilePath)
+	_, alterErr := db.Exec("DROP TABLE BmDoc_Asset; " +
+		"CREATE TABLE BmDoc_Asset (id INTEGER PRIMARY KEY, uuid TEXT, name TEXT, docType INTEGER, deleteState INTEGER, " +
+		"docDate TEXT, timestampCreated TEXT, unread INTEGER, sync INTEGER, needUpSync INTEGER, needDownSync INTEGER, " +
+		"timestampLastSync TEXT, targetDocType INTEGER, ocrState INTEGER, internalPath TEXT, fileSyncState INTEGER, " +
+		"checksum TEXT NOT NULL)")
+	require.NoError(t, alterErr)
+
+	// when
+	layoutErr := checkColumnLayout(db, schemaAdapter22003)
+
+	// then
+	require.ErrorIs(t, layoutErr, ErrUnsupportedSchemaVersion)
+	assert.ErrorContains(t, layoutErr, "BmDoc_Asset.checksum required, but unknown")
+}
@@ -2,6 +2,18 @@ package hermine
 
 const bmDocRFC3339Milli = "2006-01-02T15:04:05.000Z"
 
+// The columns of the bmDoc types, selected explicitly, so columns added by BelegManager do not break reading.
+const (
+	bmDocEntityColumns     = "id, uuid, name, docType, deleteState, docDate, timestampCreated, unread, sync, needUpSync, needDownSync, timestampLastSync"
+	bmDocAssetColumns      = bmDocEntityColumns + ", targetDocType, ocrState, internalPath, fileSyncState"
+	bmDocBelegColumns      = bmDocEntityColumns + ", number, amount, netto, vat, comment, belegDate"
+	bmDocCategoryColumns   = bmDocEntityColumns
+	bmDocLabelColumns      = bmDocEntityColumns
+	bmDocLinkColumns       = "id, sourceUuid, targetUuid"
+	bmDocPersonColumns     = bmDocEntityColumns
+	bmDocSteuerfallColumns = bmDocEntityColumns + ", steuerart, steuerjahr, filePath"
+)
+
 type sqlxSelecter interface {
 	Select(dest interface{}, query string, args ...interface{}) error
 }
@@ -60,3 +72,21 @@ type bmDocLink struct {
 type bmDocCategory struct {
 	bmDocEntity
 }
+
+// bmDocLabel corresponds to the BmDoc_Label table.
+type bmDocLabel struct {
+	bmDocEntity
+}
+
+// bmDocPerson corresponds to the BmDoc_Person table.
+type bmDocPerson struct {
+	bmDocEntity
+}
+
+// bmDocSteuerfall corresponds to the BmDoc_Steuerfall table, a tax case of a year.
+type bmDocSteuerfall struct {
+	bmDocEntity
+	Steuerart  *string `db:"steuerart"`  // TEXT, nullable
+	Steuerjahr *string `db:"steuerjahr"` // TEXT, nullable
+	FilePath   *string `db:"filePath"`   // TEXT, nullable
+}
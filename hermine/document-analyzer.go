@@ -0,0 +1,25 @@
+package hermine
+
+import (
+	"context"
+	log "github.com/sirupsen/logrus"
+)
+
+// DocumentAnalyzer analyzes a local document, e.g. by using Azure AI Document Intelligence.
+// Implementations must be safe for concurrent use, as ProcessFiles analyzes files in parallel,
+// and must stop analyzing when ctx is done.
+type DocumentAnalyzer interface {
+	Analyze(ctx context.Context, logger *log.Entry, pathOfFileToImport string) (*diAnalyzeResult, error)
+}
+
+// Aliases of the analysis result types, allowing DocumentAnalyzer implementations outside this package.
+type (
+	DIAnalyzeResult     = diAnalyzeResult
+	DIDocument          = diDocument
+	DIDocumentField     = diDocumentField
+	DIDocumentFieldItem = diDocumentFieldItem
+	DIBoundingRegion    = diBoundingRegion
+	DISpan              = diSpan
+	DICurrency          = diCurrency
+	DIAddress           = diAddress
+)
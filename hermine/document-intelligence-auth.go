@@ -0,0 +1,263 @@
+package hermine
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1" //nolint:gosec // the "x5t" certificate thumbprint is defined as SHA-1
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultEntraIDAuthorityHost = "https://login.microsoftonline.com"
+	entraIDScope                = "https://cognitiveservices.azure.com/.default"
+	entraIDClientAssertionType  = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+	// entraIDTokenRefreshMargin renews tokens this long before they expire.
+	entraIDTokenRefreshMargin = 5 * time.Minute
+)
+
+// diAuthenticator authenticates requests to Document Intelligence.
+type diAuthenticator interface {
+	authenticate(ctx context.Context, req *http.Request) error
+}
+
+// keyAuthenticator authenticates using the key of the Document Intelligence resource.
+type keyAuthenticator struct {
+	diKey string
+}
+
+func (a *keyAuthenticator) authenticate(_ context.Context, req *http.Request) error {
+	req.Header.Set("Ocp-Apim-Subscription-Key", a.diKey)
+	return nil
+}
+
+// EntraIDConfig configures authenticating with a Microsoft Entra ID app registration (service principal), using
+// the OAuth2 client credentials flow. Either ClientSecret or Certificate has to be set.
+type EntraIDConfig struct {
+	TenantID     string
+	ClientID     string
+	ClientSecret string
+	Certificate  *EntraIDCertificate
+	// AuthorityHost defaults to DefaultEntraIDAuthorityHost.
+	AuthorityHost string
+}
+
+// EntraIDCertificate is a client certificate and its private key, authenticating an Entra ID app registration.
+type EntraIDCertificate struct {
+	certificate *x509.Certificate
+	privateKey  *rsa.PrivateKey
+}
+
+// LoadEntraIDCertificate reads a PEM file containing a certificate and its unencrypted RSA private key.
+func LoadEntraIDCertificate(path string) (*EntraIDCertificate, error) {
+	content, readErr := os.ReadFile(path)
+	if readErr != nil {
+		return nil, readErr
+	}
+
+	var entraIDCertificate EntraIDCertificate
+	for block, rest := pem.Decode(content); block != nil; block, rest = pem.Decode(rest) {
+		switch block.Type {
+		case "CERTIFICATE":
+			if entraIDCertificate.certificate != nil {
+				continue
+			}
+			certificate, parseErr := x509.ParseCertificate(block.Bytes)
+			if parseErr != nil {
+				return nil, fmt.Errorf("failed to parse certificate of '%s': %w", path, parseErr)
+			}
+			entraIDCertificate.certificate = certificate
+		case "PRIVATE KEY", "RSA PRIVATE KEY":
+			privateKey, parseErr := parseRSAPrivateKey(block)
+			if parseErr != nil {
+				return nil, fmt.Errorf("failed to parse private key of '%s': %w", path, parseErr)
+			}
+			entraIDCertificate.privateKey = privateKey
+		}
+	}
+
+	if entraIDCertificate.certificate == nil || entraIDCertificate.privateKey == nil {
+		return nil, fmt.Errorf("'%s' does not contain both a certificate and an RSA private key", path)
+	}
+	return &entraIDCertificate, nil
+}
+
+func parseRSAPrivateKey(block *pem.Block) (*rsa.PrivateKey, error) {
+	if block.Type == "RSA PRIVATE KEY" {
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+
+	privateKey, parseErr := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if parseErr != nil {
+		return nil, parseErr
+	}
+	rsaPrivateKey, isRSA := privateKey.(*rsa.PrivateKey)
+	if !isRSA {
+		return nil, errors.New("private key is not an RSA key")
+	}
+
+	return rsaPrivateKey, nil
+}
+
+// entraIDAuthenticator authenticates using bearer tokens, which are cached until shortly before they expire.
+type entraIDAuthenticator struct {
+	config   EntraIDConfig
+	tokenURL string
+	hc       *http.Client
+
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+func newEntraIDAuthenticator(config EntraIDConfig, hc *http.Client) *entraIDAuthenticator {
+	authorityHost := config.AuthorityHost
+	if authorityHost == "" {
+		authorityHost = DefaultEntraIDAuthorityHost
+	}
+
+	return &entraIDAuthenticator{
+		config:   config,
+		tokenURL: fmt.Sprintf("%s/%s/oauth2/v2.0/token", strings.TrimRight(authorityHost, "/"), url.PathEscape(config.TenantID)),
+		hc:       hc,
+	}
+}
+
+func (a *entraIDAuthenticator) authenticate(ctx context.Context, req *http.Request) error {
+	token, tokenErr := a.accessToken(ctx)
+	if tokenErr != nil {
+		return tokenErr
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+func (a *entraIDAuthenticator) accessToken(ctx context.Context) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.token != "" && time.Now().Before(a.expiresAt.Add(-entraIDTokenRefreshMargin)) {
+		return a.token, nil
+	}
+
+	log.WithField("token_url", a.tokenURL).Debug("Requesting Entra ID access token")
+	token, expiresIn, requestErr := a.requestToken(ctx)
+	if requestErr != nil {
+		return "", requestErr
+	}
+	a.token = token
+	a.expiresAt = time.Now().Add(expiresIn)
+
+	return a.token, nil
+}
+
+type entraIDTokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	ExpiresIn        int    `json:"expires_in"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+func (a *entraIDAuthenticator) requestToken(ctx context.Context) (string, time.Duration, error) {
+	form := url.Values{
+		"grant_type": {"client_credentials"},
+		"client_id":  {a.config.ClientID},
+		"scope":      {entraIDScope},
+	}
+	if a.config.Certificate != nil {
+		clientAssertion, assertionErr := a.clientAssertion()
+		if assertionErr != nil {
+			return "", 0, assertionErr
+		}
+		form.Set("client_assertion_type", entraIDClientAssertionType)
+		form.Set("client_assertion", clientAssertion)
+	} else {
+		form.Set("client_secret", a.config.ClientSecret)
+	}
+
+	req, newRequestErr := http.NewRequestWithContext(ctx, http.MethodPost, a.tokenURL, strings.NewReader(form.Encode()))
+	if newRequestErr != nil {
+		return "", 0, newRequestErr
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, respErr := a.hc.Do(req)
+	if respErr != nil {
+		return "", 0, fmt.Errorf("failed to request Entra ID access token: %w", respErr)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	body, readErr := io.ReadAll(resp.Body)
+	if readErr != nil {
+		return "", 0, readErr
+	}
+	var tokenResponse entraIDTokenResponse
+	if unmarshalErr := json.Unmarshal(body, &tokenResponse); unmarshalErr != nil && resp.StatusCode == http.StatusOK {
+		return "", 0, unmarshalErr
+	}
+	if resp.StatusCode != http.StatusOK || tokenResponse.AccessToken == "" {
+		return "", 0, fmt.Errorf("failed to get Entra ID access token, status: %d, error: %s %s",
+			resp.StatusCode, tokenResponse.Error, tokenResponse.ErrorDescription)
+	}
+
+	return tokenResponse.AccessToken, time.Duration(tokenResponse.ExpiresIn) * time.Second, nil
+}
+
+// clientAssertion creates the JWT proving possession of the certificate, see
+// https://learn.microsoft.com/en-us/entra/identity-platform/certificate-credentials.
+func (a *entraIDAuthenticator) clientAssertion() (string, error) {
+	certificate := a.config.Certificate
+	thumbprint := sha1.Sum(certificate.certificate.Raw) //nolint:gosec // see import
+
+	header := map[string]string{
+		"alg": "RS256",
+		"typ": "JWT",
+		"x5t": base64.RawURLEncoding.EncodeToString(thumbprint[:]),
+	}
+	now := time.Now()
+	claims := map[string]any{
+		"aud": a.tokenURL,
+		"iss": a.config.ClientID,
+		"sub": a.config.ClientID,
+		"jti": uuid.NewString(),
+		"nbf": now.Unix(),
+		"exp": now.Add(10 * time.Minute).Unix(),
+	}
+
+	headerJSON, headerErr := json.Marshal(header)
+	if headerErr != nil {
+		return "", headerErr
+	}
+	claimsJSON, claimsErr := json.Marshal(claims)
+	if claimsErr != nil {
+		return "", claimsErr
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(headerJSON) + "." + base64.RawURLEncoding.EncodeToString(claimsJSON)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, signErr := rsa.SignPKCS1v15(rand.Reader, certificate.privateKey, crypto.SHA256, digest[:])
+	if signErr != nil {
+		return "", signErr
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
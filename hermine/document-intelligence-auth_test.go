@@ -0,0 +1,177 @@
+package hermine
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_entraIDAuthenticator_clientSecret(t *testing.T) {
+	// given
+	var tokenRequests atomic.Int32
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenRequests.Add(1)
+		assert.Equal(t, "/tenant/oauth2/v2.0/token", r.URL.Path)
+		assert.NoError(t, r.ParseForm())
+		assert.Equal(t, "client_credentials", r.PostForm.Get("grant_type"))
+		assert.Equal(t, "client", r.PostForm.Get("client_id"))
+		assert.Equal(t, "secret", r.PostForm.Get("client_secret"))
+		assert.Equal(t, entraIDScope, r.PostForm.Get("scope"))
+		_, _ = w.Write([]byte(`{"access_token": "token", "expires_in": 3600}`))
+	}))
+	t.Cleanup(tokenServer.Close)
+
+	config := EntraIDConfig{TenantID: "tenant", ClientID: "client", ClientSecret: "secret", AuthorityHost: tokenServer.URL}
+	authenticator := newEntraIDAuthenticator(config, tokenServer.Client())
+
+	// when
+	for range 3 {
+		req, newRequestErr := http.NewRequest(http.MethodGet, "https://example.com", nil)
+		require.NoError(t, newRequestErr)
+		require.NoError(t, authenticator.authenticate(context.Background(), req))
+
+		// then
+		assert.Equal(t, "Bearer token", req.Header.Get("Authorization"))
+	}
+	assert.EqualValues(t, 1, tokenRequests.Load(), "token is cached")
+}
+
+func Test_entraIDAuthenticator_refreshesExpiringToken(t *testing.T) {
+	var tokenRequests atomic.Int32
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		tokenRequests.Add(1)
+		_, _ = w.Write([]byte(`{"access_token": "token", "expires_in": 60}`))
+	}))
+	t.Cleanup(tokenServer.Close)
+
+	config := EntraIDConfig{TenantID: "tenant", ClientID: "client", ClientSecret: "secret", AuthorityHost: tokenServer.URL}
+	authenticator := newEntraIDAuthenticator(config, tokenServer.Client())
+
+	for range 2 {
+		_, tokenErr := authenticator.accessToken(context.Background())
+		require.NoError(t, tokenErr)
+	}
+	assert.EqualValues(t, 2, tokenRequests.Load(), "token expiring within the refresh margin is renewed")
+}
+
+func Test_entraIDAuthenticator_tokenError(t *testing.T) {
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"error": "invalid_client", "error_description": "AADSTS7000215: Invalid client secret"}`))
+	}))
+	t.Cleanup(tokenServer.Close)
+
+	config := EntraIDConfig{TenantID: "tenant", ClientID: "client", ClientSecret: "wrong", AuthorityHost: tokenServer.URL}
+	authenticator := newEntraIDAuthenticator(config, tokenServer.Client())
+	req, newRequestErr := http.NewRequest(http.MethodGet, "https://example.com", nil)
+	require.NoError(t, newRequestErr)
+
+	authErr := authenticator.authenticate(context.Background(), req)
+
+	require.ErrorContains(t, authErr, "invalid_client")
+	assert.Empty(t, req.Header.Get("Authorization"))
+}
+
+func Test_entraIDAuthenticator_certificate(t *testing.T) {
+	// given
+	certificatePath, privateKey := writeTestCertificate(t)
+	certificate, loadErr := LoadEntraIDCertificate(certificatePath)
+	require.NoError(t, loadErr)
+
+	var tokenURL string
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, r.ParseForm())
+		assert.Empty(t, r.PostForm.Get("client_secret"))
+		assert.Equal(t, entraIDClientAssertionType, r.PostForm.Get("client_assertion_type"))
+		assertClientAssertion(t, r.PostForm.Get("client_assertion"), &privateKey.PublicKey, tokenURL)
+		_, _ = w.Write([]byte(`{"access_token": "token", "expires_in": 3600}`))
+	}))
+	t.Cleanup(tokenServer.Close)
+
+	config := EntraIDConfig{TenantID: "tenant", ClientID: "client", Certificate: certificate, AuthorityHost: tokenServer.URL}
+	authenticator := newEntraIDAuthenticator(config, tokenServer.Client())
+	tokenURL = authenticator.tokenURL
+
+	// when
+	token, tokenErr := authenticator.accessToken(context.Background())
+
+	// then
+	require.NoError(t, tokenErr)
+	assert.Equal(t, "token", token)
+}
+
+func Test_LoadEntraIDCertificate_withoutPrivateKey(t *testing.T) {
+	certificatePath, _ := writeTestCertificate(t)
+	content, readErr := os.ReadFile(certificatePath)
+	require.NoError(t, readErr)
+	certificateOnly, _ := pem.Decode(content)
+	require.NoError(t, os.WriteFile(certificatePath, pem.EncodeToMemory(certificateOnly), 0o600))
+
+	_, loadErr := LoadEntraIDCertificate(certificatePath)
+
+	require.Error(t, loadErr)
+}
+
+func assertClientAssertion(t *testing.T, clientAssertion string, publicKey *rsa.PublicKey, expectedAudience string) {
+	t.Helper()
+
+	parts := strings.Split(clientAssertion, ".")
+	if !assert.Len(t, parts, 3) {
+		return
+	}
+	signature, decodeErr := base64.RawURLEncoding.DecodeString(parts[2])
+	assert.NoError(t, decodeErr)
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	assert.NoError(t, rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest[:], signature))
+
+	claimsJSON, decodeErr := base64.RawURLEncoding.DecodeString(parts[1])
+	assert.NoError(t, decodeErr)
+	var claims map[string]any
+	assert.NoError(t, json.Unmarshal(claimsJSON, &claims))
+	assert.Equal(t, expectedAudience, claims["aud"])
+	assert.Equal(t, "client", claims["iss"])
+	assert.Equal(t, "client", claims["sub"])
+	assert.NotEmpty(t, claims["jti"])
+}
+
+func writeTestCertificate(t *testing.T) (string, *rsa.PrivateKey) {
+	t.Helper()
+
+	privateKey, generateErr := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, generateErr)
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "hermine"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certificateDER, createErr := x509.CreateCertificate(rand.Reader, &template, &template, &privateKey.PublicKey, privateKey)
+	require.NoError(t, createErr)
+	privateKeyDER, marshalErr := x509.MarshalPKCS8PrivateKey(privateKey)
+	require.NoError(t, marshalErr)
+
+	content := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificateDER})
+	content = append(content, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateKeyDER})...)
+	certificatePath := filepath.Join(t.TempDir(), "hermine.pem")
+	require.NoError(t, os.WriteFile(certificatePath, content, 0o600))
+
+	return certificatePath, privateKey
+}
@@ -8,7 +8,51 @@ import (
 	"time"
 )
 
-const documentTypeInvoice = "invoice"
+const (
+	documentTypeInvoice = "invoice"
+	// documentTypeReceipt is the prefix of the receipt document types, e.g. "receipt.retailMeal".
+	documentTypeReceipt = "receipt"
+)
+
+// belegFieldMapping names the fields of an analyzed document, which are mapped onto a BmDoc_Beleg.
+type belegFieldMapping struct {
+	documentKind string
+	vendor       string
+	customer     string
+	number       string
+	belegDate    string
+	total        string
+	vat          string
+	// nameTemplate and commentTemplate replace the generated name and comment, if set, see FieldMapping.
+	nameTemplate    string
+	commentTemplate string
+	// categories name the fields, whose contents become categories of the Beleg.
+	categories []string
+}
+
+func invoiceFieldMapping() belegFieldMapping {
+	return belegFieldMapping{
+		documentKind: "Invoice",
+		vendor:       "VendorName",
+		customer:     "CustomerName",
+		number:       "InvoiceId",
+		belegDate:    "InvoiceDate",
+		total:        "InvoiceTotal",
+		vat:          "TaxDetails",
+		categories:   []string{"CustomerName", "VendorName"},
+	}
+}
+
+func receiptFieldMapping() belegFieldMapping {
+	return belegFieldMapping{
+		documentKind: "Receipt",
+		vendor:       "MerchantName",
+		belegDate:    "TransactionDate",
+		total:        "Total",
+		vat:          "TaxDetails",
+		categories:   []string{"MerchantName"},
+	}
+}
 
 type diAnalysisStatus struct {
 	Status              string           `json:"status"`
@@ -26,6 +70,9 @@ type diAnalyzeResult struct {
 	Pages           []map[string]any `json:"pages"`
 	Tables          []map[string]any `json:"tables"`
 	Documents       []diDocument     `json:"documents"`
+
+	// rawAnalysisStatus is the response body of the "Get Analyze Result" API containing the result, if received from it
+	rawAnalysisStatus []byte
 }
 
 type diDocument struct {
@@ -34,6 +81,11 @@ type diDocument struct {
 	Fields          map[string]diDocumentField `json:"fields"`
 	Confidence      float64                    `json:"confidence"`
 	Spans           []diSpan                   `json:"spans"`
+
+	// customMapping is the configured mapping of a custom model's document, see FieldMapping.
+	customMapping *FieldMapping
+	// modelID is the model the document has been analyzed with.
+	modelID string
 }
 
 type diBoundingRegion struct {
@@ -85,20 +137,37 @@ type diDocumentFieldItem struct {
 }
 
 func (d *diAnalysisStatus) isStatusRunning() bool {
-	return d.Status == "running"
+	return d.Status == "running" || d.Status == "notStarted"
 }
 
 func (d *diAnalysisStatus) isStatusSucceeded() bool {
 	return d.Status == "succeeded"
 }
 
+// fieldMapping returns the mapping for the document type, receipts are mapped like invoices by default.
+func (d *diDocument) fieldMapping() belegFieldMapping {
+	if d.customMapping != nil {
+		return d.customMapping.belegFieldMapping()
+	}
+	if d.isTypeReceipt() {
+		return receiptFieldMapping()
+	}
+
+	return invoiceFieldMapping()
+}
+
 func (d *diDocument) createComment() string {
-	items := d.Fields["Items"].ValueArray
-	names := make([]string, len(*items))
-	for n, item := range *items {
-		itemDescription := item.ValueObject["Description"].Content
-		itemContent := strings.ReplaceAll(itemDescription, "\n", " ")
-		names[n] = "- " + itemContent
+	if commentTemplate := d.fieldMapping().commentTemplate; commentTemplate != "" {
+		return d.expandFieldTemplate(commentTemplate)
+	}
+
+	names := make([]string, 0)
+	if items := d.Fields["Items"].ValueArray; items != nil {
+		for _, item := range *items {
+			itemDescription := item.ValueObject["Description"].Content
+			itemContent := strings.ReplaceAll(itemDescription, "\n", " ")
+			names = append(names, "- "+itemContent)
+		}
 	}
 	itemNamesTextBlock := strings.Join(names, "\n")
 
@@ -107,13 +176,17 @@ func (d *diDocument) createComment() string {
 		confidenceText = fmt.Sprintf("%.2f", *grossConfidence)
 	}
 
-	return fmt.Sprintf("%s\n\nInvoiceTotal confidence: %s", itemNamesTextBlock, confidenceText)
+	return fmt.Sprintf("%s\n\n%s confidence: %s", itemNamesTextBlock, d.fieldMapping().total, confidenceText)
 }
 
 func (d *diDocument) createInvoiceName() string {
 	fields := d.Fields
+	mapping := d.fieldMapping()
+	if mapping.nameTemplate != "" {
+		return d.expandFieldTemplate(mapping.nameTemplate)
+	}
 
-	vendorName := fields["VendorName"].Content
+	vendorName := fields[mapping.vendor].Content
 	vendorName = strings.ReplaceAll(vendorName, "\n", " ")
 
 	items := d.Fields["Items"].ValueArray
@@ -127,9 +200,36 @@ func (d *diDocument) createInvoiceName() string {
 		return fmt.Sprintf("%s from %s", itemContent, vendorName)
 	}
 
-	customerName := fields["CustomerName"].Content
+	if mapping.vendor == "" {
+		return mapping.documentKind
+	}
+	if mapping.customer == "" {
+		return fmt.Sprintf("%s from %s", mapping.documentKind, vendorName)
+	}
+
+	customerName := fields[mapping.customer].Content
 	customerName = strings.ReplaceAll(customerName, "\n", " ")
-	return fmt.Sprintf("Invoice %s from %s to %s", fields["InvoiceId"].Content, vendorName, customerName)
+	return fmt.Sprintf("%s %s from %s to %s", mapping.documentKind, fields[mapping.number].Content, vendorName, customerName)
+}
+
+func (d *diDocument) getVendor() string {
+	vendor := d.fieldMapping().vendor
+	if vendor == "" {
+		return ""
+	}
+
+	return strings.ReplaceAll(d.Fields[vendor].Content, "\n", " ")
+}
+
+func (d *diDocument) getItemDescriptions() []string {
+	descriptions := make([]string, 0)
+	if items := d.Fields["Items"].ValueArray; items != nil {
+		for _, item := range *items {
+			descriptions = append(descriptions, strings.ReplaceAll(item.ValueObject["Description"].Content, "\n", " "))
+		}
+	}
+
+	return descriptions
 }
 
 func (d *diDocument) getContentFieldCommaSeperated(fieldName string) string {
@@ -140,45 +240,82 @@ func (d *diDocument) getContentFieldCommaSeperated(fieldName string) string {
 	return commaContent
 }
 
+func (d *diDocument) getNumber() string {
+	number := d.fieldMapping().number
+	if number == "" {
+		return ""
+	}
+
+	return d.Fields[number].Content
+}
+
+func (d *diDocument) getBelegDate() *string {
+	belegDate := d.fieldMapping().belegDate
+	if belegDate == "" {
+		return nil
+	}
+
+	return d.Fields[belegDate].ValueDate
+}
+
 func (d *diDocument) getGross() *float64 {
-	fields := d.Fields
-	if field, exists := fields["InvoiceTotal"]; exists {
+	total := d.fieldMapping().total
+	if field, exists := d.Fields[total]; exists && field.ValueCurrency != nil {
 		return &field.ValueCurrency.Amount
+	} else if exists && field.ValueNumber != nil {
+		return field.ValueNumber
 	}
 
-	log.Debug("Field 'InvoiceTotal' not found in document analysis for gross")
+	log.Debugf("Field '%s' not found in document analysis for gross", total)
 	return nil
 }
 
 func (d *diDocument) getGrossConfidence() *float64 {
-	fields := d.Fields
-	if field, exists := fields["InvoiceTotal"]; exists {
+	total := d.fieldMapping().total
+	if field, exists := d.Fields[total]; exists {
 		return &field.Confidence
 	}
 
-	log.Debug("Field 'InvoiceTotal' not found in document analysis for gross confidence")
+	log.Debugf("Field '%s' not found in document analysis for gross confidence", total)
 	return nil
 }
 
 func (d *diDocument) getVat() *float64 {
-	taxDetails, taxDetailsExists := d.Fields["TaxDetails"]
-	if !taxDetailsExists {
+	vatField, vatFieldExists := d.Fields[d.fieldMapping().vat]
+	if !vatFieldExists {
 		return nil
 	}
+	if vatField.ValueArray == nil {
+		// Custom models may provide the rate as plain field
+		return parseVatRate(vatField)
+	}
 
-	if len(*taxDetails.ValueArray) != 1 {
-		log.Debugf("Not exact one but %d TaxDetails from analysis", len(*taxDetails.ValueArray))
+	if len(*vatField.ValueArray) != 1 {
+		log.Debugf("Not exact one but %d TaxDetails from analysis", len(*vatField.ValueArray))
 		return nil
 	}
 
-	taxDetail := (*taxDetails.ValueArray)[0]
-	taxRateObject := taxDetail.ValueObject["Rate"]
+	taxDetail := (*vatField.ValueArray)[0]
+	return parseVatRate(taxDetail.ValueObject["Rate"])
+}
+
+// parseVatRate returns the rate in percent, given as content like "19%" or as number like 0.19.
+func parseVatRate(taxRateField diDocumentField) *float64 {
 	// vatAsStringWithPercentSign example: "19%"
-	vatAsStringWithPercentSign := taxRateObject.Content
-	vatAsStringWithoutPercentSign := strings.TrimSuffix(vatAsStringWithPercentSign, "%")
+	vatAsStringWithPercentSign := taxRateField.Content
+	vatAsStringWithoutPercentSign := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(vatAsStringWithPercentSign), "%"))
 	vatAsStringWithoutPercentSignNormalized := strings.ReplaceAll(vatAsStringWithoutPercentSign, ",", ".")
 	vat, err := strconv.ParseFloat(vatAsStringWithoutPercentSignNormalized, 64)
 	if err != nil {
+		if taxRateField.ValueNumber != nil {
+			// Receipts may provide the rate as number only, e.g. 0.19
+			vat = *taxRateField.ValueNumber
+			if vat < 1 {
+				vat *= 100
+			}
+			return &vat
+		}
+
 		log.WithError(err).Debugf("%v", vatAsStringWithPercentSign)
 		return nil
 	}
@@ -189,3 +326,7 @@ func (d *diDocument) getVat() *float64 {
 func (d *diDocument) isTypeInvoice() bool {
 	return d.DocType == documentTypeInvoice
 }
+
+func (d *diDocument) isTypeReceipt() bool {
+	return d.DocType == documentTypeReceipt || strings.HasPrefix(d.DocType, documentTypeReceipt+".")
+}
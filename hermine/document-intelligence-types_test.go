@@ -409,3 +409,43 @@ func Test_diDocument_isNotTypeInvoice(t *testing.T) {
 	diDoc := diDocument{}
 	require.False(t, diDoc.isTypeInvoice())
 }
+
+func Test_diDocument_isTypeReceipt(t *testing.T) {
+	for _, docType := range []string{"receipt", "receipt.retailMeal", "receipt.hotel"} {
+		diDoc := diDocument{DocType: docType}
+		require.True(t, diDoc.isTypeReceipt(), docType)
+	}
+
+	for _, docType := range []string{"", documentTypeInvoice, "receipts"} {
+		diDoc := diDocument{DocType: docType}
+		require.False(t, diDoc.isTypeReceipt(), docType)
+	}
+}
+
+func Test_diDocument_receiptFields(t *testing.T) {
+	// given
+	transactionDate := "2024-10-05"
+	taxRate := 0.19
+	diDoc := diDocument{
+		DocType: "receipt.retailMeal",
+		Fields: map[string]diDocumentField{
+			"MerchantName":    {Content: "Bäckerei\nMüller"},
+			"TransactionDate": {ValueDate: &transactionDate},
+			"Total":           {Confidence: 0.9, ValueCurrency: &diCurrency{Amount: 7.5}},
+			"TaxDetails": {
+				ValueArray: &[]diDocumentFieldItem{
+					{ValueObject: map[string]diDocumentField{"Rate": {ValueNumber: &taxRate}}},
+				},
+			},
+		},
+	}
+
+	// when then
+	require.Equal(t, "Receipt from Bäckerei Müller", diDoc.createInvoiceName())
+	require.Equal(t, "\n\nTotal confidence: 0.90", diDoc.createComment())
+	require.Empty(t, diDoc.getNumber())
+	require.Equal(t, &transactionDate, diDoc.getBelegDate())
+	require.InDelta(t, 7.5, *diDoc.getGross(), 0.001)
+	require.InDelta(t, 0.9, *diDoc.getGrossConfidence(), 0.001)
+	require.InDelta(t, 19.0, *diDoc.getVat(), 0.001)
+}
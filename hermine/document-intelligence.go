@@ -1,6 +1,8 @@
 package hermine
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -9,48 +11,115 @@ import (
 	"net/http"
 	"net/url"
 	"os"
-	"strings"
 	"time"
 )
 
 const (
-	// diModelID See https://learn.microsoft.com/en-us/azure/ai-services/document-intelligence/model-overview for a list of all models.
-	diModelID = "prebuilt-invoice"
-
 	diPollingInterval = 1 * time.Second
 	diAPIVersion      = "2024-11-30"
 )
 
-func enqueueAnalysisAndWaitForCompletion(logger *log.Entry, diEndpoint, diKey, pathOfFileToImport string) (*diAnalyzeResult, error) {
+// AzureDocumentAnalyzerConfig configures an AzureDocumentAnalyzer.
+type AzureDocumentAnalyzerConfig struct {
+	Endpoint string
+	Key      string
+	// EntraID replaces authenticating by Key with Microsoft Entra ID access tokens, if set.
+	EntraID *EntraIDConfig
+	// Model is the Document Intelligence model ID or DIModelAuto, defaults to DIModelInvoice.
+	Model string
+	// ModelGlobs select the model per file, the first matching glob wins over Model.
+	ModelGlobs  []ModelGlob
+	RetryPolicy RetryPolicy
+}
+
+// AzureDocumentAnalyzer is a DocumentAnalyzer using Azure AI Document Intelligence.
+type AzureDocumentAnalyzer struct {
+	diEndpoint    string
+	authenticator diAuthenticator
+	model         string
+	modelGlobs    []ModelGlob
+	retryPolicy   RetryPolicy
+	hc            *http.Client
+}
+
+func NewAzureDocumentAnalyzer(config AzureDocumentAnalyzerConfig) *AzureDocumentAnalyzer {
 	hc := &http.Client{Timeout: 30 * time.Second}
 
-	runningAnalysisURL, enqueueErr := enqueueAnalysis(logger, hc, diEndpoint, diKey, pathOfFileToImport)
+	var authenticator diAuthenticator = &keyAuthenticator{diKey: config.Key}
+	if config.EntraID != nil {
+		authenticator = newEntraIDAuthenticator(*config.EntraID, hc)
+	}
+
+	return &AzureDocumentAnalyzer{
+		diEndpoint:    config.Endpoint,
+		authenticator: authenticator,
+		model:         config.Model,
+		modelGlobs:    config.ModelGlobs,
+		retryPolicy:   config.RetryPolicy,
+		hc:            hc,
+	}
+}
+
+func (a *AzureDocumentAnalyzer) Analyze(ctx context.Context, logger *log.Entry, pathOfFileToImport string) (*diAnalyzeResult, error) {
+	model := a.modelID(pathOfFileToImport)
+	if model != DIModelAuto {
+		return a.analyzeUsingModel(ctx, logger, pathOfFileToImport, model)
+	}
+
+	invoiceResult, invoiceErr := a.analyzeUsingModel(ctx, logger, pathOfFileToImport, DIModelInvoice)
+	if invoiceErr != nil || hasTotal(invoiceResult) {
+		return invoiceResult, invoiceErr
+	}
+
+	logger.Debugf("No invoice total found, analyzing using '%s'", DIModelReceipt)
+	receiptResult, receiptErr := a.analyzeUsingModel(ctx, logger, pathOfFileToImport, DIModelReceipt)
+	if receiptErr != nil {
+		return nil, receiptErr
+	}
+	if len(receiptResult.Documents) == 0 {
+		return invoiceResult, nil
+	}
+
+	return receiptResult, nil
+}
+
+func (a *AzureDocumentAnalyzer) analyzeUsingModel(ctx context.Context, logger *log.Entry, pathOfFileToImport string, model string) (*diAnalyzeResult, error) {
+	modelLogger := logger.WithField("di_model", model)
+
+	runningAnalysisURL, enqueueErr := a.enqueueAnalysis(ctx, modelLogger, pathOfFileToImport, model)
 	if enqueueErr != nil {
 		return nil, enqueueErr
 	}
 
-	pollResult, pollErr := pollUntilCompletion(logger, hc, runningAnalysisURL, diKey)
+	pollResult, pollErr := a.pollUntilCompletion(ctx, modelLogger, runningAnalysisURL)
 	if pollErr != nil {
 		return nil, pollErr
 	}
 	if pollResult.AnalyzeResult == nil {
 		noResultErr := errors.New("AnalyzeResult is missing")
-		logger.WithError(noResultErr).Warn()
+		modelLogger.WithError(noResultErr).Warn()
 		return nil, noResultErr
 	}
-	logger.Debugf("Analysis done, contains %d document(s)", len(pollResult.AnalyzeResult.Documents))
+	modelLogger.Debugf("Analysis done, contains %d document(s)", len(pollResult.AnalyzeResult.Documents))
 
 	return pollResult.AnalyzeResult, nil
 }
 
-func enqueueAnalysis(logger *log.Entry, hc *http.Client, diEndpoint, diKey, pathOfFileToImport string) (*url.URL, error) {
-	req, newReqErr := newAnalyzeHTTPRequest(logger, diEndpoint, diKey, pathOfFileToImport)
-	if newReqErr != nil {
-		return nil, newReqErr
+func (a *AzureDocumentAnalyzer) modelID(pathOfFileToImport string) string {
+	return selectModel(a.model, a.modelGlobs, pathOfFileToImport)
+}
+
+func (a *AzureDocumentAnalyzer) enqueueAnalysis(ctx context.Context, logger *log.Entry, pathOfFileToImport string, model string) (*url.URL, error) {
+	fileContent, readFileErr := os.ReadFile(pathOfFileToImport)
+	if readFileErr != nil {
+		logger.WithError(readFileErr).Warn("Failed to read file")
+		return nil, readFileErr
 	}
 
 	logger.Trace("Submitting file for DI analysis...")
-	resp, respErr := hc.Do(req)
+	resp, respErr := doWithRetries(ctx, logger, a.hc, a.retryPolicy, func() (*http.Request, error) {
+		return a.newAnalyzeHTTPRequest(ctx, logger, fileContent, model)
+	})
 	if respErr != nil {
 		logger.WithError(respErr).Debug("Failed to execute HTTP request")
 		return nil, respErr
@@ -73,86 +142,96 @@ func enqueueAnalysis(logger *log.Entry, hc *http.Client, diEndpoint, diKey, path
 	return url.Parse(operationLocation)
 }
 
-func newAnalyzeHTTPRequest(logger *log.Entry, diEndpoint, diKey, pathOfFileToImport string) (*http.Request, error) {
-	fileContent, readFileErr := os.ReadFile(pathOfFileToImport)
-	if readFileErr != nil {
-		logger.WithError(readFileErr).Warn("Failed to read file")
-		return nil, readFileErr
-	}
-
-	diURL := fmt.Sprintf("%s/documentintelligence/documentModels/%s:analyze?api-version=%s", diEndpoint, diModelID, diAPIVersion)
-	req, newRequestErr := http.NewRequest(http.MethodPost, diURL, strings.NewReader(string(fileContent)))
+func (a *AzureDocumentAnalyzer) newAnalyzeHTTPRequest(ctx context.Context, logger *log.Entry, fileContent []byte, model string) (*http.Request, error) {
+	diURL := fmt.Sprintf("%s/documentintelligence/documentModels/%s:analyze?api-version=%s", a.diEndpoint, model, diAPIVersion)
+	req, newRequestErr := http.NewRequestWithContext(ctx, http.MethodPost, diURL, bytes.NewReader(fileContent))
 	if newRequestErr != nil {
 		logger.WithError(newRequestErr).Warn("Failed to create a new HTTP request")
 		return nil, newRequestErr
 	}
 
 	req.Header.Set("Content-Type", "application/octet-stream")
-	addDiAuthenticationHeader(req, diKey)
+	if authErr := a.authenticator.authenticate(ctx, req); authErr != nil {
+		logger.WithError(authErr).Warn("Failed to authenticate HTTP request")
+		return nil, authErr
+	}
 
 	return req, nil
 }
 
-func pollUntilCompletion(logger *log.Entry, hc *http.Client, pollingURL *url.URL, diKey string) (*diAnalysisStatus, error) {
-	req, newRequestErr := http.NewRequest(http.MethodGet, pollingURL.String(), nil)
-	if newRequestErr != nil {
-		createErr := fmt.Errorf("failed to create a new HTTP GET request: %w", newRequestErr)
-		logger.WithError(newRequestErr).Warn(createErr)
-		return nil, createErr
+func (a *AzureDocumentAnalyzer) pollUntilCompletion(ctx context.Context, logger *log.Entry, pollingURL *url.URL) (*diAnalysisStatus, error) {
+	newPollingRequest := func() (*http.Request, error) {
+		req, newRequestErr := http.NewRequestWithContext(ctx, http.MethodGet, pollingURL.String(), nil)
+		if newRequestErr != nil {
+			createErr := fmt.Errorf("failed to create a new HTTP GET request: %w", newRequestErr)
+			logger.WithError(newRequestErr).Warn(createErr)
+			return nil, createErr
+		}
+		if authErr := a.authenticator.authenticate(ctx, req); authErr != nil {
+			logger.WithError(authErr).Warn("Failed to authenticate HTTP GET request")
+			return nil, authErr
+		}
+
+		return req, nil
 	}
-	addDiAuthenticationHeader(req, diKey)
 
 	for {
-		if diStatus, err := pollAnalysisStatus(logger, hc, req); diStatus != nil && err == nil {
-			return diStatus, nil
+		resp, respErr := doWithRetries(ctx, logger, a.hc, a.retryPolicy, newPollingRequest)
+		if respErr != nil {
+			logger.WithError(respErr).Warn("Failed to perform HTTP GET during polling")
+			return nil, respErr
+		}
+
+		diStatus, pollErr := pollAnalysisStatus(logger, resp)
+		if diStatus != nil || pollErr != nil {
+			return diStatus, pollErr
 		}
 
-		time.Sleep(diPollingInterval)
+		pollingInterval := max(parseRetryAfter(resp.Header), diPollingInterval)
+		if sleepErr := sleepContext(ctx, pollingInterval); sleepErr != nil {
+			logger.WithError(sleepErr).Warn("Stopped waiting for analysis")
+			return nil, sleepErr
+		}
 	}
 }
 
-func pollAnalysisStatus(logger *log.Entry, hc *http.Client, req *http.Request) (*diAnalysisStatus, error) {
-	resp, respErr := hc.Do(req)
-	if respErr != nil {
-		logger.WithError(respErr).Warn("Failed to perform HTTP GET during polling, trying again...")
-		return nil, respErr
-	}
+// pollAnalysisStatus returns the analysis status once succeeded, nil while still running, or an error if the
+// analysis failed.
+func pollAnalysisStatus(logger *log.Entry, resp *http.Response) (*diAnalysisStatus, error) {
 	defer closeBody(logger, resp)
 
-	switch resp.StatusCode {
-	case http.StatusOK:
-		body, readErr := io.ReadAll(resp.Body)
-		if readErr != nil {
-			logger.WithError(readErr).Warn("Failed to read response body")
-			return nil, readErr
-		}
+	body, readErr := io.ReadAll(resp.Body)
+	if readErr != nil {
+		logger.WithError(readErr).Warn("Failed to read response body")
+		return nil, readErr
+	}
+	if resp.StatusCode != http.StatusOK {
+		pollFailedErr := fmt.Errorf("polling failed, status: %d, error message: %s", resp.StatusCode, body)
+		logger.WithError(pollFailedErr).Warn()
+		return nil, pollFailedErr
+	}
 
-		var analysisStatus diAnalysisStatus
-		if unmarshalErr := json.Unmarshal(body, &analysisStatus); unmarshalErr != nil {
-			logger.WithError(unmarshalErr).Warn("Failed to parse response body into structured JSON")
-			return nil, unmarshalErr
-		}
+	var analysisStatus diAnalysisStatus
+	if unmarshalErr := json.Unmarshal(body, &analysisStatus); unmarshalErr != nil {
+		logger.WithError(unmarshalErr).Warn("Failed to parse response body into structured JSON")
+		return nil, unmarshalErr
+	}
 
-		switch {
-		case analysisStatus.isStatusSucceeded():
-			logger.Debug("Analysis succeeded")
-			return &analysisStatus, nil
-		case analysisStatus.isStatusRunning():
-			logger.Trace("Analysis running...")
-		default:
-			logger.Warnf("Unexpected response body: %s, trying again...", body)
+	switch {
+	case analysisStatus.isStatusSucceeded():
+		logger.Debug("Analysis succeeded")
+		if analysisStatus.AnalyzeResult != nil {
+			analysisStatus.AnalyzeResult.rawAnalysisStatus = body
 		}
-	case http.StatusTooManyRequests:
-		logger.Debugf("Too many requests, trying again...")
+		return &analysisStatus, nil
+	case analysisStatus.isStatusRunning():
+		logger.Trace("Analysis running...")
+		return nil, nil
 	default:
-		logger.Debugf("Unexpected status code received: %d, trying again...", resp.StatusCode)
+		analysisFailedErr := fmt.Errorf("analysis has status '%s': %s", analysisStatus.Status, body)
+		logger.WithError(analysisFailedErr).Warn()
+		return nil, analysisFailedErr
 	}
-
-	return nil, nil
-}
-
-func addDiAuthenticationHeader(req *http.Request, diKey string) {
-	req.Header.Set("Ocp-Apim-Subscription-Key", diKey)
 }
 
 func closeBody(logger *log.Entry, r *http.Response) {
@@ -1,11 +1,21 @@
 package hermine
 
 import (
+	"context"
+	"encoding/json"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func Test_enqueueAnalysisAndWaitForCompletion(t *testing.T) {
@@ -19,10 +29,163 @@ func Test_enqueueAnalysisAndWaitForCompletion(t *testing.T) {
 	testLoggerEntry := testLogger.WithField("test", t.Name())
 
 	invoiceFilePath := filepath.Join("testdata", invoiceExampleFileName)
-	diAr, analysisErr := enqueueAnalysisAndWaitForCompletion(testLoggerEntry, diEndpoint, diKey, invoiceFilePath)
+	diAr, analysisErr := NewAzureDocumentAnalyzer(AzureDocumentAnalyzerConfig{Endpoint: diEndpoint, Key: diKey}).Analyze(context.Background(), testLoggerEntry, invoiceFilePath)
 
 	require.NoError(t, analysisErr)
 	require.NotNil(t, diAr)
 	assert.Len(t, diAr.Documents, 1)
 	assert.NotEmpty(t, diAr.Content)
 }
+
+func Test_pollUntilCompletion_canceledWhileRunning(t *testing.T) {
+	testLogger, _ := newDebuggingNullLogger(t)
+	testLoggerEntry := testLogger.WithField("test", t.Name())
+
+	diServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"status": "running"}`))
+	}))
+	t.Cleanup(diServer.Close)
+	pollingURL, parseErr := url.Parse(diServer.URL)
+	require.NoError(t, parseErr)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	t.Cleanup(cancel)
+	analyzer := NewAzureDocumentAnalyzer(AzureDocumentAnalyzerConfig{Endpoint: diServer.URL, Key: "key"})
+	diStatus, pollErr := analyzer.pollUntilCompletion(ctx, testLoggerEntry, pollingURL)
+
+	require.ErrorIs(t, pollErr, context.DeadlineExceeded)
+	assert.Nil(t, diStatus)
+}
+
+func Test_AzureDocumentAnalyzer_Analyze_withRetries(t *testing.T) {
+	testLogger, _ := newDebuggingNullLogger(t)
+	testLoggerEntry := testLogger.WithField("test", t.Name())
+
+	_, diAr := getDiResultFixture(t)
+	diResult, marshalErr := json.Marshal(diAr)
+	require.NoError(t, marshalErr)
+
+	var analyzeRequests, pollRequests atomic.Int32
+	var diServerURL string
+	diServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "key", r.Header.Get("Ocp-Apim-Subscription-Key"))
+		if r.Method == http.MethodPost {
+			if analyzeRequests.Add(1) == 1 {
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			body, _ := io.ReadAll(r.Body)
+			assert.Equal(t, "document", string(body))
+			w.Header().Set("Operation-Location", diServerURL+"/result")
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+
+		if pollRequests.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write(diResult)
+	}))
+	t.Cleanup(diServer.Close)
+	diServerURL = diServer.URL
+
+	documentPath := filepath.Join(t.TempDir(), "document.pdf")
+	require.NoError(t, os.WriteFile(documentPath, []byte("document"), 0o600))
+
+	retryPolicy := RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+	analyzer := NewAzureDocumentAnalyzer(AzureDocumentAnalyzerConfig{Endpoint: diServer.URL, Key: "key", RetryPolicy: retryPolicy})
+	ctx, stats := withAnalysisStats(context.Background())
+	result, analysisErr := analyzer.Analyze(ctx, testLoggerEntry, documentPath)
+
+	require.NoError(t, analysisErr)
+	require.Len(t, result.Documents, 1)
+	assert.EqualValues(t, 2, analyzeRequests.Load())
+	assert.EqualValues(t, 2, pollRequests.Load())
+	assert.EqualValues(t, 2, stats.retries.Load())
+	assert.Equal(t, diResult, result.rawAnalysisStatus)
+}
+
+func Test_AzureDocumentAnalyzer_Analyze_failures(t *testing.T) {
+	tests := []struct {
+		name             string
+		analyzeStatus    int
+		pollBody         string
+		expectedRequests int32
+	}{
+		{name: "Unauthorized is not retried", analyzeStatus: http.StatusUnauthorized, expectedRequests: 1},
+		{name: "Not found is not retried", analyzeStatus: http.StatusNotFound, expectedRequests: 1},
+		{name: "Server error is retried until max attempts", analyzeStatus: http.StatusInternalServerError, expectedRequests: 3},
+		{name: "Failed analysis", analyzeStatus: http.StatusAccepted, pollBody: `{"status": "failed"}`, expectedRequests: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testLogger, _ := newDebuggingNullLogger(t)
+			testLoggerEntry := testLogger.WithField("test", t.Name())
+
+			var requests atomic.Int32
+			var diServerURL string
+			diServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests.Add(1)
+				if r.Method == http.MethodPost {
+					w.Header().Set("Operation-Location", diServerURL+"/result")
+					w.WriteHeader(tt.analyzeStatus)
+					return
+				}
+				_, _ = w.Write([]byte(tt.pollBody))
+			}))
+			t.Cleanup(diServer.Close)
+			diServerURL = diServer.URL
+
+			documentPath := filepath.Join(t.TempDir(), "document.pdf")
+			require.NoError(t, os.WriteFile(documentPath, []byte("document"), 0o600))
+
+			retryPolicy := RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+			analyzer := NewAzureDocumentAnalyzer(AzureDocumentAnalyzerConfig{Endpoint: diServer.URL, Key: "key", RetryPolicy: retryPolicy})
+			result, analysisErr := analyzer.Analyze(context.Background(), testLoggerEntry, documentPath)
+
+			require.Error(t, analysisErr)
+			assert.Nil(t, result)
+			assert.Equal(t, tt.expectedRequests, requests.Load())
+		})
+	}
+}
+
+func Test_AzureDocumentAnalyzer_Analyze_autoModelFallsBackToReceipt(t *testing.T) {
+	testLogger, _ := newDebuggingNullLogger(t)
+	testLoggerEntry := testLogger.WithField("test", t.Name())
+
+	var analyzedModels []string
+	var diServerURL string
+	diServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			model := strings.TrimSuffix(path.Base(r.URL.Path), ":analyze")
+			analyzedModels = append(analyzedModels, model)
+			w.Header().Set("Operation-Location", diServerURL+"/result/"+model)
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+
+		if strings.HasSuffix(r.URL.Path, DIModelReceipt) {
+			_, _ = w.Write([]byte(`{"status": "succeeded", "analyzeResult": {"documents": [` +
+				`{"docType": "receipt.retailMeal", "fields": {"Total": {"valueCurrency": {"amount": 7.5}}}}]}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"status": "succeeded", "analyzeResult": {"documents": [{"docType": "invoice"}]}}`))
+	}))
+	t.Cleanup(diServer.Close)
+	diServerURL = diServer.URL
+
+	documentPath := filepath.Join(t.TempDir(), "kassenbon.jpg")
+	require.NoError(t, os.WriteFile(documentPath, []byte("document"), 0o600))
+
+	analyzer := NewAzureDocumentAnalyzer(AzureDocumentAnalyzerConfig{Endpoint: diServer.URL, Key: "key", Model: DIModelAuto})
+	result, analysisErr := analyzer.Analyze(context.Background(), testLoggerEntry, documentPath)
+
+	require.NoError(t, analysisErr)
+	require.Len(t, result.Documents, 1)
+	assert.Equal(t, "receipt.retailMeal", result.Documents[0].DocType)
+	assert.Equal(t, []string{DIModelInvoice, DIModelReceipt}, analyzedModels)
+}
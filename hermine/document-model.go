@@ -0,0 +1,62 @@
+package hermine
+
+import (
+	"fmt"
+	"github.com/bmatcuk/doublestar/v4"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// DIModelInvoice See https://learn.microsoft.com/en-us/azure/ai-services/document-intelligence/model-overview for a list of all models.
+	DIModelInvoice = "prebuilt-invoice"
+	DIModelReceipt = "prebuilt-receipt"
+	// DIModelAuto analyzes using DIModelInvoice first, and DIModelReceipt if no invoice total was found.
+	DIModelAuto = "auto"
+)
+
+// ModelGlob selects the Document Intelligence model for all files matching the glob pattern.
+type ModelGlob struct {
+	Model string
+	Glob  string
+}
+
+// ParseModelGlob parses a model glob given as "<model>=<glob>", e.g. "prebuilt-receipt=**/Kassenbons/**".
+func ParseModelGlob(modelGlob string) (ModelGlob, error) {
+	model, glob, found := strings.Cut(modelGlob, "=")
+	model, glob = strings.TrimSpace(model), strings.TrimSpace(glob)
+	if !found || model == "" || glob == "" {
+		return ModelGlob{}, fmt.Errorf("model glob '%s' is not in the format '<model>=<glob>'", modelGlob)
+	}
+	if !doublestar.ValidatePattern(filepath.ToSlash(glob)) {
+		return ModelGlob{}, fmt.Errorf("model glob '%s' contains an invalid glob pattern", modelGlob)
+	}
+
+	return ModelGlob{Model: model, Glob: glob}, nil
+}
+
+// selectModel returns the model of the first model glob matching the path, or the default model.
+func selectModel(defaultModel string, modelGlobs []ModelGlob, pathOfFileToImport string) string {
+	slashedPath := filepath.ToSlash(pathOfFileToImport)
+	for _, mg := range modelGlobs {
+		if matched, _ := doublestar.Match(filepath.ToSlash(mg.Glob), slashedPath); matched {
+			return mg.Model
+		}
+	}
+
+	if defaultModel == "" {
+		return DIModelInvoice
+	}
+	return defaultModel
+}
+
+// hasTotal reports whether any analyzed document contains a total amount.
+func hasTotal(diAr *diAnalyzeResult) bool {
+	for _, document := range diAr.Documents {
+		if document.getGross() != nil {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,69 @@
+package hermine
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func Test_ParseModelGlob(t *testing.T) {
+	tests := []struct {
+		name          string
+		modelGlob     string
+		expected      ModelGlob
+		expectedError bool
+	}{
+		{
+			name:      "Model and glob",
+			modelGlob: "prebuilt-receipt=**/Kassenbons/**",
+			expected:  ModelGlob{Model: DIModelReceipt, Glob: "**/Kassenbons/**"},
+		},
+		{
+			name:      "Surrounding spaces are trimmed",
+			modelGlob: " prebuilt-invoice = *.pdf ",
+			expected:  ModelGlob{Model: DIModelInvoice, Glob: "*.pdf"},
+		},
+		{name: "Missing separator", modelGlob: "prebuilt-receipt", expectedError: true},
+		{name: "Missing model", modelGlob: "=*.pdf", expectedError: true},
+		{name: "Missing glob", modelGlob: "prebuilt-receipt=", expectedError: true},
+		{name: "Invalid glob", modelGlob: "prebuilt-receipt=[", expectedError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			modelGlob, err := ParseModelGlob(tt.modelGlob)
+
+			if tt.expectedError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, modelGlob)
+		})
+	}
+}
+
+func Test_selectModel(t *testing.T) {
+	modelGlobs := []ModelGlob{
+		{Model: DIModelReceipt, Glob: "**/Kassenbons/**"},
+		{Model: DIModelAuto, Glob: "**/*.jpg"},
+	}
+
+	tests := []struct {
+		name          string
+		defaultModel  string
+		path          string
+		expectedModel string
+	}{
+		{name: "First matching glob wins", path: "/import/Kassenbons/2024/scan.jpg", expectedModel: DIModelReceipt},
+		{name: "Second glob matches", path: "/import/photo.jpg", expectedModel: DIModelAuto},
+		{name: "Default model", defaultModel: DIModelReceipt, path: "/import/invoice.pdf", expectedModel: DIModelReceipt},
+		{name: "Invoice model without default", path: "/import/invoice.pdf", expectedModel: DIModelInvoice},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expectedModel, selectModel(tt.defaultModel, modelGlobs, tt.path))
+		})
+	}
+}
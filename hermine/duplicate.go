@@ -0,0 +1,107 @@
+package hermine
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"slices"
+)
+
+// Duplicate policies decide about a document, whose file is new, but which matches an existing Beleg by number,
+// amount, Beleg date and vendor category, e.g. an invoice received as email PDF and as phone photo.
+const (
+	// DuplicatePolicyCreate creates a new Beleg anyway.
+	DuplicatePolicyCreate = "create"
+	// DuplicatePolicyFlag creates a new Beleg, noting the possible duplicate in its comment for review.
+	DuplicatePolicyFlag = "flag"
+	// DuplicatePolicyLink adds the file as additional asset to the existing Beleg.
+	DuplicatePolicyLink = "link"
+	// DuplicatePolicySkip imports nothing.
+	DuplicatePolicySkip = "skip"
+)
+
+// selectDuplicateBmDocBelegQuery finds active Belege with equal amount and Beleg date. Number and vendor category are
+// compared only if given, i.e. not empty.
+const selectDuplicateBmDocBelegQuery = `SELECT ` + bmDocBelegColumns + ` FROM BmDoc_Beleg
+WHERE (deleteState IS NULL OR deleteState = 0)
+  AND amount BETWEEN ? AND ?
+  AND belegDate = ?
+  AND (? = '' OR number = ?)
+  AND (? = '' OR uuid IN (
+    SELECT l.targetUuid FROM BmDoc_LinkTable l JOIN BmDoc_Kategorie k ON k.uuid = l.sourceUuid WHERE k.name = ?
+  ))
+ORDER BY id
+LIMIT 1`
+
+// amountTolerance compares amounts up to rounding to cents.
+const amountTolerance = 0.005
+
+func ValidDuplicatePolicies() []string {
+	return []string{DuplicatePolicyCreate, DuplicatePolicyFlag, DuplicatePolicyLink, DuplicatePolicySkip}
+}
+
+func IsValidDuplicatePolicy(policy string) bool {
+	return slices.Contains(ValidDuplicatePolicies(), policy)
+}
+
+// duplicateDecision records how a document matching an existing Beleg was handled.
+type duplicateDecision struct {
+	policy    string
+	duplicate *bmDocBeleg
+}
+
+func (d *duplicateDecision) String() string {
+	if d == nil {
+		return ""
+	}
+
+	return fmt.Sprintf("%s: Beleg %d", d.policy, d.duplicate.ID)
+}
+
+// createsBeleg reports whether a new Beleg is created despite the duplicate.
+func (d *duplicateDecision) createsBeleg() bool {
+	return d == nil || d.policy == DuplicatePolicyCreate || d.policy == DuplicatePolicyFlag
+}
+
+// skips reports whether the document is not imported due to the duplicate.
+func (d *duplicateDecision) skips() bool {
+	return d != nil && d.policy == DuplicatePolicySkip
+}
+
+func (d *duplicateDecision) reviewNote() string {
+	if d == nil || d.policy != DuplicatePolicyFlag {
+		return ""
+	}
+
+	return fmt.Sprintf("Possible duplicate of Beleg %d '%s', please review", d.duplicate.ID, d.duplicate.Name)
+}
+
+// findDuplicateBmDocBeleg returns an existing Beleg matching the document, or nil. The vendor is compared by the name
+// of its category, see resolveVendorCategory, "" for documents without vendor. Documents without amount or Beleg date
+// are never considered duplicates.
+func findDuplicateBmDocBeleg(logger *log.Entry, q sqlxSelecter, documentFromAnalysis diDocument, vendor string) (*bmDocBeleg, error) {
+	amount := documentFromAnalysis.getGross()
+	belegDate := documentFromAnalysis.getBelegDate()
+	if amount == nil || belegDate == nil {
+		return nil, nil
+	}
+
+	number := documentFromAnalysis.getNumber()
+
+	duplicates := make([]*bmDocBeleg, 0, 1)
+	if selectErr := q.Select(
+		&duplicates,
+		selectDuplicateBmDocBelegQuery,
+		*amount-amountTolerance, *amount+amountTolerance,
+		*belegDate,
+		number, number,
+		vendor, vendor,
+	); selectErr != nil {
+		logger.WithError(selectErr).Warn("Error when searching duplicate BmDoc_Beleg")
+		return nil, selectErr
+	}
+	if len(duplicates) == 0 {
+		return nil, nil
+	}
+
+	return duplicates[0], nil
+}
@@ -0,0 +1,151 @@
+package hermine
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_importIntoBelegManager_duplicatePolicies(t *testing.T) {
+	tests := []struct {
+		policy            string
+		expectNewBeleg    bool
+		expectedAssets    int
+		expectedNote      bool
+		expectedDecision  string
+		expectedChangeLen int
+	}{
+		{policy: "", expectNewBeleg: true, expectedAssets: 2, expectedDecision: "create: Beleg 1", expectedChangeLen: 6},
+		{policy: DuplicatePolicyFlag, expectNewBeleg: true, expectedAssets: 2, expectedNote: true, expectedDecision: "flag: Beleg 1", expectedChangeLen: 6},
+		{policy: DuplicatePolicyLink, expectedAssets: 2, expectedDecision: "link: Beleg 1", expectedChangeLen: 3},
+		{policy: DuplicatePolicySkip, expectedAssets: 1, expectedDecision: "skip: Beleg 1", expectedChangeLen: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			t.Parallel()
+
+			// given
+			testLogger, _ := newDebuggingNullLogger(t)
+			testLoggerEntry := testLogger.WithField("test", t.Name())
+
+			belegManagerDirectory, openDirErr := os.Open(t.TempDir())
+			require.NoError(t, openDirErr)
+			t.Cleanup(func() {
+				require.NoError(t, belegManagerDirectory.Close())
+			})
+
+			database := openDatabaseFixture(t, testLoggerEntry)
+			invoiceFilePath, diAr := getDiResultFixture(t)
+			document := diAr.AnalyzeResult.Documents[0]
+			photoFilePath := filepath.Join(t.TempDir(), "photo.jpg")
+			require.NoError(t, os.WriteFile(photoFilePath, []byte("photo of the invoice"), 0o600))
+
+			r := newImportRun(database, nil, belegManagerDirectory, ImportOptions{DuplicatePolicy: tt.policy})
+			imported, importErr := r.importIntoBelegManager(testLoggerEntry, invoiceFilePath, document)
+			require.NoError(t, importErr)
+			require.Nil(t, imported.duplicate)
+
+			// when
+			outcome, duplicateImportErr := r.importIntoBelegManager(testLoggerEntry, photoFilePath, document)
+
+			// then
+			require.NoError(t, duplicateImportErr)
+			assert.Equal(t, tt.expectedDecision, outcome.duplicate.String())
+			assert.Len(t, outcome.changes, tt.expectedChangeLen)
+			switch {
+			case tt.policy == DuplicatePolicySkip:
+				assert.Nil(t, outcome.beleg, "nothing imported")
+			case tt.expectNewBeleg:
+				assert.NotEqual(t, imported.beleg.ID, outcome.beleg.ID)
+			default:
+				assert.Equal(t, imported.beleg.ID, outcome.beleg.ID)
+			}
+			if tt.expectedNote {
+				assert.Contains(t, *outcome.beleg.Comment, "Possible duplicate of Beleg 1")
+			} else if outcome.beleg != nil {
+				assert.NotContains(t, *outcome.beleg.Comment, "Possible duplicate")
+			}
+
+			var assetCount int
+			require.NoError(t, database.Get(&assetCount, "SELECT COUNT(*) FROM BmDoc_Asset"))
+			assert.Equal(t, tt.expectedAssets, assetCount)
+		})
+	}
+}
+
+func Test_processFile_skippedDuplicateIsKept(t *testing.T) {
+	t.Parallel()
+
+	// given
+	belegManagerDirectory, openDirErr := os.Open(t.TempDir())
+	require.NoError(t, openDirErr)
+	t.Cleanup(func() {
+		require.NoError(t, belegManagerDirectory.Close())
+	})
+
+	sourceDirectory := t.TempDir()
+	invoiceFixturePath, diAr := getDiResultFixture(t)
+	invoiceFilePath := filepath.Join(sourceDirectory, invoiceExampleFileName)
+	require.NoError(t, copyFileToTargetIfTargetDoesNotExist(newDummyLogEntry(t), invoiceFixturePath, invoiceFilePath))
+	photoFilePath := filepath.Join(sourceDirectory, "photo.jpg")
+	require.NoError(t, os.WriteFile(photoFilePath, []byte("photo of another invoice with equal amount and date"), 0o600))
+
+	afterImportOptions, optionsErr := NewAfterImportOptions([]string{AfterImportDelete}, filepath.Join(sourceDirectory, "*"), "", "")
+	require.NoError(t, optionsErr)
+	database := openPlainDatabase(t, copyDatabaseFixtureIntoDirectory(t, t.TempDir()))
+	analyzer := fixtureDocumentAnalyzer{result: diAr.AnalyzeResult}
+	r := newImportRun(database, analyzer, belegManagerDirectory,
+		ImportOptions{DuplicatePolicy: DuplicatePolicySkip, AfterImport: afterImportOptions})
+	imported := r.processFile(context.Background(), invoiceFilePath)
+	require.Len(t, imported, 1)
+	require.NotNil(t, imported[0].beleg)
+	require.NoFileExists(t, invoiceFilePath, "imported files are deleted")
+
+	// when
+	skipped := r.processFile(context.Background(), photoFilePath)
+
+	// then
+	require.Len(t, skipped, 1)
+	assert.Equal(t, "skip: Beleg 1", skipped[0].duplicate.String())
+	assert.Nil(t, skipped[0].beleg, "not reported as imported")
+	assert.Empty(t, skipped[0].afterImport)
+	assert.FileExists(t, photoFilePath, "suspected duplicates are kept for review")
+}
+
+func Test_findDuplicateBmDocBeleg_differentAmount(t *testing.T) {
+	// given
+	testLogger, _ := newDebuggingNullLogger(t)
+	testLoggerEntry := testLogger.WithField("test", t.Name())
+
+	belegManagerDirectory, openDirErr := os.Open(t.TempDir())
+	require.NoError(t, openDirErr)
+	t.Cleanup(func() {
+		require.NoError(t, belegManagerDirectory.Close())
+	})
+
+	database := openDatabaseFixture(t, testLoggerEntry)
+	invoiceFilePath, diAr := getDiResultFixture(t)
+	document := diAr.AnalyzeResult.Documents[0]
+	r := newImportRun(database, nil, belegManagerDirectory, ImportOptions{})
+	_, importErr := r.importIntoBelegManager(testLoggerEntry, invoiceFilePath, document)
+	require.NoError(t, importErr)
+
+	// when
+	otherTotal := document.Fields["InvoiceTotal"]
+	otherTotal.ValueCurrency = &diCurrency{Amount: otherTotal.ValueCurrency.Amount + 0.01}
+	document.Fields = map[string]diDocumentField{
+		"InvoiceId":    document.Fields["InvoiceId"],
+		"InvoiceDate":  document.Fields["InvoiceDate"],
+		"VendorName":   document.Fields["VendorName"],
+		"InvoiceTotal": otherTotal,
+	}
+	duplicate, findErr := findDuplicateBmDocBeleg(testLoggerEntry, database, document, "CONTOSO")
+
+	// then
+	require.NoError(t, findErr)
+	assert.Nil(t, duplicate)
+}
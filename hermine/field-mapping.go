@@ -0,0 +1,69 @@
+package hermine
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// fieldTemplatePlaceholder matches placeholders like "{ProviderName}" in name and comment templates.
+var fieldTemplatePlaceholder = regexp.MustCompile(`\{([^{}]+)}`)
+
+// FieldMapping declares how the fields of documents analyzed by a custom trained Document Intelligence model are
+// mapped onto a Beleg. Name and Comment are templates, in which "{<field name>}" is replaced by the field's content.
+// All other attributes name a field of the model.
+type FieldMapping struct {
+	// Model is the ID of the custom model, matching the docType "<model>" or "<model>:<document type>".
+	Model      string   `mapstructure:"model"`
+	Name       string   `mapstructure:"name"`
+	Number     string   `mapstructure:"number"`
+	Amount     string   `mapstructure:"amount"`
+	Vat        string   `mapstructure:"vat"`
+	BelegDate  string   `mapstructure:"beleg-date"`
+	Comment    string   `mapstructure:"comment"`
+	Categories []string `mapstructure:"categories"`
+}
+
+func (m FieldMapping) Validate() error {
+	if strings.TrimSpace(m.Model) == "" {
+		return errors.New("field mapping without model")
+	}
+
+	return nil
+}
+
+func (m FieldMapping) matchesDocType(docType string) bool {
+	return docType == m.Model || strings.HasPrefix(docType, m.Model+":")
+}
+
+func (m FieldMapping) belegFieldMapping() belegFieldMapping {
+	return belegFieldMapping{
+		documentKind:    m.Model,
+		number:          m.Number,
+		belegDate:       m.BelegDate,
+		total:           m.Amount,
+		vat:             m.Vat,
+		nameTemplate:    m.Name,
+		commentTemplate: m.Comment,
+		categories:      m.Categories,
+	}
+}
+
+// findFieldMapping returns the first field mapping for the docType, or nil.
+func findFieldMapping(fieldMappings []FieldMapping, docType string) *FieldMapping {
+	for i := range fieldMappings {
+		if fieldMappings[i].matchesDocType(docType) {
+			return &fieldMappings[i]
+		}
+	}
+
+	return nil
+}
+
+// expandFieldTemplate replaces the placeholders of the template by the content of the document's fields.
+func (d *diDocument) expandFieldTemplate(template string) string {
+	return fieldTemplatePlaceholder.ReplaceAllStringFunc(template, func(placeholder string) string {
+		fieldName := strings.Trim(placeholder, "{}")
+		return strings.ReplaceAll(d.Fields[fieldName].Content, "\n", " ")
+	})
+}
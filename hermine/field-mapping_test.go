@@ -0,0 +1,87 @@
+package hermine
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func Test_findFieldMapping(t *testing.T) {
+	fieldMappings := []FieldMapping{{Model: "utility-bill"}, {Model: "insurance"}}
+
+	tests := []struct {
+		name          string
+		docType       string
+		expectedModel string
+	}{
+		{name: "Model as docType", docType: "utility-bill", expectedModel: "utility-bill"},
+		{name: "Model with document type", docType: "insurance:car", expectedModel: "insurance"},
+		{name: "Model as prefix only", docType: "utility-bills"},
+		{name: "Prebuilt model", docType: documentTypeInvoice},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fieldMapping := findFieldMapping(fieldMappings, tt.docType)
+
+			if tt.expectedModel == "" {
+				assert.Nil(t, fieldMapping)
+				return
+			}
+			require.NotNil(t, fieldMapping)
+			assert.Equal(t, tt.expectedModel, fieldMapping.Model)
+		})
+	}
+}
+
+func Test_FieldMapping_Validate(t *testing.T) {
+	require.NoError(t, FieldMapping{Model: "utility-bill"}.Validate())
+	require.Error(t, FieldMapping{Model: " "}.Validate())
+}
+
+func Test_diDocument_customMapping(t *testing.T) {
+	// given
+	billDate := "2024-03-31"
+	amount := 89.9
+	diDoc := diDocument{
+		DocType: "utility-bill",
+		Fields: map[string]diDocumentField{
+			"Provider":       {Content: "Stadtwerke\nMusterstadt"},
+			"Period":         {Content: "Q1 2024"},
+			"CustomerNumber": {Content: "4711"},
+			"TotalAmount":    {Confidence: 0.8, ValueNumber: &amount},
+			"VatRate":        {Content: "19 %"},
+			"BillDate":       {ValueDate: &billDate},
+		},
+		customMapping: &FieldMapping{
+			Model:      "utility-bill",
+			Name:       "Strom {Provider} {Period}",
+			Number:     "CustomerNumber",
+			Amount:     "TotalAmount",
+			Vat:        "VatRate",
+			BelegDate:  "BillDate",
+			Comment:    "Zeitraum: {Period}, {Unknown}",
+			Categories: []string{"Provider"},
+		},
+	}
+
+	// when then
+	assert.Equal(t, "Strom Stadtwerke Musterstadt Q1 2024", diDoc.createInvoiceName())
+	assert.Equal(t, "Zeitraum: Q1 2024, ", diDoc.createComment())
+	assert.Equal(t, "4711", diDoc.getNumber())
+	assert.Equal(t, &billDate, diDoc.getBelegDate())
+	assert.Equal(t, &amount, diDoc.getGross())
+	assert.InDelta(t, 0.8, *diDoc.getGrossConfidence(), 0.001)
+	assert.InDelta(t, 19.0, *diDoc.getVat(), 0.001)
+	assert.Equal(t, []string{"Provider"}, diDoc.fieldMapping().categories)
+}
+
+func Test_diDocument_customMappingWithoutName(t *testing.T) {
+	diDoc := diDocument{DocType: "utility-bill", customMapping: &FieldMapping{Model: "utility-bill"}}
+
+	assert.Equal(t, "utility-bill", diDoc.createInvoiceName())
+	assert.Empty(t, diDoc.getNumber())
+	assert.Nil(t, diDoc.getBelegDate())
+	assert.Nil(t, diDoc.getGross())
+	assert.Nil(t, diDoc.getVat())
+}
@@ -1,6 +1,8 @@
 package hermine
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"io"
@@ -9,21 +11,38 @@ import (
 	"time"
 )
 
+// targetFileNameInDirectory returns the file name copyFileIntoDirectoryIfTargetDoesNotExist would currently use.
+func targetFileNameInDirectory(filePath, directoryPath string) (string, error) {
+	fileName := filepath.Base(filePath)
+	_, statErr := os.Stat(filepath.Join(directoryPath, fileName))
+	if os.IsNotExist(statErr) {
+		return fileName, nil
+	} else if statErr != nil {
+		return "", statErr
+	}
+
+	return fileNameWithTimeSuffix(fileName), nil
+}
+
+func fileNameWithTimeSuffix(fileName string) string {
+	fileExt := filepath.Ext(fileName)
+	fileBaseName := fileName[:len(fileName)-len(fileExt)]
+	nowAsFlatDateTime := time.Now().Format(flatDateTime)
+	return fmt.Sprintf("%s_%s%s", fileBaseName, nowAsFlatDateTime, fileExt)
+}
+
 func copyFileIntoDirectoryIfTargetDoesNotExist(logger *log.Entry, filePath, directoryPath string) (string, error) {
 	originalFileBaseName := filepath.Base(filePath)
 	fileName := originalFileBaseName
 	targetFilePath := filepath.Join(directoryPath, fileName)
 	copyErr := copyFileToTargetIfTargetDoesNotExist(logger, filePath, targetFilePath)
 	if copyErr != nil && os.IsExist(copyErr) {
-		fileExt := filepath.Ext(originalFileBaseName)
-		fileBaseName := originalFileBaseName[:len(originalFileBaseName)-len(fileExt)]
-		nowAsFlatDateTime := time.Now().Format(flatDateTime)
-		fileNameWithTimeSuffix := fmt.Sprintf("%s_%s%s", fileBaseName, nowAsFlatDateTime, fileExt)
-		targetFilePathWithTimeSuffix := filepath.Join(directoryPath, fileNameWithTimeSuffix)
+		fileNameWithSuffix := fileNameWithTimeSuffix(originalFileBaseName)
+		targetFilePathWithTimeSuffix := filepath.Join(directoryPath, fileNameWithSuffix)
 		logger.WithError(copyErr).Debugf("File %s already exists, copying %s to %s", targetFilePath, filePath, targetFilePathWithTimeSuffix)
 		if copyErr = copyFileToTargetIfTargetDoesNotExist(logger, filePath, targetFilePathWithTimeSuffix); copyErr == nil {
 			logger.Debugf("Copied %s to %s", filePath, targetFilePathWithTimeSuffix)
-			return fileNameWithTimeSuffix, nil
+			return fileNameWithSuffix, nil
 		}
 	}
 	if copyErr != nil {
@@ -92,3 +111,22 @@ func copyFileToTargetIfTargetDoesNotExist(logger *log.Entry, filePath, targetPat
 	copyLogger.Debug("File copied successfully")
 	return nil
 }
+
+func fileSHA256(filePath string) (string, error) {
+	f, openErr := os.Open(filePath)
+	if openErr != nil {
+		return "", openErr
+	}
+	defer func() {
+		if closeErr := f.Close(); closeErr != nil {
+			log.WithError(closeErr).Debugf("Failed to close %s", filePath)
+		}
+	}()
+
+	h := sha256.New()
+	if _, copyErr := io.Copy(h, f); copyErr != nil {
+		return "", copyErr
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
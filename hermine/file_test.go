@@ -71,3 +71,13 @@ func Test_copyFileIntoDirectoryIfTargetDoesNotExist_targetAlreadyPresent(t *test
 	require.Len(t, dirEntries, 2)
 	assert.Equal(t, fileToCopyBaseName, dirEntries[0].Name())
 }
+
+func Test_fileSHA256(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "hello.txt")
+	require.NoError(t, os.WriteFile(filePath, []byte("hello"), 0o600))
+
+	hash, hashErr := fileSHA256(filePath)
+
+	require.NoError(t, hashErr)
+	assert.Equal(t, "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824", hash)
+}
@@ -0,0 +1,130 @@
+package hermine
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"strconv"
+)
+
+const (
+	importChangeInsert = "insert"
+	importChangeUpdate = "update"
+	importChangeCopy   = "copy"
+	importChangeDelete = "delete"
+	importChangeRemove = "remove"
+)
+
+// importChange describes a single change to the BelegManager database or data directory.
+type importChange struct {
+	Operation string         `json:"operation"`
+	Table     string         `json:"table,omitempty"`
+	ID        int64          `json:"id,omitempty"`
+	UUID      string         `json:"uuid,omitempty"`
+	Values    map[string]any `json:"values,omitempty"`
+	// Previous holds the values overwritten by an update, so it can be undone.
+	Previous   map[string]any `json:"previous,omitempty"`
+	SourcePath string         `json:"sourcePath,omitempty"`
+	TargetPath string         `json:"targetPath,omitempty"`
+}
+
+// importTx is the database transaction importing a single document, recording each change made.
+// In a dry run, the transaction is rolled back and files are not copied.
+type importTx struct {
+	*sqlx.Tx
+	schema  *schemaAdapter
+	dryRun  bool
+	changes []importChange
+}
+
+func (tx *importTx) recordChange(logger *log.Entry, change importChange) {
+	tx.changes = append(tx.changes, change)
+	logger.WithField("change", change).Trace("Recorded change")
+}
+
+func (c importChange) logFields() log.Fields {
+	fields := log.Fields{"operation": c.Operation}
+	if c.Table != "" {
+		fields["table"] = c.Table
+		fields["id"] = c.ID
+	}
+	if c.UUID != "" {
+		fields["uuid"] = c.UUID
+	}
+	if c.SourcePath != "" {
+		fields["source"] = c.SourcePath
+	}
+	if c.TargetPath != "" {
+		fields["target"] = c.TargetPath
+	}
+	if len(c.Values) > 0 {
+		fields["values"] = c.valuesAsJSON()
+	}
+
+	return fields
+}
+
+func (c importChange) valuesAsJSON() string {
+	if len(c.Values) == 0 {
+		return ""
+	}
+
+	valuesAsJSON, marshalErr := json.Marshal(c.Values)
+	if marshalErr != nil {
+		log.WithError(marshalErr).Debug("Failed to serialize change values")
+		return ""
+	}
+
+	return string(valuesAsJSON)
+}
+
+func (c importChange) toCsvRow(pathOfFileToImport string) []string {
+	id := ""
+	if c.Table != "" {
+		id = strconv.FormatInt(c.ID, 10)
+	}
+
+	return []string{pathOfFileToImport, c.Operation, c.Table, id, c.UUID, c.valuesAsJSON(), c.TargetPath}
+}
+
+func logPlannedChanges(pdds []*processingDoneData) {
+	for _, pdd := range pdds {
+		pddLogger := log.WithField("file_to_import_full_path", pdd.pathOfFileToImport)
+		for _, change := range pdd.changes {
+			pddLogger.WithFields(change.logFields()).Info("Dry run, planned change")
+		}
+	}
+}
+
+func writeDryRunReport(reportPath string, pdds []*processingDoneData) {
+	reportFile, createErr := os.Create(reportPath)
+	if createErr != nil {
+		log.WithError(createErr).Warnf("Failed to create dry run report %s", reportPath)
+		return
+	}
+	defer func() {
+		if err := reportFile.Close(); err != nil {
+			log.WithError(err).Debugf("Failed to close dry run report %s", reportPath)
+		}
+	}()
+
+	reportWriter := csv.NewWriter(reportFile)
+	defer reportWriter.Flush()
+
+	csvHeaders := []string{"OriginalPath", "Operation", "Table", "ID", "UUID", "Values", "Target"}
+	if writeHeadersErr := reportWriter.Write(csvHeaders); writeHeadersErr != nil {
+		log.WithError(writeHeadersErr).Warn("Failed to write CSV headers")
+	}
+
+	for _, pdd := range pdds {
+		for _, change := range pdd.changes {
+			if writeRowErr := reportWriter.Write(change.toCsvRow(pdd.pathOfFileToImport)); writeRowErr != nil {
+				log.WithError(writeRowErr).Warnf("Failed to write row to CSV file %s", reportPath)
+			}
+		}
+	}
+
+	log.Infof("Wrote dry run report %s", reportPath)
+}
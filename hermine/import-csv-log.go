@@ -14,6 +14,15 @@ type processingDoneData struct {
 	pathOfFileToImport string
 	beleg              *bmDocBeleg
 	doc                *diDocument
+	changes            []importChange
+	retries            int
+	duplicate          *duplicateDecision
+	afterImport        string
+	steuerfall         *bmDocSteuerfall
+	person             *bmDocPerson
+	labels             []*bmDocLabel
+	rules              string
+	vendor             string
 }
 
 func (pdd processingDoneData) toCsvLogRow() []string {
@@ -25,6 +34,8 @@ func (pdd processingDoneData) toCsvLogRow() []string {
 	docAsCsvLog := diDocumentToCsvLog(pdd.doc)
 	logRow = append(logRow, docAsCsvLog...)
 
+	logRow = append(logRow, strconv.Itoa(pdd.retries), pdd.duplicate.String(), pdd.afterImport, pdd.steuerfall.String(), pdd.person.String(), labelsString(pdd.labels), pdd.rules, pdd.vendor)
+
 	return logRow
 }
 
@@ -49,7 +60,7 @@ func writeToCsvLog(csvLogFile *os.File, pdds []*processingDoneData) {
 	csvLogFileWriter := csv.NewWriter(csvLogFile)
 	defer csvLogFileWriter.Flush()
 
-	csvHeaders := []string{"OriginalPath", "BelegID", "BelegName", "BelegDate", "InvoiceTotal", "InvoiceTotalConfidence", "VatRate"}
+	csvHeaders := []string{"OriginalPath", "BelegID", "BelegName", "BelegDate", "InvoiceTotal", "InvoiceTotalConfidence", "VatRate", "Retries", "Duplicate", "AfterImport", "Steuerfall", "Person", "Labels", "Rules", "VendorCategory"}
 	if writeHeadersErr := csvLogFileWriter.Write(csvHeaders); writeHeadersErr != nil {
 		log.WithError(writeHeadersErr).Warn("Failed to write CSV headers")
 	}
@@ -79,7 +90,7 @@ func belegToCsvLog(beleg *bmDocBeleg) []string {
 
 func diDocumentToCsvLog(d *diDocument) []string {
 	if d == nil {
-		return []string{"", "", ""}
+		return []string{"", ""}
 	}
 
 	return []string{
@@ -96,9 +107,9 @@ func convertFloatPointerToString(value *float64) string {
 	return ""
 }
 
-func diDocumentIsTypeInvoice(logger *log.Entry, d diDocument) error {
-	if !d.isTypeInvoice() {
-		err := fmt.Errorf("not an invoice, but %s", d.DocType)
+func diDocumentIsSupportedType(logger *log.Entry, d diDocument) error {
+	if !d.isTypeInvoice() && !d.isTypeReceipt() && d.customMapping == nil {
+		err := fmt.Errorf("neither an invoice nor a receipt nor mapped by a field mapping, but %s", d.DocType)
 		logger.WithError(err).Debug()
 		return err
 	}
@@ -1,28 +1,136 @@
 package hermine
 
 import (
+	"context"
 	"github.com/jmoiron/sqlx"
 	log "github.com/sirupsen/logrus"
 	"os"
 	"path/filepath"
+	"slices"
 	"sync"
+	"time"
 )
 
-func ProcessFiles(db *sqlx.DB, diEndpoint, diKey string, belegManagerDirectory *os.File, filesToImport []string) {
-	pdds := gatherResultsFromProcessingFiles(db, diEndpoint, diKey, belegManagerDirectory, filesToImport)
-	logToCsv(belegManagerDirectory, pdds)
+// ImportOptions configures how ProcessFiles imports documents into the BelegManager.
+type ImportOptions struct {
+	// DryRun analyzes and maps documents, but neither commits database changes nor touches the BelegManager directory.
+	DryRun bool
+	// DryRunReportPath optionally names a CSV file receiving the changes planned by a dry run.
+	DryRunReportPath string
+	// AnalysisConcurrency limits the number of files analyzed at the same time, at least 1.
+	AnalysisConcurrency int
+	// ImportConcurrency limits the number of documents written into the database at the same time, at least 1.
+	// SQLite allows a single writer only, so values above 1 may lead to "database is locked" errors.
+	ImportConcurrency int
+	// DocumentTimeout limits the analysis of a single file, 0 means no limit.
+	DocumentTimeout time.Duration
+	// DuplicatePolicy decides about documents matching an existing Beleg, see DuplicatePolicyCreate, the default.
+	DuplicatePolicy string
+	// FieldMappings map documents of custom models onto Belege, the first mapping matching a document's type is used.
+	FieldMappings []FieldMapping
+	// AfterImport handles the files to import once processed, ignored in a dry run.
+	AfterImport AfterImportOptions
+	// Steuerfall decides which Steuerfall new Belege are linked to.
+	Steuerfall SteuerfallOptions
+	// Customer decides whether the customer of a document is linked as category or as person.
+	Customer CustomerOptions
+	// Labels decide the labels of imported Belege.
+	Labels LabelOptions
+	// Rules assign categories, persons, Steuerfälle and labels to the Belege of the documents they match, nil for none.
+	Rules *Rules
+	// Vendor decides the category of a vendor, e.g. by aliases.
+	Vendor VendorOptions
 }
 
-func gatherResultsFromProcessingFiles(db *sqlx.DB, diEndpoint, diKey string, belegManagerDirectory *os.File, filesToImport []string) []*processingDoneData {
+// importRun holds everything shared by the files processed in one run.
+type importRun struct {
+	db                    *sqlx.DB
+	analyzer              DocumentAnalyzer
+	belegManagerDirectory *os.File
+	options               ImportOptions
+	importSlots           chan struct{}
+	assetFingerprints     *assetFingerprintIndex
+	// journal records the committed changes, nil in a dry run
+	journal *runJournal
+}
+
+func newImportRun(db *sqlx.DB, analyzer DocumentAnalyzer, belegManagerDirectory *os.File, options ImportOptions) *importRun {
+	var journal *runJournal
+	assetFingerprints := &assetFingerprintIndex{}
+	if belegManagerDirectory != nil {
+		if !options.DryRun {
+			journal = newRunJournal(belegManagerDirectory.Name())
+		}
+		if db != nil {
+			directoryLogger := log.WithField("beleg_manager_directory", belegManagerDirectory.Name())
+			assetFingerprints = newAssetFingerprintIndex(directoryLogger, db, belegManagerDirectory.Name())
+		}
+	}
+
+	return &importRun{
+		db:                    db,
+		analyzer:              analyzer,
+		belegManagerDirectory: belegManagerDirectory,
+		options:               options,
+		importSlots:           make(chan struct{}, max(options.ImportConcurrency, 1)),
+		assetFingerprints:     assetFingerprints,
+		journal:               journal,
+	}
+}
+
+// ProcessFiles analyzes the files and imports them into the BelegManager. When ctx is done, files not yet started are
+// skipped, running analyses are canceled, but running imports are finished and the CSV log is still written.
+func ProcessFiles(ctx context.Context, db *sqlx.DB, analyzer DocumentAnalyzer, belegManagerDirectory *os.File, filesToImport []string, options ImportOptions) {
+	r := newImportRun(db, analyzer, belegManagerDirectory, options)
+	pdds := r.gatherResultsFromProcessingFiles(ctx, filesToImport)
+	r.report(pdds)
+}
+
+// report writes the CSV log, or the planned changes of a dry run.
+func (r *importRun) report(pdds []*processingDoneData) {
+	if !r.options.DryRun {
+		logToCsv(r.belegManagerDirectory, pdds)
+		r.journal.logUndoHint()
+		return
+	}
+
+	logPlannedChanges(pdds)
+	if r.options.DryRunReportPath != "" {
+		writeDryRunReport(r.options.DryRunReportPath, pdds)
+	}
+}
+
+func (r *importRun) gatherResultsFromProcessingFiles(ctx context.Context, filesToImport []string) []*processingDoneData {
+	workers := min(max(r.options.AnalysisConcurrency, 1), len(filesToImport))
+	log.Debugf("Processing %d file(s) using %d worker(s)", len(filesToImport), workers)
+
 	results := make(chan []*processingDoneData)
+	pathsOfFilesToImport := make(chan string)
 	var wg sync.WaitGroup
-	for _, pathOfFileToImport := range filesToImport {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(pathsOfFilesToImport)
+		for i, pathOfFileToImport := range filesToImport {
+			select {
+			case pathsOfFilesToImport <- pathOfFileToImport:
+			case <-ctx.Done():
+				log.WithError(ctx.Err()).Warnf("Stopping, skipping %d file(s) not processed yet", len(filesToImport)-i)
+				results <- skippedFiles(filesToImport[i:])
+				return
+			}
+		}
+	}()
+
+	for range workers {
 		wg.Add(1)
 
-		go func(p string) {
+		go func() {
 			defer wg.Done()
-			results <- processFile(db, diEndpoint, diKey, belegManagerDirectory, p)
-		}(pathOfFileToImport)
+			for p := range pathsOfFilesToImport {
+				results <- r.processFile(ctx, p)
+			}
+		}()
 	}
 	go func() {
 		wg.Wait()
@@ -37,26 +145,60 @@ func gatherResultsFromProcessingFiles(db *sqlx.DB, diEndpoint, diKey string, bel
 	return pdds
 }
 
-func processFile(db *sqlx.DB, diEndpoint, diKey string, belegManagerDirectory *os.File, pathOfFileToImport string) []*processingDoneData {
+func skippedFiles(pathsOfFilesToImport []string) []*processingDoneData {
+	pdds := make([]*processingDoneData, len(pathsOfFilesToImport))
+	for i, pathOfFileToImport := range pathsOfFilesToImport {
+		pdds[i] = &processingDoneData{pathOfFileToImport: pathOfFileToImport}
+	}
+
+	return pdds
+}
+
+func (r *importRun) processFile(ctx context.Context, pathOfFileToImport string) []*processingDoneData {
 	pathOfFileToImportBaseName := filepath.Base(pathOfFileToImport)
 	fileLogger := log.
 		WithField("file_to_import_base_name", pathOfFileToImportBaseName).
 		WithField("file_to_import_full_path", pathOfFileToImport)
 	fileLogger.Tracef("Processing %s...", pathOfFileToImportBaseName)
 
-	analysisResult, arErr := enqueueAnalysisAndWaitForCompletion(fileLogger, diEndpoint, diKey, pathOfFileToImport)
+	analysisCtx, stats := withAnalysisStats(ctx)
+	if r.options.DocumentTimeout > 0 {
+		var cancel context.CancelFunc
+		analysisCtx, cancel = context.WithTimeout(analysisCtx, r.options.DocumentTimeout)
+		defer cancel()
+	}
+
+	// Once analyzed, documents are imported even if ctx is done, as the analysis might already have been paid for
+	analysisResult, arErr := r.analyzer.Analyze(analysisCtx, fileLogger, pathOfFileToImport)
+	retries := int(stats.retries.Load())
 	if arErr != nil {
-		pdd := processingDoneData{pathOfFileToImport: pathOfFileToImport}
-		return []*processingDoneData{&pdd}
+		pdds := []*processingDoneData{{pathOfFileToImport: pathOfFileToImport, retries: retries}}
+		// Files not analyzed due to stopping are not failed, but simply not imported yet
+		if ctx.Err() == nil {
+			r.handleProcessedFile(fileLogger, pathOfFileToImport, pdds)
+		}
+		return pdds
 	}
 
 	pdds := make([]*processingDoneData, 0, len(analysisResult.Documents))
 	for i, documentFromAnalysis := range analysisResult.Documents {
-		pdd := processingDoneData{pathOfFileToImport: pathOfFileToImport, doc: &documentFromAnalysis}
+		documentFromAnalysis.customMapping = findFieldMapping(r.options.FieldMappings, documentFromAnalysis.DocType)
+		documentFromAnalysis.modelID = analysisResult.ModelID
+		pdd := processingDoneData{pathOfFileToImport: pathOfFileToImport, doc: &documentFromAnalysis, retries: retries}
 		fileLogger.Debugf("%s analyzed, importing document nr %d...", pathOfFileToImportBaseName, i+1)
 
-		if beleg, importErr := importIntoBelegManager(fileLogger, db, belegManagerDirectory, pathOfFileToImport, documentFromAnalysis); importErr == nil {
-			pdd.beleg = beleg
+		r.importSlots <- struct{}{}
+		outcome, importErr := r.importIntoBelegManager(fileLogger, pathOfFileToImport, documentFromAnalysis)
+		<-r.importSlots
+		if importErr == nil {
+			pdd.beleg = outcome.beleg
+			pdd.changes = outcome.changes
+			pdd.duplicate = outcome.duplicate
+			pdd.steuerfall = outcome.steuerfall
+			pdd.person = outcome.person
+			pdd.labels = outcome.labels
+			pdd.rules = outcome.rules.String()
+			pdd.vendor = outcome.vendor.String()
 			fileLogger.Debugf("Document nr %d from %s imported", i+1, pathOfFileToImportBaseName)
 		} else {
 			fileLogger.WithError(importErr).Warn("Failed to import file")
@@ -64,56 +206,237 @@ func processFile(db *sqlx.DB, diEndpoint, diKey string, belegManagerDirectory *o
 
 		pdds = append(pdds, &pdd)
 	}
+	r.handleProcessedFile(fileLogger, pathOfFileToImport, pdds)
 
 	return pdds
 }
 
-func importIntoBelegManager(logger *log.Entry, db *sqlx.DB, belegManagerDirectory *os.File, pathOfFileToImport string, analysedDocument diDocument) (*bmDocBeleg, error) {
-	if documentIsNoInvoiceErr := diDocumentIsTypeInvoice(logger, analysedDocument); documentIsNoInvoiceErr != nil {
-		return nil, documentIsNoInvoiceErr
+// handleProcessedFile applies the after-import policy to a file, once all its documents are imported or failed to.
+// A file counts as imported, if it contains documents and each of them has been imported. Files containing a document
+// skipped as suspected duplicate are kept for review.
+func (r *importRun) handleProcessedFile(logger *log.Entry, pathOfFileToImport string, pdds []*processingDoneData) {
+	if r.options.DryRun {
+		logger.Tracef("Dry run, keeping %s", pathOfFileToImport)
+		return
+	}
+	if slices.ContainsFunc(pdds, func(pdd *processingDoneData) bool { return pdd.duplicate.skips() }) {
+		logger.Infof("Keeping %s, as a suspected duplicate has been skipped", pathOfFileToImport)
+		return
 	}
 
-	tx, beginTxErr := beginTransaction(db)
+	imported := len(pdds) > 0 && !slices.ContainsFunc(pdds, func(pdd *processingDoneData) bool {
+		return pdd.beleg == nil
+	})
+	afterImport := r.options.AfterImport.handleProcessedFile(logger, pathOfFileToImport, imported)
+	for _, pdd := range pdds {
+		pdd.afterImport = afterImport
+	}
+}
+
+// importOutcome describes the import of a single document.
+type importOutcome struct {
+	beleg *bmDocBeleg
+	// created reports whether the Beleg is new
+	created    bool
+	changes    []importChange
+	duplicate  *duplicateDecision
+	steuerfall *bmDocSteuerfall
+	person     *bmDocPerson
+	labels     []*bmDocLabel
+	rules      matchedRules
+	// vendor is the category chosen for the vendor, nil for documents without vendor or updating a Beleg
+	vendor *vendorMatch
+}
+
+func (r *importRun) importIntoBelegManager(logger *log.Entry, pathOfFileToImport string, analysedDocument diDocument) (outcome *importOutcome, err error) {
+	if unsupportedTypeErr := diDocumentIsSupportedType(logger, analysedDocument); unsupportedTypeErr != nil {
+		return nil, unsupportedTypeErr
+	}
+
+	tx, beginTxErr := beginTransaction(r.db, r.options.DryRun)
 	if beginTxErr != nil {
 		return nil, beginTxErr
 	}
-	defer finishTransaction(tx)
+	defer func() {
+		p := recover()
+		// Roll back instead of committing a partially imported document
+		if err != nil && p == nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				logger.WithError(rollbackErr).Warn("Failed to rollback transaction")
+			}
+			return
+		}
+		if finishErr := finishTransaction(tx, p); finishErr != nil {
+			outcome, err = nil, finishErr
+			return
+		}
+		if !tx.dryRun {
+			r.journal.record(logger, pathOfFileToImport, tx.changes)
+		}
+	}()
 
-	beleg, err := createOrUpdateBeleg(logger, tx, belegManagerDirectory, pathOfFileToImport, analysedDocument)
+	outcome, err = r.createOrUpdateBeleg(logger, tx, pathOfFileToImport, analysedDocument)
 	if err != nil {
 		return nil, err
 	}
 
-	if linkCustomerCategoryErr := linkCategoryToBeleg(logger, tx, analysedDocument, "CustomerName", beleg); linkCustomerCategoryErr != nil {
-		return nil, linkCustomerCategoryErr
+	outcome.rules = r.options.Rules.match(logger, pathOfFileToImport, analysedDocument)
+	if outcome.duplicate.createsBeleg() {
+		if linkErr := r.linkCategoriesAndPerson(logger, tx, analysedDocument, outcome); linkErr != nil {
+			return nil, linkErr
+		}
+	}
+	if outcome.created {
+		var linkSteuerfallErr error
+		if outcome.rules.Steuerfall != "" {
+			outcome.steuerfall, linkSteuerfallErr = linkRuleSteuerfallToBeleg(logger, tx, outcome.rules.Steuerfall, outcome.beleg)
+		} else {
+			outcome.steuerfall, linkSteuerfallErr =
+				linkSteuerfallToBeleg(logger, tx, r.options.Steuerfall, pathOfFileToImport, analysedDocument, outcome.beleg)
+		}
+		if linkSteuerfallErr != nil {
+			return nil, linkSteuerfallErr
+		}
 	}
-	if linkVendorCategoryErr := linkCategoryToBeleg(logger, tx, analysedDocument, "VendorName", beleg); linkVendorCategoryErr != nil {
-		return nil, linkVendorCategoryErr
+
+	if !outcome.duplicate.skips() {
+		var labelErr error
+		outcome.labels, labelErr = labelBeleg(logger, tx, r.options.Labels, analysedDocument, outcome.rules.Labels, outcome.beleg)
+		if labelErr != nil {
+			return nil, labelErr
+		}
 	}
 
-	return beleg, nil
+	outcome.changes = tx.changes
+	return outcome, nil
 }
 
-func createOrUpdateBeleg(logger *log.Entry, tx *sqlx.Tx, belegManagerDirectory *os.File, pathOfFileToImport string, analysedDocument diDocument) (*bmDocBeleg, error) {
-	fileToImportStatInfo, fileStatErr := os.Stat(pathOfFileToImport)
-	if fileStatErr != nil && !os.IsNotExist(fileStatErr) {
-		logger.WithError(fileStatErr).Warnf("Error checking for file %s ", pathOfFileToImport)
-		return nil, fileStatErr
+// linkCategoriesAndPerson links the categories and the person of a new Beleg. Categories assigned by rules replace the
+// categories from fields, e.g. the vendor, unless none of them exists, and a person assigned by rules replaces the
+// person of the customer.
+func (r *importRun) linkCategoriesAndPerson(logger *log.Entry, tx *importTx, analysedDocument diDocument, outcome *importOutcome) error {
+	personMode := r.options.Customer.Mode == CustomerModePerson
+	linkedRuleCategories, linkCategoriesErr := linkRuleCategoriesToBeleg(logger, tx, outcome.rules.Categories, outcome.beleg)
+	if linkCategoriesErr != nil {
+		return linkCategoriesErr
+	}
+	if linkedRuleCategories > 0 {
+		// The vendor's category is not linked, so it is not reported either
+		outcome.vendor = nil
+	} else {
+		for _, categoryField := range analysedDocument.fieldMapping().categories {
+			if personMode && categoryField == analysedDocument.fieldMapping().customer {
+				continue
+			}
+			if categoryField == analysedDocument.fieldMapping().vendor && outcome.vendor != nil {
+				if linkVendorErr := linkVendorCategoryToBeleg(logger, tx, outcome.vendor, outcome.beleg); linkVendorErr != nil {
+					return linkVendorErr
+				}
+				continue
+			}
+			if linkCategoryErr := linkCategoryToBeleg(logger, tx, analysedDocument, categoryField, outcome.beleg); linkCategoryErr != nil {
+				return linkCategoryErr
+			}
+		}
 	}
 
-	bmDocAssets, fileInfoForAsset, findAssetErr := findBmDocAssets(logger, tx, belegManagerDirectory, pathOfFileToImport)
+	var linkPersonErr error
+	switch {
+	case outcome.rules.Person != "":
+		outcome.person, linkPersonErr = linkRulePersonToBeleg(logger, tx, outcome.rules.Person, outcome.beleg)
+	case personMode:
+		outcome.person, linkPersonErr = linkPersonToBeleg(logger, tx, r.options.Customer, analysedDocument, outcome.beleg)
+	}
+	return linkPersonErr
+}
+
+// createOrUpdateBeleg updates the Beleg of an existing asset with equal content. Otherwise, it handles a duplicate
+// Beleg according to the duplicate policy, or creates a new Beleg and asset.
+func (r *importRun) createOrUpdateBeleg(logger *log.Entry, tx *importTx, pathOfFileToImport string, analysedDocument diDocument) (*importOutcome, error) {
+	fingerprint, hashErr := fileSHA256(pathOfFileToImport)
+	if hashErr != nil {
+		logger.WithError(hashErr).Warnf("Failed to fingerprint %s", pathOfFileToImport)
+		return nil, hashErr
+	}
+
+	existingAsset, findAssetErr := r.assetFingerprints.findAsset(logger, tx, fingerprint)
 	if findAssetErr != nil {
 		return nil, findAssetErr
 	}
+	if existingAsset != nil {
+		beleg, updateErr := updateBmDocBeleg(logger, tx, analysedDocument, existingAsset)
+		if updateErr != nil {
+			return nil, updateErr
+		}
+		return &importOutcome{beleg: beleg}, nil
+	}
 
-	if len(bmDocAssets) == 1 && !bmDocAssets[0].isDeleted() && fileInfoForAsset != nil && fileToImportStatInfo.Size() == fileInfoForAsset.Size() {
-		return updateBmDocBeleg(logger, tx, analysedDocument, bmDocAssets[0])
+	vendor, resolveVendorErr := resolveVendorCategory(logger, tx, r.options.Vendor, analysedDocument)
+	if resolveVendorErr != nil {
+		return nil, resolveVendorErr
+	}
+	decision, duplicateErr := r.decideAboutDuplicate(logger, tx, analysedDocument, vendor)
+	if duplicateErr != nil {
+		return nil, duplicateErr
 	}
 
-	return createBmDocBelegWithLinkedAsset(logger, tx, belegManagerDirectory, pathOfFileToImport, analysedDocument)
+	var newAsset *bmDocAsset
+	outcome := importOutcome{duplicate: decision, vendor: vendor}
+	switch {
+	case decision.createsBeleg():
+		var createErr error
+		outcome.beleg, newAsset, createErr =
+			createBmDocBelegWithLinkedAsset(logger, tx, r.belegManagerDirectory, pathOfFileToImport, analysedDocument, decision.reviewNote())
+		if createErr != nil {
+			return nil, createErr
+		}
+		outcome.created = true
+	case decision.policy == DuplicatePolicyLink:
+		var linkErr error
+		newAsset, linkErr = linkNewAssetToBmDocBeleg(logger, tx, r.belegManagerDirectory, pathOfFileToImport, decision.duplicate)
+		if linkErr != nil {
+			return nil, linkErr
+		}
+		outcome.beleg = decision.duplicate
+	default:
+		// Nothing is imported, the suspected duplicate may well be another document
+		return &outcome, nil
+	}
+	r.assetFingerprints.add(fingerprint, *newAsset.InternalPath)
+
+	return &outcome, nil
 }
 
-func linkCategoryToBeleg(logger *log.Entry, tx *sqlx.Tx, analysedDocument diDocument, fieldName string, beleg *bmDocBeleg) error {
+// decideAboutDuplicate returns the decision about an existing Beleg matching the document, or nil if there is none.
+func (r *importRun) decideAboutDuplicate(logger *log.Entry, tx *importTx, analysedDocument diDocument, vendor *vendorMatch) (*duplicateDecision, error) {
+	vendorCategoryName := ""
+	if vendor != nil {
+		vendorCategoryName = vendor.categoryName
+	}
+	duplicate, findDuplicateErr := findDuplicateBmDocBeleg(logger, tx, analysedDocument, vendorCategoryName)
+	if findDuplicateErr != nil || duplicate == nil {
+		return nil, findDuplicateErr
+	}
+
+	policy := r.options.DuplicatePolicy
+	if policy == "" {
+		policy = DuplicatePolicyCreate
+	}
+	logger.
+		WithField("duplicate_beleg_id", duplicate.ID).
+		WithField("duplicate_beleg_name", duplicate.Name).
+		WithField("duplicate_policy", policy).
+		Info("Document matches an existing Beleg")
+
+	return &duplicateDecision{policy: policy, duplicate: duplicate}, nil
+}
+
+func linkCategoryToBeleg(logger *log.Entry, tx *importTx, analysedDocument diDocument, fieldName string, beleg *bmDocBeleg) error {
+	if fieldName == "" || analysedDocument.getContentFieldCommaSeperated(fieldName) == "" {
+		logger.Debugf("No content for category field '%s'", fieldName)
+		return nil
+	}
+
 	cat, catErr := findOrCreateBmDocCategory(logger, tx, analysedDocument, fieldName)
 	if catErr != nil {
 		return catErr
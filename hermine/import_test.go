@@ -1,7 +1,9 @@
 package hermine
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/jmoiron/sqlx"
 	log "github.com/sirupsen/logrus"
@@ -9,6 +11,7 @@ import (
 	"github.com/stretchr/testify/require"
 	"os"
 	"path/filepath"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -36,32 +39,91 @@ func Test_importIntoBelegManager(t *testing.T) {
 
 	database := openDatabaseFixture(t, testLoggerEntry)
 	invoiceAbsFilePath, diAr := getDiResultFixture(t)
+	r := newImportRun(database, nil, tempDir, ImportOptions{})
 
 	// when
-	importedBeleg, importErrInsert := importIntoBelegManager(testLoggerEntry, database, tempDir, invoiceAbsFilePath, diAr.AnalyzeResult.Documents[0])
+	inserted, importErrInsert := r.importIntoBelegManager(testLoggerEntry, invoiceAbsFilePath, diAr.AnalyzeResult.Documents[0])
 	require.NoError(t, importErrInsert)
+	assert.Len(t, inserted.changes, 8)
 
 	// then
-	createdBeleg := assertBelegCreated(t, testLoggerEntry, database, tempDir, importedBeleg, invoiceAbsFilePath)
+	createdBeleg := assertBelegCreated(t, testLoggerEntry, database, tempDir, inserted.beleg, invoiceAbsFilePath)
 
 	// when
 	time.Sleep(1 * time.Second)
-	reimportedBeleg, importErrUpdate := importIntoBelegManager(testLoggerEntry, database, tempDir, invoiceAbsFilePath, diAr.AnalyzeResult.Documents[0])
+	updated, importErrUpdate := r.importIntoBelegManager(testLoggerEntry, invoiceAbsFilePath, diAr.AnalyzeResult.Documents[0])
 	require.NoError(t, importErrUpdate)
+	require.Len(t, updated.changes, 1)
+	assert.Equal(t, importChangeUpdate, updated.changes[0].Operation)
 
 	// then
-	assertBelegUpdate(t, testLoggerEntry, database, createdBeleg, reimportedBeleg)
+	assertBelegUpdate(t, testLoggerEntry, database, createdBeleg, updated.beleg)
+}
+
+func Test_importIntoBelegManager_identifiesAssetsByContent(t *testing.T) {
+	t.Parallel()
+
+	// given
+	testLogger, _ := newDebuggingNullLogger(t)
+	testLoggerEntry := testLogger.WithField("test", t.Name())
+
+	belegManagerDirectory, openDirErr := os.Open(t.TempDir())
+	require.NoError(t, openDirErr)
+	t.Cleanup(func() {
+		require.NoError(t, belegManagerDirectory.Close())
+	})
+
+	database := openDatabaseFixture(t, testLoggerEntry)
+	invoiceFilePath, diAr := getDiResultFixture(t)
+	document := diAr.AnalyzeResult.Documents[0]
+
+	importDirectory := t.TempDir()
+	renamedFilePath := filepath.Join(importDirectory, "renamed.png")
+	require.NoError(t, copyFileToTargetIfTargetDoesNotExist(testLoggerEntry, invoiceFilePath, renamedFilePath))
+	sameNameFilePath := filepath.Join(importDirectory, invoiceExampleFileName)
+	require.NoError(t, os.WriteFile(sameNameFilePath, []byte("another scan"), 0o600))
+
+	r := newImportRun(database, nil, belegManagerDirectory, ImportOptions{})
+	imported, importErr := r.importIntoBelegManager(testLoggerEntry, invoiceFilePath, document)
+	require.NoError(t, importErr)
+
+	// when
+	renamed, renamedImportErr := r.importIntoBelegManager(testLoggerEntry, renamedFilePath, document)
+
+	// then
+	require.NoError(t, renamedImportErr)
+	assert.Equal(t, imported.beleg.ID, renamed.beleg.ID, "file with equal content updates the existing Beleg")
+	require.Len(t, renamed.changes, 1)
+	assert.Equal(t, importChangeUpdate, renamed.changes[0].Operation)
+
+	// when
+	sameName, sameNameImportErr := r.importIntoBelegManager(testLoggerEntry, sameNameFilePath, document)
+
+	// then
+	require.NoError(t, sameNameImportErr)
+	assert.NotEqual(t, imported.beleg.ID, sameName.beleg.ID, "file with equal name but other content creates a new Beleg")
+	assert.Equal(t, importChangeCopy, sameName.changes[0].Operation)
+	assert.NotEqual(t, filepath.Join(belegManagerDirectory.Name(), invoiceExampleFileName), sameName.changes[0].TargetPath)
+
+	// when a new run fingerprints the assets in the data directory
+	r = newImportRun(database, nil, belegManagerDirectory, ImportOptions{})
+	assert.Len(t, r.assetFingerprints.internalPaths, 2, "fingerprinted before any transaction begins")
+	reimported, reimportErr := r.importIntoBelegManager(testLoggerEntry, sameNameFilePath, document)
+
+	// then
+	require.NoError(t, reimportErr)
+	assert.Equal(t, sameName.beleg.ID, reimported.beleg.ID)
 }
 
 func assertBelegCreated(t *testing.T, logger *log.Entry, db *sqlx.DB, belegManagerDirectory *os.File, importedBeleg *bmDocBeleg, pathOfFileToImport string) *bmDocBeleg {
 	t.Helper()
 
-	bmDocAssets, fileInfoForAsset, findAssetErr := findBmDocAssets(logger, db, belegManagerDirectory, pathOfFileToImport)
+	fingerprint, fingerprintErr := fileSHA256(pathOfFileToImport)
+	require.NoError(t, fingerprintErr)
+	docAsset, findAssetErr := newAssetFingerprintIndex(logger, db, belegManagerDirectory.Name()).findAsset(logger, db, fingerprint)
 	require.NoError(t, findAssetErr)
-	require.Len(t, bmDocAssets, 1)
-	require.NotNil(t, fileInfoForAsset)
+	require.NotNil(t, docAsset)
 
-	docAsset := bmDocAssets[0]
 	require.EqualValues(t, 1, docAsset.ID)
 	assert.NotEmpty(t, docAsset.UUID)
 	assert.Equal(t, invoiceExampleFileName, docAsset.Name)
@@ -186,3 +248,256 @@ func getDiResultFixture(t *testing.T) (string, *diAnalysisStatus) {
 	invoiceFilePath := filepath.Join(testDataDirectoryName, invoiceExampleFileName)
 	return invoiceFilePath, &diAr
 }
+
+type fixtureDocumentAnalyzer struct {
+	result *diAnalyzeResult
+	err    error
+}
+
+func (a fixtureDocumentAnalyzer) Analyze(_ context.Context, _ *log.Entry, _ string) (*diAnalyzeResult, error) {
+	return a.result, a.err
+}
+
+func Test_processFile_withDocumentAnalyzer(t *testing.T) {
+	t.Parallel()
+
+	// given
+	testLogger, _ := newDebuggingNullLogger(t)
+	testLoggerEntry := testLogger.WithField("test", t.Name())
+
+	tempDir, openTempDirErr := os.Open(t.TempDir())
+	require.NoError(t, openTempDirErr)
+	t.Cleanup(func() {
+		closeErr := tempDir.Close()
+		require.NoError(t, closeErr)
+	})
+
+	database := openDatabaseFixture(t, testLoggerEntry)
+	invoiceFilePath, diAr := getDiResultFixture(t)
+	r := newImportRun(database, fixtureDocumentAnalyzer{result: diAr.AnalyzeResult}, tempDir, ImportOptions{})
+
+	// when
+	pdds := r.processFile(context.Background(), invoiceFilePath)
+
+	// then
+	require.Len(t, pdds, 1)
+	assert.Equal(t, invoiceFilePath, pdds[0].pathOfFileToImport)
+	require.NotNil(t, pdds[0].beleg)
+	assert.EqualValues(t, "654123", *pdds[0].beleg.Number)
+}
+
+func Test_processFile_analysisFailed(t *testing.T) {
+	t.Parallel()
+
+	// given
+	testLogger, _ := newDebuggingNullLogger(t)
+	testLoggerEntry := testLogger.WithField("test", t.Name())
+
+	database := openDatabaseFixture(t, testLoggerEntry)
+	invoiceFilePath := filepath.Join(testDataDirectoryName, invoiceExampleFileName)
+	r := newImportRun(database, fixtureDocumentAnalyzer{err: errors.New("analysis failed")}, nil, ImportOptions{})
+
+	// when
+	pdds := r.processFile(context.Background(), invoiceFilePath)
+
+	// then
+	require.Len(t, pdds, 1)
+	assert.Equal(t, invoiceFilePath, pdds[0].pathOfFileToImport)
+	assert.Nil(t, pdds[0].beleg)
+	assert.Nil(t, pdds[0].doc)
+}
+
+// retryingDocumentAnalyzer counts retries like the Azure analyzer, before returning its result.
+type retryingDocumentAnalyzer struct {
+	fixtureDocumentAnalyzer
+	retries int
+}
+
+func (a retryingDocumentAnalyzer) Analyze(ctx context.Context, logger *log.Entry, path string) (*diAnalyzeResult, error) {
+	for range a.retries {
+		countRetry(ctx)
+	}
+	return a.fixtureDocumentAnalyzer.Analyze(ctx, logger, path)
+}
+
+func Test_processFile_retriesWithDocumentTimeout(t *testing.T) {
+	t.Parallel()
+
+	// given
+	invoiceFilePath := filepath.Join(testDataDirectoryName, invoiceExampleFileName)
+	analyzer := retryingDocumentAnalyzer{fixtureDocumentAnalyzer: fixtureDocumentAnalyzer{err: errors.New("analysis failed")}, retries: 2}
+	r := newImportRun(nil, analyzer, nil, ImportOptions{DocumentTimeout: time.Minute})
+
+	// when
+	pdds := r.processFile(context.Background(), invoiceFilePath)
+
+	// then
+	require.Len(t, pdds, 1)
+	assert.Equal(t, 2, pdds[0].retries)
+	assert.Nil(t, pdds[0].beleg)
+}
+
+func Test_processFile_afterImport(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name         string
+		analyzerErr  error
+		dryRun       bool
+		wantInFolder string
+	}{
+		{name: "imported", wantInFolder: "processed"},
+		{name: "failed", analyzerErr: errors.New("analysis failed"), wantInFolder: "failed"},
+		{name: "dry run", dryRun: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			// given
+			testLogger, _ := newDebuggingNullLogger(t)
+			testLoggerEntry := testLogger.WithField("test", t.Name())
+
+			tempDir := t.TempDir()
+			belegManagerDirectory, openDirErr := os.Open(t.TempDir())
+			require.NoError(t, openDirErr)
+			t.Cleanup(func() {
+				closeErr := belegManagerDirectory.Close()
+				require.NoError(t, closeErr)
+			})
+
+			invoiceFixturePath, diAr := getDiResultFixture(t)
+			sourceDirectory := filepath.Join(tempDir, "import")
+			require.NoError(t, os.MkdirAll(sourceDirectory, 0o755))
+			_, copyErr := copyFileIntoDirectoryIfTargetDoesNotExist(testLoggerEntry, invoiceFixturePath, sourceDirectory)
+			require.NoError(t, copyErr)
+			invoiceFilePath := filepath.Join(sourceDirectory, invoiceExampleFileName)
+
+			afterImportOptions, optionsErr := NewAfterImportOptions(
+				[]string{AfterImportMove, AfterImportMoveFailed},
+				filepath.Join(sourceDirectory, "*.png"),
+				filepath.Join(tempDir, "processed"),
+				filepath.Join(tempDir, "failed"),
+			)
+			require.NoError(t, optionsErr)
+
+			database := openDatabaseFixture(t, testLoggerEntry)
+			analyzer := fixtureDocumentAnalyzer{result: diAr.AnalyzeResult, err: tt.analyzerErr}
+			r := newImportRun(database, analyzer, belegManagerDirectory, ImportOptions{DryRun: tt.dryRun, AfterImport: afterImportOptions})
+
+			// when
+			pdds := r.processFile(context.Background(), invoiceFilePath)
+
+			// then
+			require.Len(t, pdds, 1)
+			if tt.wantInFolder == "" {
+				assert.FileExists(t, invoiceFilePath)
+				assert.Empty(t, pdds[0].afterImport)
+				return
+			}
+			assert.NoFileExists(t, invoiceFilePath)
+			assert.FileExists(t, filepath.Join(tempDir, tt.wantInFolder, invoiceExampleFileName))
+			assert.NotEmpty(t, pdds[0].afterImport)
+		})
+	}
+}
+
+func Test_importIntoBelegManager_dryRun(t *testing.T) {
+	t.Parallel()
+
+	// given
+	testLogger, _ := newDebuggingNullLogger(t)
+	testLoggerEntry := testLogger.WithField("test", t.Name())
+
+	tempDir, openTempDirErr := os.Open(t.TempDir())
+	require.NoError(t, openTempDirErr)
+	t.Cleanup(func() {
+		closeErr := tempDir.Close()
+		require.NoError(t, closeErr)
+	})
+
+	database := openDatabaseFixture(t, testLoggerEntry)
+	invoiceFilePath, diAr := getDiResultFixture(t)
+	r := newImportRun(database, nil, tempDir, ImportOptions{DryRun: true})
+
+	// when
+	outcome, importErr := r.importIntoBelegManager(testLoggerEntry, invoiceFilePath, diAr.AnalyzeResult.Documents[0])
+
+	// then
+	require.NoError(t, importErr)
+	require.NotNil(t, outcome.beleg)
+	changes := outcome.changes
+	require.Len(t, changes, 8)
+	assert.Equal(t, importChangeCopy, changes[0].Operation)
+	assert.Equal(t, filepath.Join(tempDir.Name(), invoiceExampleFileName), changes[0].TargetPath)
+	assert.Equal(t, "BmDoc_Asset", changes[1].Table)
+	assert.Equal(t, "BmDoc_Beleg", changes[2].Table)
+	assert.Equal(t, "654123", *changes[2].Values["number"].(*string))
+
+	dirEntries, readDirErr := os.ReadDir(tempDir.Name())
+	require.NoError(t, readDirErr)
+	assert.Empty(t, dirEntries)
+
+	var belegCount int
+	require.NoError(t, database.Get(&belegCount, "SELECT COUNT(*) FROM BmDoc_Beleg"))
+	assert.Zero(t, belegCount)
+}
+
+type concurrencyMeasuringDocumentAnalyzer struct {
+	running    atomic.Int32
+	maxRunning atomic.Int32
+}
+
+func (a *concurrencyMeasuringDocumentAnalyzer) Analyze(_ context.Context, _ *log.Entry, _ string) (*diAnalyzeResult, error) {
+	running := a.running.Add(1)
+	defer a.running.Add(-1)
+	for {
+		maxRunning := a.maxRunning.Load()
+		if running <= maxRunning || a.maxRunning.CompareAndSwap(maxRunning, running) {
+			break
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	return &diAnalyzeResult{}, nil
+}
+
+func Test_gatherResultsFromProcessingFiles_boundedConcurrency(t *testing.T) {
+	t.Parallel()
+
+	// given
+	analyzer := &concurrencyMeasuringDocumentAnalyzer{}
+	r := newImportRun(nil, analyzer, nil, ImportOptions{AnalysisConcurrency: 3})
+	filesToImport := make([]string, 20)
+	for i := range filesToImport {
+		filesToImport[i] = fmt.Sprintf("file%d.pdf", i)
+	}
+
+	// when
+	pdds := r.gatherResultsFromProcessingFiles(context.Background(), filesToImport)
+
+	// then
+	assert.Empty(t, pdds)
+	assert.LessOrEqual(t, analyzer.maxRunning.Load(), int32(3))
+	assert.Positive(t, analyzer.maxRunning.Load())
+}
+
+func Test_gatherResultsFromProcessingFiles_canceled(t *testing.T) {
+	t.Parallel()
+
+	// given
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r := newImportRun(nil, &concurrencyMeasuringDocumentAnalyzer{}, nil, ImportOptions{AnalysisConcurrency: 2})
+	filesToImport := []string{"file1.pdf", "file2.pdf", "file3.pdf"}
+
+	// when
+	pdds := r.gatherResultsFromProcessingFiles(ctx, filesToImport)
+
+	// then
+	require.NotEmpty(t, pdds)
+	for _, pdd := range pdds {
+		assert.Contains(t, filesToImport, pdd.pathOfFileToImport)
+		assert.Nil(t, pdd.beleg)
+	}
+}
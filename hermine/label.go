@@ -0,0 +1,158 @@
+package hermine
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"strings"
+	"time"
+)
+
+const selectActiveBmDocLabelByNameQuery = "SELECT " + bmDocLabelColumns + " FROM BmDoc_Label WHERE name = ? COLLATE NOCASE AND (deleteState IS NULL OR deleteState = 0) ORDER BY id"
+
+// Label template placeholders, besides the fields of the document like "{VendorName}".
+const (
+	labelPlaceholderDate  = "{date}"
+	labelPlaceholderModel = "{model}"
+)
+
+// LabelOptions decide the labels of imported Belege, making them filterable in BelegManager, e.g. for review.
+type LabelOptions struct {
+	// Templates are the names of the labels. "{date}" is replaced by the date of the import, "{model}" by the
+	// Document Intelligence model and "{<field name>}" by the content of the document's field. Labels expanding to an
+	// empty name are left out.
+	Templates []string `mapstructure:"templates"`
+	// LowConfidenceThreshold is the confidence of the total below which the LowConfidence label is added, 0 disables it.
+	LowConfidenceThreshold float64 `mapstructure:"low-confidence-threshold"`
+	// LowConfidence is the label of Belege whose total has a low confidence, or has not been recognized at all.
+	LowConfidence string `mapstructure:"low-confidence"`
+}
+
+func (o LabelOptions) Validate() error {
+	if o.LowConfidenceThreshold < 0 || o.LowConfidenceThreshold > 1 {
+		return fmt.Errorf("low confidence threshold %v is not between 0 and 1", o.LowConfidenceThreshold)
+	}
+	if o.LowConfidenceThreshold > 0 && strings.TrimSpace(o.LowConfidence) == "" {
+		return errors.New("low confidence threshold without low confidence label")
+	}
+
+	return nil
+}
+
+// labelNames returns the names of the labels of the document, followed by the labels assigned by rules, without
+// duplicates.
+func (o LabelOptions) labelNames(analysedDocument diDocument, ruleLabels []string, importDate time.Time) []string {
+	names := make([]string, 0, len(o.Templates)+len(ruleLabels)+1)
+	addName := func(name string) {
+		name = strings.TrimSpace(name)
+		if name != "" && !containsFold(names, name) {
+			names = append(names, name)
+		}
+	}
+
+	for _, template := range o.Templates {
+		addName(analysedDocument.expandLabelTemplate(template, importDate))
+	}
+	for _, ruleLabel := range ruleLabels {
+		addName(ruleLabel)
+	}
+	if o.LowConfidenceThreshold > 0 {
+		if grossConfidence := analysedDocument.getGrossConfidence(); grossConfidence == nil || *grossConfidence < o.LowConfidenceThreshold {
+			addName(o.LowConfidence)
+		}
+	}
+
+	return names
+}
+
+// expandLabelTemplate replaces the label placeholders, and the placeholders of fields like expandFieldTemplate.
+func (d *diDocument) expandLabelTemplate(template string, importDate time.Time) string {
+	return fieldTemplatePlaceholder.ReplaceAllStringFunc(template, func(placeholder string) string {
+		switch placeholder {
+		case labelPlaceholderDate:
+			return importDate.Format(time.DateOnly)
+		case labelPlaceholderModel:
+			if d.modelID != "" {
+				return d.modelID
+			}
+			return d.DocType
+		default:
+			return d.expandFieldTemplate(placeholder)
+		}
+	})
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// labelBeleg links the labels of the document and the labels assigned by rules to the Beleg, creating labels not
+// existing yet.
+func labelBeleg(logger *log.Entry, tx *importTx, options LabelOptions, analysedDocument diDocument, ruleLabels []string, beleg *bmDocBeleg) ([]*bmDocLabel, error) {
+	labelNames := options.labelNames(analysedDocument, ruleLabels, time.Now())
+	labels := make([]*bmDocLabel, 0, len(labelNames))
+	for _, labelName := range labelNames {
+		labelLogger := logger.WithField("label", labelName)
+		label, findErr := findOrCreateBmDocLabel(labelLogger, tx, labelName)
+		if findErr != nil {
+			return nil, findErr
+		}
+
+		if createLinkErr := createIgnoreBmDocLink(labelLogger, tx, label.UUID, beleg.UUID); createLinkErr != nil {
+			return nil, createLinkErr
+		}
+		labelLogger.Debug("Beleg labelled")
+		labels = append(labels, label)
+	}
+
+	return labels, nil
+}
+
+func findOrCreateBmDocLabel(logger *log.Entry, tx *importTx, labelName string) (*bmDocLabel, error) {
+	label, findErr := findBmDocLabelByName(logger, tx, labelName)
+	if label != nil || findErr != nil {
+		return label, findErr
+	}
+
+	bmDocUUID := newBmDocUUID()
+	now := time.Now().Format(bmDocRFC3339Milli)
+	insertQuery, args := tx.schema.insertQuery("BmDoc_Label", map[string]any{
+		"uuid": bmDocUUID, "name": labelName, "docDate": now, "timestampCreated": now,
+	})
+	result, err := tx.Exec(insertQuery, args...)
+	if err != nil {
+		logger.WithError(err).Warnf("Error when inserting new BmDoc_Label '%s'", labelName)
+		return nil, err
+	}
+	recordInsert(logger, tx, "BmDoc_Label", result, bmDocUUID, map[string]any{"name": labelName})
+
+	return findBmDocLabelByName(logger, tx, labelName)
+}
+
+// findBmDocLabelByName returns the oldest active label with the name ignoring case, like labelNames, or nil.
+func findBmDocLabelByName(logger *log.Entry, q sqlxSelecter, labelName string) (*bmDocLabel, error) {
+	labels := make([]*bmDocLabel, 0)
+	if err := q.Select(&labels, selectActiveBmDocLabelByNameQuery, labelName); err != nil {
+		logger.WithError(err).Warnf("Error when searching for %s BmDoc_Label", labelName)
+		return nil, err
+	}
+
+	if len(labels) == 0 {
+		return nil, nil
+	}
+	return labels[0], nil
+}
+
+// labelsString returns the names of the labels, separated by comma.
+func labelsString(labels []*bmDocLabel) string {
+	names := make([]string, 0, len(labels))
+	for _, label := range labels {
+		names = append(names, label.Name)
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,123 @@
+package hermine
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_LabelOptions_labelNames(t *testing.T) {
+	t.Parallel()
+
+	confidentTotal := diDocumentField{Confidence: 0.95}
+	unsureTotal := diDocumentField{Confidence: 0.5}
+	importDate := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		options    LabelOptions
+		document   diDocument
+		ruleLabels []string
+		want       []string
+	}{
+		{name: "none", document: diDocument{DocType: documentTypeInvoice}, want: []string{}},
+		{
+			name:     "templates",
+			options:  LabelOptions{Templates: []string{"Hermine-Import", "Import {date}", "{model}", "Vendor {VendorName}"}},
+			document: diDocument{DocType: documentTypeInvoice, modelID: "prebuilt-invoice", Fields: map[string]diDocumentField{"VendorName": {Content: "CONTOSO"}}},
+			want:     []string{"Hermine-Import", "Import 2026-10-18", "prebuilt-invoice", "Vendor CONTOSO"},
+		},
+		{name: "rule labels", options: LabelOptions{Templates: []string{"Hermine-Import"}}, document: diDocument{DocType: documentTypeInvoice}, ruleLabels: []string{"§35a", "hermine-import"}, want: []string{"Hermine-Import", "§35a"}},
+		{name: "model unknown", options: LabelOptions{Templates: []string{"{model}"}}, document: diDocument{DocType: documentTypeInvoice}, want: []string{"invoice"}},
+		{name: "empty and duplicate", options: LabelOptions{Templates: []string{"{CustomerName}", "Hermine", "hermine"}}, document: diDocument{DocType: documentTypeInvoice}, want: []string{"Hermine"}},
+		{
+			name:     "confident",
+			options:  LabelOptions{LowConfidenceThreshold: 0.8, LowConfidence: "low-confidence"},
+			document: diDocument{DocType: documentTypeInvoice, Fields: map[string]diDocumentField{"InvoiceTotal": confidentTotal}},
+			want:     []string{},
+		},
+		{
+			name:     "low confidence",
+			options:  LabelOptions{LowConfidenceThreshold: 0.8, LowConfidence: "low-confidence"},
+			document: diDocument{DocType: documentTypeInvoice, Fields: map[string]diDocumentField{"InvoiceTotal": unsureTotal}},
+			want:     []string{"low-confidence"},
+		},
+		{name: "total missing", options: LabelOptions{LowConfidenceThreshold: 0.8, LowConfidence: "low-confidence"}, document: diDocument{DocType: documentTypeInvoice}, want: []string{"low-confidence"}},
+		{
+			name:     "low confidence disabled",
+			options:  LabelOptions{LowConfidence: "low-confidence"},
+			document: diDocument{DocType: documentTypeInvoice, Fields: map[string]diDocumentField{"InvoiceTotal": unsureTotal}},
+			want:     []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tt.want, tt.options.labelNames(tt.document, tt.ruleLabels, importDate))
+		})
+	}
+}
+
+func Test_LabelOptions_Validate(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, LabelOptions{}.Validate())
+	assert.NoError(t, LabelOptions{Templates: []string{"Hermine-Import"}, LowConfidenceThreshold: 0.8, LowConfidence: "low-confidence"}.Validate())
+	assert.Error(t, LabelOptions{LowConfidenceThreshold: 1.5, LowConfidence: "low-confidence"}.Validate())
+	assert.Error(t, LabelOptions{LowConfidenceThreshold: 0.8}.Validate())
+}
+
+func Test_importIntoBelegManager_labels(t *testing.T) {
+	t.Parallel()
+
+	// given
+	testLoggerEntry := newDummyLogEntry(t)
+	belegManagerDirectory, openDirErr := os.Open(t.TempDir())
+	require.NoError(t, openDirErr)
+	t.Cleanup(func() {
+		require.NoError(t, belegManagerDirectory.Close())
+	})
+
+	database := openPlainDatabase(t, copyDatabaseFixtureIntoDirectory(t, t.TempDir()))
+	existingLabelUUID := newBmDocUUID()
+	_, insertErr := database.Exec("INSERT INTO BmDoc_Label (uuid, name, docType, deleteState) VALUES (?, 'Hermine-Import', 5, 0)", existingLabelUUID)
+	require.NoError(t, insertErr)
+	countsBefore := countBmDocRows(t, database)
+	invoiceFilePath, diAr := getDiResultFixture(t)
+	options := LabelOptions{Templates: []string{"hermine-import", "{model}"}, LowConfidenceThreshold: 0.99, LowConfidence: "low-confidence"}
+	r := newImportRun(database, nil, belegManagerDirectory, ImportOptions{Labels: options})
+
+	// when
+	outcome, importErr := r.importIntoBelegManager(testLoggerEntry, invoiceFilePath, diAr.AnalyzeResult.Documents[0])
+
+	// then
+	require.NoError(t, importErr)
+	assert.Equal(t, "Hermine-Import, invoice, low-confidence", labelsString(outcome.labels))
+	assert.Equal(t, existingLabelUUID, outcome.labels[0].UUID, "existing label reused, ignoring case")
+	assert.Equal(t, countsBefore["BmDoc_Label"]+2, countBmDocRows(t, database)["BmDoc_Label"])
+	links, findLinksErr := findBmDocLinkByBelegAsTarget(testLoggerEntry, database, outcome.beleg)
+	require.NoError(t, findLinksErr)
+	assert.Len(t, links, 6, "asset, categories and labels")
+	for _, label := range outcome.labels {
+		assert.True(t, containsLink(links, label.UUID, outcome.beleg.UUID), label.Name)
+	}
+
+	// when
+	undoErr := UndoRun(database, belegManagerDirectory, r.journal.runID, false)
+
+	// then
+	require.NoError(t, undoErr)
+	assert.Equal(t, countsBefore, countBmDocRows(t, database), "created labels removed, existing label kept")
+}
+
+func containsLink(links []bmDocLink, sourceUUID, targetUUID string) bool {
+	for _, link := range links {
+		if link.SourceUUID == sourceUUID && link.TargetUUID == targetUUID {
+			return true
+		}
+	}
+	return false
+}
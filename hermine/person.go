@@ -0,0 +1,158 @@
+package hermine
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Customer modes decide how the customer of a document, e.g. the CustomerName of an invoice, is imported.
+const (
+	// CustomerModeCategory links the Beleg to a category named like the customer.
+	CustomerModeCategory = "category"
+	// CustomerModePerson links the Beleg to the person of the customer, see CustomerOptions.
+	CustomerModePerson = "person"
+)
+
+const selectActiveBmDocPersonsQuery = "SELECT " + bmDocPersonColumns + " FROM BmDoc_Person WHERE deleteState IS NULL OR deleteState = 0 ORDER BY id"
+
+func ValidCustomerModes() []string {
+	return []string{CustomerModeCategory, CustomerModePerson}
+}
+
+func IsValidCustomerMode(mode string) bool {
+	return slices.Contains(ValidCustomerModes(), mode)
+}
+
+// CustomerOptions decide how the customer of a document is imported.
+type CustomerOptions struct {
+	// Mode is CustomerModeCategory, the default, or CustomerModePerson.
+	Mode string `mapstructure:"mode"`
+	// CreatePersons creates a person for customers not matching any existing person, else they are not linked.
+	CreatePersons bool `mapstructure:"create-persons"`
+	// Persons list the names a person appears with in documents, e.g. "M. Mustermann" for "Max Mustermann".
+	Persons []PersonAliases `mapstructure:"persons"`
+}
+
+// PersonAliases lists the names a person of BelegManager appears with in documents.
+type PersonAliases struct {
+	// Name is the name of the person in BelegManager.
+	Name    string   `mapstructure:"name"`
+	Aliases []string `mapstructure:"aliases"`
+}
+
+func (p PersonAliases) Validate() error {
+	if normalizePersonName(p.Name) == "" {
+		return errors.New("person without name")
+	}
+
+	return nil
+}
+
+// personName returns the name of the person the customer name is an alias of, or the customer name itself.
+func (o CustomerOptions) personName(customerName string) string {
+	normalizedCustomerName := normalizePersonName(customerName)
+	for _, person := range o.Persons {
+		names := append([]string{person.Name}, person.Aliases...)
+		if slices.ContainsFunc(names, func(name string) bool { return normalizePersonName(name) == normalizedCustomerName }) {
+			return person.Name
+		}
+	}
+
+	return customerName
+}
+
+// normalizePersonName ignores case, punctuation and whitespace, so "M. Mustermann" equals "m mustermann".
+func normalizePersonName(name string) string {
+	words := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.Join(words, " ")
+}
+
+// linkPersonToBeleg links the person of the customer to the Beleg, creating the person if configured to. Customers
+// not matching any person are logged only, as the Beleg can be assigned in BelegManager later.
+func linkPersonToBeleg(logger *log.Entry, tx *importTx, options CustomerOptions, analysedDocument diDocument, beleg *bmDocBeleg) (*bmDocPerson, error) {
+	customerField := analysedDocument.fieldMapping().customer
+	customerName := strings.ReplaceAll(analysedDocument.Fields[customerField].Content, "\n", " ")
+	if customerField == "" || strings.TrimSpace(customerName) == "" {
+		logger.Debug("No customer, not linking any person")
+		return nil, nil
+	}
+
+	personName := options.personName(customerName)
+	personLogger := logger.WithField("customer_name", customerName).WithField("person_name", personName)
+	person, findErr := findBmDocPersonByName(personLogger, tx, personName)
+	if findErr != nil {
+		return nil, findErr
+	}
+	if person == nil && !options.CreatePersons {
+		personLogger.Warn("No person found for the customer, configure an alias or to create persons")
+		return nil, nil
+	}
+	if person == nil {
+		if person, findErr = createBmDocPerson(personLogger, tx, personName); findErr != nil {
+			return nil, findErr
+		}
+	}
+
+	if createLinkErr := createIgnoreBmDocLink(personLogger, tx, person.UUID, beleg.UUID); createLinkErr != nil {
+		return nil, createLinkErr
+	}
+	personLogger.WithField("person_id", person.ID).Debug("Beleg linked to person")
+
+	return person, nil
+}
+
+// findBmDocPersonByName returns the active person with the name, compared like normalizePersonName, or nil.
+func findBmDocPersonByName(logger *log.Entry, q sqlxSelecter, name string) (*bmDocPerson, error) {
+	persons := make([]*bmDocPerson, 0)
+	if err := q.Select(&persons, selectActiveBmDocPersonsQuery); err != nil {
+		logger.WithError(err).Warn("Error when searching BmDoc_Person")
+		return nil, err
+	}
+
+	normalizedName := normalizePersonName(name)
+	persons = slices.DeleteFunc(persons, func(p *bmDocPerson) bool {
+		return normalizePersonName(p.Name) != normalizedName
+	})
+	if len(persons) > 1 {
+		err := fmt.Errorf("BmDoc_Person %s exists more than once, check in BelegManager", name)
+		logger.Warn(err)
+		return nil, err
+	}
+
+	if len(persons) == 1 {
+		return persons[0], nil
+	}
+	return nil, nil
+}
+
+func createBmDocPerson(logger *log.Entry, tx *importTx, name string) (*bmDocPerson, error) {
+	bmDocUUID := newBmDocUUID()
+	now := time.Now().Format(bmDocRFC3339Milli)
+	insertQuery, args := tx.schema.insertQuery("BmDoc_Person", map[string]any{
+		"uuid": bmDocUUID, "name": name, "docDate": now, "timestampCreated": now,
+	})
+	result, err := tx.Exec(insertQuery, args...)
+	if err != nil {
+		logger.WithError(err).Warnf("Error when inserting new BmDoc_Person '%s'", name)
+		return nil, err
+	}
+	recordInsert(logger, tx, "BmDoc_Person", result, bmDocUUID, map[string]any{"name": name})
+	logger.Info("New person created")
+
+	return findBmDocPersonByName(logger, tx, name)
+}
+
+func (p *bmDocPerson) String() string {
+	if p == nil {
+		return ""
+	}
+
+	return fmt.Sprintf("%d: %s", p.ID, p.Name)
+}
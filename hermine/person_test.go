@@ -0,0 +1,164 @@
+package hermine
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+)
+
+func Test_CustomerOptions_personName(t *testing.T) {
+	t.Parallel()
+
+	options := CustomerOptions{Persons: []PersonAliases{
+		{Name: "Max Mustermann", Aliases: []string{"M. Mustermann", "Familie Mustermann"}},
+		{Name: "Erika Musterfrau"},
+	}}
+
+	tests := []struct {
+		customerName string
+		want         string
+	}{
+		{customerName: "Max Mustermann", want: "Max Mustermann"},
+		{customerName: "M. Mustermann", want: "Max Mustermann"},
+		{customerName: "FAMILIE\nMUSTERMANN", want: "Max Mustermann"},
+		{customerName: "m mustermann", want: "Max Mustermann"},
+		{customerName: " Erika  Musterfrau ", want: "Erika Musterfrau"},
+		{customerName: "Mustermann GmbH", want: "Mustermann GmbH"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.customerName, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tt.want, options.personName(tt.customerName))
+		})
+	}
+}
+
+func Test_PersonAliases_Validate(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, PersonAliases{Name: "Max Mustermann", Aliases: []string{"M. Mustermann"}}.Validate())
+	assert.Error(t, PersonAliases{Name: " . ", Aliases: []string{"M. Mustermann"}}.Validate())
+}
+
+func Test_importIntoBelegManager_person(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		persons    []string
+		options    CustomerOptions
+		wantPerson string
+	}{
+		{name: "category mode", persons: []string{"Microsoft"}, options: CustomerOptions{Mode: CustomerModeCategory}},
+		{name: "existing person", persons: []string{"Max Mustermann", "Microsoft"}, options: CustomerOptions{Mode: CustomerModePerson}, wantPerson: "Microsoft"},
+		{name: "alias", persons: []string{"Max Mustermann"}, options: CustomerOptions{Mode: CustomerModePerson, Persons: []PersonAliases{{Name: "Max Mustermann", Aliases: []string{"Microsoft"}}}}, wantPerson: "Max Mustermann"},
+		{name: "none found", persons: []string{"Max Mustermann"}, options: CustomerOptions{Mode: CustomerModePerson}},
+		{name: "none found, but created", options: CustomerOptions{Mode: CustomerModePerson, CreatePersons: true}, wantPerson: "MICROSOFT"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			// given
+			testLoggerEntry := newDummyLogEntry(t)
+			belegManagerDirectory, openDirErr := os.Open(t.TempDir())
+			require.NoError(t, openDirErr)
+			t.Cleanup(func() {
+				require.NoError(t, belegManagerDirectory.Close())
+			})
+
+			database := openPlainDatabase(t, copyDatabaseFixtureIntoDirectory(t, t.TempDir()))
+			for _, person := range tt.persons {
+				_, insertErr := database.Exec("INSERT INTO BmDoc_Person (uuid, name, docType, deleteState) VALUES (?, ?, 2, 0)", newBmDocUUID(), person)
+				require.NoError(t, insertErr)
+			}
+			invoiceFilePath, diAr := getDiResultFixture(t)
+			r := newImportRun(database, nil, belegManagerDirectory, ImportOptions{Customer: tt.options})
+
+			// when
+			outcome, importErr := r.importIntoBelegManager(testLoggerEntry, invoiceFilePath, diAr.AnalyzeResult.Documents[0])
+
+			// then
+			require.NoError(t, importErr)
+			links, findLinksErr := findBmDocLinkByBelegAsTarget(testLoggerEntry, database, outcome.beleg)
+			require.NoError(t, findLinksErr)
+			customerCategory, findCategoryErr := findBmDocCategoryByName(testLoggerEntry, database, "MICROSOFT")
+			require.NoError(t, findCategoryErr)
+			if tt.options.Mode == CustomerModeCategory {
+				assert.NotNil(t, customerCategory)
+				assert.Nil(t, outcome.person)
+				assert.Len(t, links, 3, "asset and categories")
+				return
+			}
+			assert.Nil(t, customerCategory, "customer is no category")
+			if tt.wantPerson == "" {
+				assert.Nil(t, outcome.person)
+				assert.Len(t, links, 2, "asset and vendor category only")
+				return
+			}
+			require.NotNil(t, outcome.person)
+			assert.Equal(t, tt.wantPerson, outcome.person.Name)
+			assert.Len(t, links, 3)
+			assert.Contains(t, links, bmDocLink{ID: links[2].ID, SourceUUID: outcome.person.UUID, TargetUUID: outcome.beleg.UUID})
+		})
+	}
+}
+
+func Test_importIntoBelegManager_failedLinkRollsBack(t *testing.T) {
+	t.Parallel()
+
+	// given
+	testLoggerEntry := newDummyLogEntry(t)
+	belegManagerDirectory, openDirErr := os.Open(t.TempDir())
+	require.NoError(t, openDirErr)
+	t.Cleanup(func() {
+		require.NoError(t, belegManagerDirectory.Close())
+	})
+
+	database := openPlainDatabase(t, copyDatabaseFixtureIntoDirectory(t, t.TempDir()))
+	for range 2 {
+		_, insertErr := database.Exec("INSERT INTO BmDoc_Person (uuid, name, docType, deleteState) VALUES (?, 'Microsoft', 2, 0)", newBmDocUUID())
+		require.NoError(t, insertErr)
+	}
+	rowsBefore := countBmDocRows(t, database)
+	invoiceFilePath, diAr := getDiResultFixture(t)
+	r := newImportRun(database, nil, belegManagerDirectory, ImportOptions{Customer: CustomerOptions{Mode: CustomerModePerson}})
+
+	// when
+	outcome, importErr := r.importIntoBelegManager(testLoggerEntry, invoiceFilePath, diAr.AnalyzeResult.Documents[0])
+
+	// then
+	require.ErrorContains(t, importErr, "exists more than once")
+	assert.Nil(t, outcome)
+	assert.Equal(t, rowsBefore, countBmDocRows(t, database), "Beleg, asset and vendor category rolled back")
+	assert.NoFileExists(t, r.journal.path, "nothing journaled")
+}
+
+func Test_UndoRun_createdPerson(t *testing.T) {
+	t.Parallel()
+
+	// given
+	testLoggerEntry := newDummyLogEntry(t)
+	belegManagerDirectory, openDirErr := os.Open(t.TempDir())
+	require.NoError(t, openDirErr)
+	t.Cleanup(func() {
+		require.NoError(t, belegManagerDirectory.Close())
+	})
+
+	database := openPlainDatabase(t, copyDatabaseFixtureIntoDirectory(t, t.TempDir()))
+	countsBefore := countBmDocRows(t, database)
+	invoiceFilePath, diAr := getDiResultFixture(t)
+	r := newImportRun(database, nil, belegManagerDirectory, ImportOptions{Customer: CustomerOptions{Mode: CustomerModePerson, CreatePersons: true}})
+	outcome, importErr := r.importIntoBelegManager(testLoggerEntry, invoiceFilePath, diAr.AnalyzeResult.Documents[0])
+	require.NoError(t, importErr)
+	require.NotNil(t, outcome.person)
+
+	// when
+	undoErr := UndoRun(database, belegManagerDirectory, r.journal.runID, false)
+
+	// then
+	require.NoError(t, undoErr)
+	assert.Equal(t, countsBefore, countBmDocRows(t, database))
+}
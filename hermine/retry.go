@@ -0,0 +1,150 @@
+package hermine
+
+import (
+	"context"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// RetryPolicy configures retrying requests to Document Intelligence, which failed due to network errors, throttling
+// (429) or server errors (5xx). Other errors, e.g. 400, 401, 403 or 404, are not retried.
+type RetryPolicy struct {
+	// MaxAttempts limits the attempts per request, including the first one. Values below 1 mean a single attempt.
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry, doubled for each further retry.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between two attempts, unless the server demands more using "Retry-After".
+	MaxBackoff time.Duration
+	// Jitter randomizes delays by up to this fraction, e.g. 0.2 for +/- 20%.
+	Jitter float64
+}
+
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    5,
+		InitialBackoff: 1 * time.Second,
+		MaxBackoff:     30 * time.Second,
+		Jitter:         0.2,
+	}
+}
+
+type analysisStatsContextKey struct{}
+
+// analysisStats collects statistics about analyzing a single file.
+type analysisStats struct {
+	retries atomic.Int32
+}
+
+func withAnalysisStats(ctx context.Context) (context.Context, *analysisStats) {
+	stats := &analysisStats{}
+	return context.WithValue(ctx, analysisStatsContextKey{}, stats), stats
+}
+
+func countRetry(ctx context.Context) {
+	if stats, ok := ctx.Value(analysisStatsContextKey{}).(*analysisStats); ok {
+		stats.retries.Add(1)
+	}
+}
+
+// doWithRetries performs the request created by newRequest, retrying according to the policy. A response is returned
+// for each status code not worth retrying, the caller is responsible for checking it and closing its body.
+func doWithRetries(ctx context.Context, logger *log.Entry, hc *http.Client, policy RetryPolicy, newRequest func() (*http.Request, error)) (*http.Response, error) {
+	maxAttempts := max(policy.MaxAttempts, 1)
+	var lastErr error
+	for attempt := 1; ; attempt++ {
+		req, newRequestErr := newRequest()
+		if newRequestErr != nil {
+			return nil, newRequestErr
+		}
+
+		var retryAfter time.Duration
+		resp, respErr := hc.Do(req)
+		switch {
+		case respErr != nil && ctx.Err() != nil:
+			return nil, ctx.Err()
+		case respErr != nil:
+			lastErr = respErr
+		case isRetryableStatusCode(resp.StatusCode):
+			lastErr = fmt.Errorf("retryable status code %d", resp.StatusCode)
+			retryAfter = parseRetryAfter(resp.Header)
+			closeBody(logger, resp)
+		default:
+			return resp, nil
+		}
+
+		attemptLogger := logger.WithError(lastErr).WithField("attempt", attempt).WithField("max_attempts", maxAttempts)
+		if attempt >= maxAttempts {
+			attemptLogger.Warn("Request failed, giving up")
+			return nil, fmt.Errorf("giving up after %d attempt(s): %w", attempt, lastErr)
+		}
+
+		delay := max(retryAfter, policy.backoff(attempt))
+		attemptLogger.Debugf("Request failed, retrying in %s...", delay)
+		countRetry(ctx)
+		if sleepErr := sleepContext(ctx, delay); sleepErr != nil {
+			return nil, sleepErr
+		}
+	}
+}
+
+func isRetryableStatusCode(statusCode int) bool {
+	return statusCode == http.StatusRequestTimeout ||
+		statusCode == http.StatusTooManyRequests ||
+		statusCode >= http.StatusInternalServerError
+}
+
+// backoff returns the delay after the given failed attempt, growing exponentially, randomized by jitter.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.InitialBackoff
+	for i := 1; i < attempt && delay < p.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if p.MaxBackoff > 0 {
+		delay = min(delay, p.MaxBackoff)
+	}
+
+	if p.Jitter > 0 {
+		//nolint:gosec // Jitter does not need a cryptographically secure random number
+		factor := 1 + p.Jitter*(2*rand.Float64()-1)
+		delay = time.Duration(float64(delay) * factor)
+	}
+
+	return max(delay, 0)
+}
+
+// parseRetryAfter supports "retry-after-ms" as sent by Azure, and "Retry-After" as seconds or HTTP date.
+func parseRetryAfter(header http.Header) time.Duration {
+	if ms, err := strconv.Atoi(header.Get("retry-after-ms")); err == nil && ms > 0 {
+		return time.Duration(ms) * time.Millisecond
+	}
+
+	retryAfter := header.Get("Retry-After")
+	if retryAfter == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(retryAfter); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+	if date, err := http.ParseTime(retryAfter); err == nil {
+		return max(time.Until(date), 0)
+	}
+
+	return 0
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,57 @@
+package hermine
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func Test_RetryPolicy_backoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}
+
+	assert.Equal(t, 1*time.Second, policy.backoff(1))
+	assert.Equal(t, 2*time.Second, policy.backoff(2))
+	assert.Equal(t, 4*time.Second, policy.backoff(3))
+	assert.Equal(t, 5*time.Second, policy.backoff(4))
+	assert.Equal(t, 5*time.Second, policy.backoff(40))
+}
+
+func Test_RetryPolicy_backoffWithJitter(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: 10 * time.Second, MaxBackoff: 10 * time.Second, Jitter: 0.2}
+
+	for range 100 {
+		backoff := policy.backoff(1)
+		assert.GreaterOrEqual(t, backoff, 8*time.Second)
+		assert.LessOrEqual(t, backoff, 12*time.Second)
+	}
+}
+
+func Test_parseRetryAfter(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   http.Header
+		expected time.Duration
+	}{
+		{name: "Missing", header: http.Header{}, expected: 0},
+		{name: "Seconds", header: http.Header{"Retry-After": {"7"}}, expected: 7 * time.Second},
+		{name: "Milliseconds", header: http.Header{"Retry-After": {"7"}, "Retry-After-Ms": {"1500"}}, expected: 1500 * time.Millisecond},
+		{name: "Date in the past", header: http.Header{"Retry-After": {"Wed, 21 Oct 2015 07:28:00 GMT"}}, expected: 0},
+		{name: "Invalid", header: http.Header{"Retry-After": {"soon"}}, expected: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, parseRetryAfter(tt.header))
+		})
+	}
+}
+
+func Test_isRetryableStatusCode(t *testing.T) {
+	for _, statusCode := range []int{http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusServiceUnavailable} {
+		assert.True(t, isRetryableStatusCode(statusCode), statusCode)
+	}
+	for _, statusCode := range []int{http.StatusOK, http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound} {
+		assert.False(t, isRetryableStatusCode(statusCode), statusCode)
+	}
+}
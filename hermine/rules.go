@@ -0,0 +1,263 @@
+package hermine
+
+import (
+	"errors"
+	"fmt"
+	"github.com/bmatcuk/doublestar/v4"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// vatRateTolerance is the difference in percent up to which VAT rates are considered equal, e.g. 19 and 19.0001.
+const vatRateTolerance = 0.01
+
+// Rules assign existing categories, persons and Steuerfälle as well as labels to the Belege of the documents they
+// match, see LoadRules.
+type Rules struct {
+	Rules []Rule `yaml:"rules"`
+}
+
+// Rule applies its actions to documents matching all of its conditions. A rule without conditions matches all
+// documents.
+type Rule struct {
+	Name string        `yaml:"name"`
+	When RuleCondition `yaml:"when"`
+	Then RuleActions   `yaml:"then"`
+	// Stop skips the following rules, if the rule matches.
+	Stop bool `yaml:"stop"`
+}
+
+// RuleCondition lists the conditions of a rule, unset ones are ignored.
+type RuleCondition struct {
+	// Vendor is a regular expression matching the vendor, e.g. "(?i)^amazon".
+	Vendor string `yaml:"vendor"`
+	// Item is a regular expression matching the description of at least one item.
+	Item string `yaml:"item"`
+	// MinAmount and MaxAmount restrict the total, including both bounds.
+	MinAmount *float64 `yaml:"min-amount"`
+	MaxAmount *float64 `yaml:"max-amount"`
+	// VatRate is the VAT rate in percent, e.g. 7.
+	VatRate *float64 `yaml:"vat-rate"`
+	// Folder is a glob pattern matching the file, e.g. "**/Handwerker/**".
+	Folder string `yaml:"folder"`
+
+	vendorPattern, itemPattern *regexp.Regexp
+}
+
+// RuleActions name what is assigned to the Beleg. Categories, persons and Steuerfälle have to exist in BelegManager,
+// labels are created if required.
+type RuleActions struct {
+	Categories []string `yaml:"categories"`
+	Labels     []string `yaml:"labels"`
+	Person     string   `yaml:"person"`
+	Steuerfall string   `yaml:"steuerfall"`
+}
+
+// matchedRules are the merged actions of the rules matching a document.
+type matchedRules struct {
+	RuleActions
+	names []string
+}
+
+// LoadRules reads the rules from a YAML file, rejecting unknown keys.
+func LoadRules(rulesFilePath string) (*Rules, error) {
+	rulesFile, openErr := os.Open(rulesFilePath)
+	if openErr != nil {
+		return nil, openErr
+	}
+	defer func() {
+		_ = rulesFile.Close()
+	}()
+
+	decoder := yaml.NewDecoder(rulesFile)
+	decoder.KnownFields(true)
+	var rules Rules
+	if decodeErr := decoder.Decode(&rules); decodeErr != nil {
+		return nil, fmt.Errorf("invalid rules file %s: %w", rulesFilePath, decodeErr)
+	}
+
+	for i := range rules.Rules {
+		if validationErr := rules.Rules[i].compile(); validationErr != nil {
+			return nil, fmt.Errorf("invalid rule '%s' in %s: %w", rules.Rules[i].displayName(i), rulesFilePath, validationErr)
+		}
+	}
+
+	return &rules, nil
+}
+
+// compile validates the rule and compiles its regular expressions.
+func (r *Rule) compile() error {
+	actions := r.Then
+	if len(actions.Categories) == 0 && len(actions.Labels) == 0 && actions.Person == "" && actions.Steuerfall == "" {
+		return errors.New("rule without action")
+	}
+	if r.When.Folder != "" && !doublestar.ValidatePattern(filepath.ToSlash(r.When.Folder)) {
+		return fmt.Errorf("invalid folder glob pattern '%s'", r.When.Folder)
+	}
+	if r.When.MinAmount != nil && r.When.MaxAmount != nil && *r.When.MinAmount > *r.When.MaxAmount {
+		return errors.New("min-amount exceeds max-amount")
+	}
+
+	var compileErr error
+	if r.When.Vendor != "" {
+		if r.When.vendorPattern, compileErr = regexp.Compile(r.When.Vendor); compileErr != nil {
+			return fmt.Errorf("invalid vendor pattern: %w", compileErr)
+		}
+	}
+	if r.When.Item != "" {
+		if r.When.itemPattern, compileErr = regexp.Compile(r.When.Item); compileErr != nil {
+			return fmt.Errorf("invalid item pattern: %w", compileErr)
+		}
+	}
+
+	return nil
+}
+
+func (r *Rule) displayName(index int) string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return fmt.Sprintf("rule %d", index+1)
+}
+
+func (c *RuleCondition) matches(pathOfFileToImport string, analysedDocument diDocument) bool {
+	if c.Folder != "" {
+		if matched, _ := doublestar.Match(filepath.ToSlash(c.Folder), filepath.ToSlash(pathOfFileToImport)); !matched {
+			return false
+		}
+	}
+	if c.vendorPattern != nil && !c.vendorPattern.MatchString(analysedDocument.getVendor()) {
+		return false
+	}
+	if c.itemPattern != nil && !slices.ContainsFunc(analysedDocument.getItemDescriptions(), c.itemPattern.MatchString) {
+		return false
+	}
+
+	if c.MinAmount != nil || c.MaxAmount != nil {
+		gross := analysedDocument.getGross()
+		if gross == nil || (c.MinAmount != nil && *gross < *c.MinAmount) || (c.MaxAmount != nil && *gross > *c.MaxAmount) {
+			return false
+		}
+	}
+	if c.VatRate != nil {
+		vat := analysedDocument.getVat()
+		if vat == nil || math.Abs(*vat-*c.VatRate) > vatRateTolerance {
+			return false
+		}
+	}
+
+	return true
+}
+
+// match returns the merged actions of the rules matching the document. Categories and labels of all matching rules
+// are assigned, the person and Steuerfall of the first matching rule naming one.
+func (rs *Rules) match(logger *log.Entry, pathOfFileToImport string, analysedDocument diDocument) matchedRules {
+	var matched matchedRules
+	if rs == nil {
+		return matched
+	}
+
+	for i := range rs.Rules {
+		rule := &rs.Rules[i]
+		if !rule.When.matches(pathOfFileToImport, analysedDocument) {
+			continue
+		}
+
+		name := rule.displayName(i)
+		logger.WithField("rule", name).Debug("Rule matches")
+		matched.names = append(matched.names, name)
+		matched.Categories = appendMissing(matched.Categories, rule.Then.Categories...)
+		matched.Labels = appendMissing(matched.Labels, rule.Then.Labels...)
+		if matched.Person == "" {
+			matched.Person = rule.Then.Person
+		}
+		if matched.Steuerfall == "" {
+			matched.Steuerfall = rule.Then.Steuerfall
+		}
+		if rule.Stop {
+			break
+		}
+	}
+
+	return matched
+}
+
+func appendMissing(values []string, additionalValues ...string) []string {
+	for _, value := range additionalValues {
+		if !slices.Contains(values, value) {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// linkRuleCategoriesToBeleg links the categories assigned by rules, which have to exist. It returns the number of
+// categories linked.
+func linkRuleCategoriesToBeleg(logger *log.Entry, tx *importTx, categoryNames []string, beleg *bmDocBeleg) (int, error) {
+	linked := 0
+	for _, categoryName := range categoryNames {
+		categoryLogger := logger.WithField("category", categoryName)
+		category, findErr := findBmDocCategoryByName(categoryLogger, tx, categoryName)
+		if findErr != nil {
+			return linked, findErr
+		}
+		if category == nil {
+			categoryLogger.Warn("Category assigned by rule not found, create it in BelegManager")
+			continue
+		}
+
+		if createLinkErr := createIgnoreBmDocLink(categoryLogger, tx, category.UUID, beleg.UUID); createLinkErr != nil {
+			return linked, createLinkErr
+		}
+		linked++
+	}
+
+	return linked, nil
+}
+
+// linkRulePersonToBeleg links the person assigned by rules, which has to exist.
+func linkRulePersonToBeleg(logger *log.Entry, tx *importTx, personName string, beleg *bmDocBeleg) (*bmDocPerson, error) {
+	personLogger := logger.WithField("person_name", personName)
+	person, findErr := findBmDocPersonByName(personLogger, tx, personName)
+	if findErr != nil {
+		return nil, findErr
+	}
+	if person == nil {
+		personLogger.Warn("Person assigned by rule not found, create it in BelegManager")
+		return nil, nil
+	}
+
+	if createLinkErr := createIgnoreBmDocLink(personLogger, tx, person.UUID, beleg.UUID); createLinkErr != nil {
+		return nil, createLinkErr
+	}
+	return person, nil
+}
+
+// linkRuleSteuerfallToBeleg links the Steuerfall assigned by rules, which has to exist.
+func linkRuleSteuerfallToBeleg(logger *log.Entry, tx *importTx, steuerfallName string, beleg *bmDocBeleg) (*bmDocSteuerfall, error) {
+	steuerfallLogger := logger.WithField("steuerfall", steuerfallName)
+	steuerfall, findErr := findBmDocSteuerfallByName(steuerfallLogger, tx, steuerfallName)
+	if findErr != nil {
+		return nil, findErr
+	}
+	if steuerfall == nil {
+		steuerfallLogger.Warn("Steuerfall assigned by rule not found, create it in BelegManager")
+		return nil, nil
+	}
+
+	if createLinkErr := createIgnoreBmDocLink(steuerfallLogger, tx, steuerfall.UUID, beleg.UUID); createLinkErr != nil {
+		return nil, createLinkErr
+	}
+	return steuerfall, nil
+}
+
+// String returns the names of the matched rules, separated by comma.
+func (m matchedRules) String() string {
+	return strings.Join(m.names, ", ")
+}
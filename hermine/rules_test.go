@@ -0,0 +1,214 @@
+package hermine
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_LoadRules(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		content     string
+		wantErrText string
+	}{
+		{name: "valid", content: "rules:\n  - name: Handwerker\n    when:\n      vendor: '(?i)maler'\n      min-amount: 50\n    then:\n      categories: [Handwerkerleistungen]\n"},
+		{name: "unknown key", content: "rules:\n  - name: Handwerker\n    when:\n      vendors: 'maler'\n    then:\n      labels: [Handwerk]\n", wantErrText: "field vendors not found"},
+		{name: "invalid pattern", content: "rules:\n  - when:\n      item: '('\n    then:\n      labels: [Handwerk]\n", wantErrText: "invalid rule 'rule 1'"},
+		{name: "invalid amount range", content: "rules:\n  - when:\n      min-amount: 50\n      max-amount: 10\n    then:\n      labels: [Handwerk]\n", wantErrText: "min-amount exceeds max-amount"},
+		{name: "without action", content: "rules:\n  - name: Nothing\n    when:\n      vendor: 'maler'\n", wantErrText: "rule without action"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			// given
+			rulesFilePath := filepath.Join(t.TempDir(), "rules.yml")
+			require.NoError(t, os.WriteFile(rulesFilePath, []byte(tt.content), 0o600))
+
+			// when
+			rules, loadErr := LoadRules(rulesFilePath)
+
+			// then
+			if tt.wantErrText != "" {
+				assert.ErrorContains(t, loadErr, tt.wantErrText)
+				return
+			}
+			require.NoError(t, loadErr)
+			require.Len(t, rules.Rules, 1)
+			assert.NotNil(t, rules.Rules[0].When.vendorPattern)
+		})
+	}
+}
+
+func Test_Rules_match(t *testing.T) {
+	t.Parallel()
+
+	amount := func(a float64) *float64 { return &a }
+	_, diAr := getDiResultFixture(t)
+	invoice := diAr.AnalyzeResult.Documents[0]
+
+	tests := []struct {
+		name      string
+		condition RuleCondition
+		want      bool
+	}{
+		{name: "no condition", want: true},
+		{name: "vendor", condition: RuleCondition{Vendor: "(?i)^contoso$"}, want: true},
+		{name: "other vendor", condition: RuleCondition{Vendor: "(?i)maler"}},
+		{name: "item", condition: RuleCondition{Item: "PROMOTION VIDEO"}, want: true},
+		{name: "other item", condition: RuleCondition{Item: "Arbeitslohn"}},
+		{name: "amount range", condition: RuleCondition{MinAmount: amount(100000), MaxAmount: amount(118368)}, want: true},
+		{name: "amount too low", condition: RuleCondition{MinAmount: amount(200000)}},
+		{name: "amount too high", condition: RuleCondition{MaxAmount: amount(100)}},
+		{name: "VAT rate", condition: RuleCondition{VatRate: amount(20)}, want: true},
+		{name: "other VAT rate", condition: RuleCondition{VatRate: amount(7)}},
+		{name: "folder", condition: RuleCondition{Folder: "**/Rechnungen/**"}, want: true},
+		{name: "other folder", condition: RuleCondition{Folder: "**/Kassenbons/**"}},
+		{name: "all", condition: RuleCondition{Vendor: "CONTOSO", Item: "VIDEO", VatRate: amount(20), Folder: "**/*.pdf"}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			// given
+			rule := Rule{When: tt.condition, Then: RuleActions{Labels: []string{"Matched"}}}
+			require.NoError(t, rule.compile())
+			rules := &Rules{Rules: []Rule{rule}}
+
+			// when
+			matched := rules.match(newDummyLogEntry(t), "/import/Rechnungen/invoice.pdf", invoice)
+
+			// then
+			if tt.want {
+				assert.Equal(t, []string{"Matched"}, matched.Labels)
+				assert.Equal(t, "rule 1", matched.String())
+			} else {
+				assert.Empty(t, matched.Labels)
+				assert.Empty(t, matched.String())
+			}
+		})
+	}
+}
+
+func Test_Rules_match_merged(t *testing.T) {
+	t.Parallel()
+
+	// given
+	rules := &Rules{Rules: []Rule{
+		{Name: "Video", When: RuleCondition{Item: "VIDEO"}, Then: RuleActions{Categories: []string{"Werbung"}, Person: "Max Mustermann"}},
+		{Name: "Maler", When: RuleCondition{Vendor: "Maler"}, Then: RuleActions{Categories: []string{"Handwerkerleistungen"}}},
+		{Name: "Contoso", When: RuleCondition{Vendor: "CONTOSO"}, Then: RuleActions{Categories: []string{"Werbung", "Fachliteratur"}, Person: "Erika Musterfrau", Steuerfall: "2023 ESt"}, Stop: true},
+		{Name: "All", Then: RuleActions{Labels: []string{"Stopped before"}}},
+	}}
+	for i := range rules.Rules {
+		require.NoError(t, rules.Rules[i].compile())
+	}
+	_, diAr := getDiResultFixture(t)
+
+	// when
+	matched := rules.match(newDummyLogEntry(t), "/import/invoice.pdf", diAr.AnalyzeResult.Documents[0])
+
+	// then
+	assert.Equal(t, "Video, Contoso", matched.String())
+	assert.Equal(t, []string{"Werbung", "Fachliteratur"}, matched.Categories)
+	assert.Equal(t, "Max Mustermann", matched.Person, "first person applies")
+	assert.Equal(t, "2023 ESt", matched.Steuerfall)
+	assert.Empty(t, matched.Labels)
+}
+
+func Test_importIntoBelegManager_rules(t *testing.T) {
+	t.Parallel()
+
+	// given
+	testLoggerEntry := newDummyLogEntry(t)
+	belegManagerDirectory, openDirErr := os.Open(t.TempDir())
+	require.NoError(t, openDirErr)
+	t.Cleanup(func() {
+		require.NoError(t, belegManagerDirectory.Close())
+	})
+
+	database := openPlainDatabase(t, copyDatabaseFixtureIntoDirectory(t, t.TempDir()))
+	for _, insertQuery := range []string{
+		"INSERT INTO BmDoc_Kategorie (uuid, name, docType, deleteState) VALUES (?, 'Werbung', 1, 0)",
+		"INSERT INTO BmDoc_Person (uuid, name, docType, deleteState) VALUES (?, 'Max Mustermann', 2, 0)",
+		"INSERT INTO BmDoc_Steuerfall (uuid, name, deleteState, steuerart, steuerjahr) VALUES (?, 'ESt 2023', 0, 'ESt', '2023')",
+		"INSERT INTO BmDoc_Steuerfall (uuid, name, deleteState, steuerart, steuerjahr) VALUES (?, 'USt 2023', 0, 'USt', '2023')",
+	} {
+		_, insertErr := database.Exec(insertQuery, newBmDocUUID())
+		require.NoError(t, insertErr)
+	}
+	rules := &Rules{Rules: []Rule{{
+		Name: "Contoso",
+		When: RuleCondition{Vendor: "CONTOSO"},
+		Then: RuleActions{Categories: []string{"Werbung", "Missing"}, Labels: []string{"Werbekosten"}, Person: "Max Mustermann", Steuerfall: "USt 2023"},
+	}}}
+	require.NoError(t, rules.Rules[0].compile())
+	invoiceFilePath, diAr := getDiResultFixture(t)
+	r := newImportRun(database, nil, belegManagerDirectory, ImportOptions{Rules: rules})
+
+	// when
+	outcome, importErr := r.importIntoBelegManager(testLoggerEntry, invoiceFilePath, diAr.AnalyzeResult.Documents[0])
+
+	// then
+	require.NoError(t, importErr)
+	assert.Equal(t, "Contoso", outcome.rules.String())
+	require.NotNil(t, outcome.person)
+	assert.Equal(t, "Max Mustermann", outcome.person.Name)
+	require.NotNil(t, outcome.steuerfall)
+	assert.Equal(t, "USt 2023", outcome.steuerfall.Name)
+	assert.Equal(t, "Werbekosten", labelsString(outcome.labels))
+
+	werbung, findCategoryErr := findBmDocCategoryByName(testLoggerEntry, database, "Werbung")
+	require.NoError(t, findCategoryErr)
+	vendorCategory, findVendorCategoryErr := findBmDocCategoryByName(testLoggerEntry, database, "CONTOSO")
+	require.NoError(t, findVendorCategoryErr)
+	assert.Nil(t, vendorCategory, "rule categories replace the vendor category")
+	missingCategory, findMissingCategoryErr := findBmDocCategoryByName(testLoggerEntry, database, "Missing")
+	require.NoError(t, findMissingCategoryErr)
+	assert.Nil(t, missingCategory, "rules assign existing categories only")
+
+	links, findLinksErr := findBmDocLinkByBelegAsTarget(testLoggerEntry, database, outcome.beleg)
+	require.NoError(t, findLinksErr)
+	assert.Len(t, links, 5, "asset, category, person, Steuerfall and label")
+	for _, sourceUUID := range []string{werbung.UUID, outcome.person.UUID, outcome.steuerfall.UUID, outcome.labels[0].UUID} {
+		assert.True(t, containsLink(links, sourceUUID, outcome.beleg.UUID), sourceUUID)
+	}
+}
+
+func Test_importIntoBelegManager_rulesWithMissingCategories(t *testing.T) {
+	t.Parallel()
+
+	// given
+	testLoggerEntry := newDummyLogEntry(t)
+	belegManagerDirectory, openDirErr := os.Open(t.TempDir())
+	require.NoError(t, openDirErr)
+	t.Cleanup(func() {
+		require.NoError(t, belegManagerDirectory.Close())
+	})
+
+	database := openPlainDatabase(t, copyDatabaseFixtureIntoDirectory(t, t.TempDir()))
+	rules := &Rules{Rules: []Rule{{Name: "Contoso", Then: RuleActions{Categories: []string{"Missing"}}}}}
+	require.NoError(t, rules.Rules[0].compile())
+	invoiceFilePath, diAr := getDiResultFixture(t)
+	r := newImportRun(database, nil, belegManagerDirectory, ImportOptions{Rules: rules})
+
+	// when
+	outcome, importErr := r.importIntoBelegManager(testLoggerEntry, invoiceFilePath, diAr.AnalyzeResult.Documents[0])
+
+	// then
+	require.NoError(t, importErr)
+	assert.Equal(t, "Contoso", outcome.rules.String())
+	require.NotNil(t, outcome.vendor)
+	vendorCategory, findVendorCategoryErr := findBmDocCategoryByName(testLoggerEntry, database, "CONTOSO")
+	require.NoError(t, findVendorCategoryErr)
+	require.NotNil(t, vendorCategory, "field categories are kept, as no rule category exists")
+
+	links, findLinksErr := findBmDocLinkByBelegAsTarget(testLoggerEntry, database, outcome.beleg)
+	require.NoError(t, findLinksErr)
+	assert.True(t, containsLink(links, vendorCategory.UUID, outcome.beleg.UUID))
+}
@@ -0,0 +1,144 @@
+package hermine
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+)
+
+const (
+	runJournalFilePrefix = "_import-journal-"
+	runJournalFileEnding = ".jsonl"
+	// undoneRunJournalFileEnding is appended to the journal of a run once undone, so it is not undone twice.
+	undoneRunJournalFileEnding = ".undone"
+)
+
+// runIDPattern matches run IDs, i.e. the start time of a run, suffixed if several runs started at the same second.
+var runIDPattern = regexp.MustCompile(`^\d{14}(-\d+)?$`)
+
+// ErrRunAlreadyUndone is returned when undoing a run, whose journal has been undone before.
+var ErrRunAlreadyUndone = errors.New("run has already been undone")
+
+// runJournalEntry holds the committed changes of importing a single document.
+type runJournalEntry struct {
+	PathOfFileToImport string         `json:"file"`
+	Changes            []importChange `json:"changes"`
+}
+
+// runJournal records the changes of a run in a JSON Lines file in the BelegManager directory, so the run can be
+// undone. Entries are appended once their transaction has been committed, so the journal survives aborted runs.
+type runJournal struct {
+	runID string
+	path  string
+
+	mu sync.Mutex
+}
+
+func newRunJournal(belegManagerDirectory string) *runJournal {
+	startTime := time.Now().Format(flatDateTime)
+	runID := startTime
+	for i := 2; runJournalExists(belegManagerDirectory, runID); i++ {
+		runID = fmt.Sprintf("%s-%d", startTime, i)
+	}
+
+	return &runJournal{runID: runID, path: RunJournalFilePath(belegManagerDirectory, runID)}
+}
+
+// RunJournalFilePath returns the path of the journal of the run with the ID.
+func RunJournalFilePath(belegManagerDirectory, runID string) string {
+	return filepath.Join(belegManagerDirectory, runJournalFilePrefix+runID+runJournalFileEnding)
+}
+
+func runJournalExists(belegManagerDirectory, runID string) bool {
+	journalPath := RunJournalFilePath(belegManagerDirectory, runID)
+	for _, path := range []string{journalPath, journalPath + undoneRunJournalFileEnding} {
+		if _, statErr := os.Stat(path); statErr == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// record appends the changes of a document to the journal, if any. A nil journal records nothing, e.g. in a dry run.
+func (j *runJournal) record(logger *log.Entry, pathOfFileToImport string, changes []importChange) {
+	if j == nil || len(changes) == 0 {
+		return
+	}
+
+	entryAsJSON, marshalErr := json.Marshal(runJournalEntry{PathOfFileToImport: pathOfFileToImport, Changes: changes})
+	if marshalErr != nil {
+		logger.WithError(marshalErr).Warn("Failed to serialize run journal entry, the changes cannot be undone")
+		return
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	journalFile, openErr := os.OpenFile(j.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if openErr != nil {
+		logger.WithError(openErr).Warnf("Failed to open run journal %s, the changes cannot be undone", j.path)
+		return
+	}
+	defer func() {
+		if closeErr := journalFile.Close(); closeErr != nil {
+			logger.WithError(closeErr).Debugf("Failed to close run journal %s", j.path)
+		}
+	}()
+
+	if _, writeErr := journalFile.Write(append(entryAsJSON, '\n')); writeErr != nil {
+		logger.WithError(writeErr).Warnf("Failed to write run journal %s, the changes cannot be undone", j.path)
+		return
+	}
+	if syncErr := journalFile.Sync(); syncErr != nil {
+		logger.WithError(syncErr).Warnf("Failed to sync run journal %s", j.path)
+	}
+}
+
+// logUndoHint tells how to undo the run, if it changed anything.
+func (j *runJournal) logUndoHint() {
+	if j == nil {
+		return
+	}
+	if _, statErr := os.Stat(j.path); statErr != nil {
+		return
+	}
+
+	log.WithField("run_id", j.runID).Infof("Wrote run journal %s, undo the run using 'undo %s'", j.path, j.runID)
+}
+
+func readRunJournal(path string) ([]runJournalEntry, error) {
+	journalFile, openErr := os.Open(path)
+	if openErr != nil {
+		return nil, openErr
+	}
+	defer func() {
+		if closeErr := journalFile.Close(); closeErr != nil {
+			log.WithError(closeErr).Debugf("Failed to close run journal %s", path)
+		}
+	}()
+
+	entries := make([]runJournalEntry, 0)
+	scanner := bufio.NewScanner(journalFile)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var entry runJournalEntry
+		if unmarshalErr := json.Unmarshal(scanner.Bytes(), &entry); unmarshalErr != nil {
+			return nil, fmt.Errorf("invalid line %d of run journal %s: %w", lineNumber, path, unmarshalErr)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, scanner.Err()
+}
@@ -0,0 +1,68 @@
+package hermine
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+)
+
+func Test_runJournal_record(t *testing.T) {
+	t.Parallel()
+
+	// given
+	testLogger, _ := newDebuggingNullLogger(t)
+	testLoggerEntry := testLogger.WithField("test", t.Name())
+	journal := newRunJournal(t.TempDir())
+	amount := 12.5
+
+	// when
+	journal.record(testLoggerEntry, "a.pdf", []importChange{
+		{Operation: importChangeCopy, SourcePath: "a.pdf", TargetPath: "data/a.pdf"},
+		{Operation: importChangeInsert, Table: "BmDoc_Beleg", ID: 7, UUID: "{u}", Values: map[string]any{"amount": &amount}},
+	})
+	journal.record(testLoggerEntry, "b.pdf", nil)
+	journal.record(testLoggerEntry, "c.pdf", []importChange{
+		{Operation: importChangeUpdate, Table: "BmDoc_Beleg", ID: 3, Previous: map[string]any{"name": "before"}},
+	})
+
+	// then
+	entries, readErr := readRunJournal(journal.path)
+	require.NoError(t, readErr)
+	require.Len(t, entries, 2, "files without changes are not journaled")
+	assert.Equal(t, "a.pdf", entries[0].PathOfFileToImport)
+	require.Len(t, entries[0].Changes, 2)
+	assert.Equal(t, "data/a.pdf", entries[0].Changes[0].TargetPath)
+	assert.EqualValues(t, 7, entries[0].Changes[1].ID)
+	assert.InDelta(t, 12.5, entries[0].Changes[1].Values["amount"], 0.001)
+	assert.Equal(t, "before", entries[1].Changes[0].Previous["name"])
+}
+
+func Test_runJournal_record_nilJournal(t *testing.T) {
+	t.Parallel()
+
+	testLogger, _ := newDebuggingNullLogger(t)
+	var journal *runJournal
+
+	assert.NotPanics(t, func() {
+		journal.record(testLogger.WithField("test", t.Name()), "a.pdf", []importChange{{Operation: importChangeCopy}})
+		journal.logUndoHint()
+	})
+}
+
+func Test_newRunJournal_uniqueRunID(t *testing.T) {
+	t.Parallel()
+
+	// given
+	belegManagerDirectory := t.TempDir()
+	first := newRunJournal(belegManagerDirectory)
+	require.NoError(t, os.WriteFile(first.path, []byte("{}\n"), 0o600))
+
+	// when
+	second := newRunJournal(belegManagerDirectory)
+
+	// then
+	assert.NotEqual(t, first.runID, second.runID)
+	assert.Regexp(t, runIDPattern, first.runID)
+	assert.Regexp(t, runIDPattern, second.runID)
+}
@@ -0,0 +1,167 @@
+package hermine
+
+import (
+	"errors"
+	"fmt"
+	"github.com/bmatcuk/doublestar/v4"
+	log "github.com/sirupsen/logrus"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	selectActiveBmDocSteuerfallByYearQuery = "SELECT " + bmDocSteuerfallColumns + " FROM BmDoc_Steuerfall WHERE TRIM(steuerjahr) = ? AND (deleteState IS NULL OR deleteState = 0) ORDER BY id"
+	selectActiveBmDocSteuerfallByNameQuery = "SELECT " + bmDocSteuerfallColumns + " FROM BmDoc_Steuerfall WHERE TRIM(name) = ? AND (deleteState IS NULL OR deleteState = 0) ORDER BY id"
+)
+
+// SteuerfallOptions decide which Steuerfall a new Beleg is linked to: the Steuerfall of the year of its Beleg date,
+// unless a rule shifts the year.
+type SteuerfallOptions struct {
+	// Steuerart restricts the Steuerfälle to one kind of tax, if there are several per year, e.g. of spouses.
+	Steuerart string `mapstructure:"steuerart"`
+	// YearRules determine the tax year of documents belonging to another year, the first matching rule applies.
+	YearRules []SteuerjahrRule `mapstructure:"year-rules"`
+}
+
+// SteuerjahrRule determines the tax year of the documents it matches, e.g. of December invoices paid in January.
+// A rule without Glob and Months matches all documents.
+type SteuerjahrRule struct {
+	// Glob restricts the rule to files matching the glob pattern, e.g. "**/Nebenkosten/**".
+	Glob string `mapstructure:"glob"`
+	// Months restricts the rule to documents whose Beleg date is in one of the months, 1 to 12.
+	Months []int `mapstructure:"months"`
+	// DateField names the date field of the analysis the year is taken from instead of the Beleg date, e.g.
+	// "DueDate". Documents lacking the field use the Beleg date.
+	DateField string `mapstructure:"date-field"`
+	// YearOffset is added to the year, e.g. 1 to assign December invoices to the next year.
+	YearOffset int `mapstructure:"year-offset"`
+}
+
+func (r SteuerjahrRule) Validate() error {
+	if r.Glob != "" && !doublestar.ValidatePattern(filepath.ToSlash(r.Glob)) {
+		return fmt.Errorf("Steuerjahr rule contains an invalid glob pattern '%s'", r.Glob)
+	}
+	for _, month := range r.Months {
+		if month < 1 || month > 12 {
+			return fmt.Errorf("Steuerjahr rule contains an invalid month %d", month)
+		}
+	}
+	if r.DateField == "" && r.YearOffset == 0 {
+		return errors.New("Steuerjahr rule neither has a date field nor a year offset")
+	}
+
+	return nil
+}
+
+func (r SteuerjahrRule) matches(pathOfFileToImport string, belegDate time.Time) bool {
+	if r.Glob != "" {
+		if matched, _ := doublestar.Match(filepath.ToSlash(r.Glob), filepath.ToSlash(pathOfFileToImport)); !matched {
+			return false
+		}
+	}
+
+	return len(r.Months) == 0 || slices.Contains(r.Months, int(belegDate.Month()))
+}
+
+// steuerjahr returns the tax year of the document, or 0 if it has no Beleg date.
+func (o SteuerfallOptions) steuerjahr(logger *log.Entry, pathOfFileToImport string, analysedDocument diDocument) int {
+	belegDate, hasBelegDate := parseDocumentDate(analysedDocument.getBelegDate())
+	if !hasBelegDate {
+		return 0
+	}
+
+	for i, rule := range o.YearRules {
+		if !rule.matches(pathOfFileToImport, belegDate) {
+			continue
+		}
+
+		date := belegDate
+		if rule.DateField != "" {
+			if fieldDate, hasFieldDate := parseDocumentDate(analysedDocument.Fields[rule.DateField].ValueDate); hasFieldDate {
+				date = fieldDate
+			}
+		}
+		logger.Debugf("Steuerjahr rule %d applies", i+1)
+		return date.Year() + rule.YearOffset
+	}
+
+	return belegDate.Year()
+}
+
+func parseDocumentDate(date *string) (time.Time, bool) {
+	if date == nil {
+		return time.Time{}, false
+	}
+
+	parsed, parseErr := time.Parse(time.DateOnly, *date)
+	return parsed, parseErr == nil
+}
+
+// linkSteuerfallToBeleg links the Steuerfall of the document's tax year to the new Beleg. If there is none, or it is
+// ambiguous, it warns only, as the Beleg can be assigned in BelegManager later.
+func linkSteuerfallToBeleg(logger *log.Entry, tx *importTx, options SteuerfallOptions, pathOfFileToImport string, analysedDocument diDocument, beleg *bmDocBeleg) (*bmDocSteuerfall, error) {
+	steuerjahr := options.steuerjahr(logger, pathOfFileToImport, analysedDocument)
+	if steuerjahr == 0 {
+		logger.Warn("No Beleg date, not linking any Steuerfall")
+		return nil, nil
+	}
+	steuerjahrLogger := logger.WithField("steuerjahr", steuerjahr)
+
+	steuerfaelle := make([]*bmDocSteuerfall, 0)
+	if err := tx.Select(&steuerfaelle, selectActiveBmDocSteuerfallByYearQuery, strconv.Itoa(steuerjahr)); err != nil {
+		steuerjahrLogger.WithError(err).Warn("Error when searching BmDoc_Steuerfall")
+		return nil, err
+	}
+	if options.Steuerart != "" {
+		steuerfaelle = slices.DeleteFunc(steuerfaelle, func(s *bmDocSteuerfall) bool {
+			return s.Steuerart == nil || !strings.EqualFold(strings.TrimSpace(*s.Steuerart), options.Steuerart)
+		})
+	}
+
+	if len(steuerfaelle) == 0 {
+		steuerjahrLogger.Warn("No Steuerfall found for the tax year, create it in BelegManager and assign the Beleg")
+		return nil, nil
+	}
+	if len(steuerfaelle) > 1 {
+		steuerjahrLogger.Warnf("%d Steuerfälle found for the tax year, configure the Steuerart to link one", len(steuerfaelle))
+		return nil, nil
+	}
+
+	steuerfall := steuerfaelle[0]
+	if createLinkErr := createIgnoreBmDocLink(logger, tx, steuerfall.UUID, beleg.UUID); createLinkErr != nil {
+		return nil, createLinkErr
+	}
+	steuerjahrLogger.WithField("steuerfall_id", steuerfall.ID).Debug("Beleg linked to Steuerfall")
+
+	return steuerfall, nil
+}
+
+// findBmDocSteuerfallByName returns the active Steuerfall with the name, or nil.
+func findBmDocSteuerfallByName(logger *log.Entry, q sqlxSelecter, name string) (*bmDocSteuerfall, error) {
+	steuerfaelle := make([]*bmDocSteuerfall, 0)
+	if err := q.Select(&steuerfaelle, selectActiveBmDocSteuerfallByNameQuery, strings.TrimSpace(name)); err != nil {
+		logger.WithError(err).Warn("Error when searching BmDoc_Steuerfall")
+		return nil, err
+	}
+	if len(steuerfaelle) > 1 {
+		err := fmt.Errorf("BmDoc_Steuerfall %s exists more than once, check in BelegManager", name)
+		logger.Warn(err)
+		return nil, err
+	}
+
+	if len(steuerfaelle) == 1 {
+		return steuerfaelle[0], nil
+	}
+	return nil, nil
+}
+
+func (s *bmDocSteuerfall) String() string {
+	if s == nil {
+		return ""
+	}
+
+	return fmt.Sprintf("%d: %s", s.ID, s.Name)
+}
@@ -0,0 +1,118 @@
+package hermine
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"strings"
+	"testing"
+)
+
+func Test_SteuerfallOptions_steuerjahr(t *testing.T) {
+	t.Parallel()
+
+	decemberInvoice := func(invoiceDate string) diDocument {
+		dueDate := "2024-01-10"
+		return diDocument{DocType: documentTypeInvoice, Fields: map[string]diDocumentField{
+			"InvoiceDate": {ValueDate: &invoiceDate},
+			"DueDate":     {ValueDate: &dueDate},
+		}}
+	}
+
+	tests := []struct {
+		name     string
+		rules    []SteuerjahrRule
+		document diDocument
+		want     int
+	}{
+		{name: "year of Beleg date", document: decemberInvoice("2023-12-20"), want: 2023},
+		{name: "no Beleg date", document: diDocument{DocType: documentTypeInvoice}, want: 0},
+		{name: "month shifted", rules: []SteuerjahrRule{{Months: []int{12}, YearOffset: 1}}, document: decemberInvoice("2023-12-20"), want: 2024},
+		{name: "month not matching", rules: []SteuerjahrRule{{Months: []int{12}, YearOffset: 1}}, document: decemberInvoice("2023-11-20"), want: 2023},
+		{name: "date field", rules: []SteuerjahrRule{{DateField: "DueDate"}}, document: decemberInvoice("2023-12-20"), want: 2024},
+		{name: "date field missing", rules: []SteuerjahrRule{{DateField: "ServiceEndDate"}}, document: decemberInvoice("2023-12-20"), want: 2023},
+		{name: "glob matching", rules: []SteuerjahrRule{{Glob: "**/Nebenkosten/**", YearOffset: -1}}, document: decemberInvoice("2023-12-20"), want: 2022},
+		{name: "first rule applies", rules: []SteuerjahrRule{{Glob: "**/Strom/**", YearOffset: 5}, {YearOffset: 1}, {YearOffset: 2}}, document: decemberInvoice("2023-12-20"), want: 2024},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			// given
+			options := SteuerfallOptions{YearRules: tt.rules}
+
+			// when
+			steuerjahr := options.steuerjahr(newDummyLogEntry(t), "/import/Nebenkosten/invoice.pdf", tt.document)
+
+			// then
+			assert.Equal(t, tt.want, steuerjahr)
+		})
+	}
+}
+
+func Test_SteuerjahrRule_Validate(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, SteuerjahrRule{Months: []int{1, 12}, YearOffset: -1}.Validate())
+	assert.NoError(t, SteuerjahrRule{Glob: "**/Nebenkosten/**", DateField: "DueDate"}.Validate())
+	assert.Error(t, SteuerjahrRule{Months: []int{13}, YearOffset: 1}.Validate())
+	assert.Error(t, SteuerjahrRule{Glob: "[", YearOffset: 1}.Validate())
+	assert.Error(t, SteuerjahrRule{Months: []int{12}}.Validate(), "changes nothing")
+}
+
+func Test_importIntoBelegManager_steuerfall(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name           string
+		steuerfaelle   []string
+		options        SteuerfallOptions
+		wantSteuerfall string
+	}{
+		{name: "matching year", steuerfaelle: []string{"2022 ESt", "2023 ESt"}, wantSteuerfall: "2023 ESt"},
+		{name: "none found", steuerfaelle: []string{"2022 ESt"}},
+		{name: "ambiguous", steuerfaelle: []string{"2023 ESt", "2023 USt"}},
+		{name: "ambiguous, but Steuerart configured", steuerfaelle: []string{"2023 ESt", "2023 USt"}, options: SteuerfallOptions{Steuerart: "ust"}, wantSteuerfall: "2023 USt"},
+		{name: "year shifted", steuerfaelle: []string{"2023 ESt", "2024 ESt"}, options: SteuerfallOptions{YearRules: []SteuerjahrRule{{YearOffset: 1}}}, wantSteuerfall: "2024 ESt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			// given
+			testLoggerEntry := newDummyLogEntry(t)
+			belegManagerDirectory, openDirErr := os.Open(t.TempDir())
+			require.NoError(t, openDirErr)
+			t.Cleanup(func() {
+				require.NoError(t, belegManagerDirectory.Close())
+			})
+
+			database := openPlainDatabase(t, copyDatabaseFixtureIntoDirectory(t, t.TempDir()))
+			for _, steuerfall := range tt.steuerfaelle {
+				steuerjahr, steuerart, _ := strings.Cut(steuerfall, " ")
+				_, insertErr := database.Exec("INSERT INTO BmDoc_Steuerfall (uuid, name, deleteState, steuerart, steuerjahr) VALUES (?, ?, 0, ?, ?)",
+					newBmDocUUID(), steuerfall, steuerart, steuerjahr)
+				require.NoError(t, insertErr)
+			}
+			invoiceFilePath, diAr := getDiResultFixture(t)
+			r := newImportRun(database, nil, belegManagerDirectory, ImportOptions{Steuerfall: tt.options})
+
+			// when
+			outcome, importErr := r.importIntoBelegManager(testLoggerEntry, invoiceFilePath, diAr.AnalyzeResult.Documents[0])
+
+			// then
+			require.NoError(t, importErr)
+			links, findLinksErr := findBmDocLinkByBelegAsTarget(testLoggerEntry, database, outcome.beleg)
+			require.NoError(t, findLinksErr)
+			if tt.wantSteuerfall == "" {
+				assert.Nil(t, outcome.steuerfall)
+				assert.Len(t, links, 3, "asset and categories only")
+				return
+			}
+			require.NotNil(t, outcome.steuerfall)
+			assert.Equal(t, tt.wantSteuerfall, outcome.steuerfall.Name)
+			assert.Len(t, links, 4)
+			assert.Contains(t, links, bmDocLink{ID: links[3].ID, SourceUUID: outcome.steuerfall.UUID, TargetUUID: outcome.beleg.UUID})
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package hermine
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"slices"
+)
+
+const (
+	deleteBmDocBelegQuery                = "DELETE FROM BmDoc_Beleg WHERE id = ? AND uuid = ?"
+	deleteBmDocAssetQuery                = "DELETE FROM BmDoc_Asset WHERE id = ? AND uuid = ?"
+	deleteBmDocCategoryQuery             = "DELETE FROM BmDoc_Kategorie WHERE id = ? AND uuid = ?"
+	deleteBmDocPersonQuery               = "DELETE FROM BmDoc_Person WHERE id = ? AND uuid = ?"
+	deleteBmDocLabelQuery                = "DELETE FROM BmDoc_Label WHERE id = ? AND uuid = ?"
+	deleteBmDocLinkTableQuery            = "DELETE FROM BmDoc_LinkTable WHERE id = ? AND sourceUuid = ? AND targetUuid = ?"
+	deleteBmDocLinkTableByUUIDQuery      = "DELETE FROM BmDoc_LinkTable WHERE sourceUuid = ? OR targetUuid = ?"
+	countBmDocLinkTableBySourceUUIDQuery = "SELECT COUNT(*) FROM BmDoc_LinkTable WHERE sourceUuid = ?"
+)
+
+// UndoRun reverts the changes journaled for the run: Belege, assets, links, categories, persons and labels created
+// are deleted, updated Belege get their previous values back, and files copied into the BelegManager directory are
+// removed. Categories, persons and labels meanwhile used by other Belege are kept. In a dry run, the planned changes are logged only.
+func UndoRun(db *sqlx.DB, belegManagerDirectory *os.File, runID string, dryRun bool) error {
+	if !runIDPattern.MatchString(runID) {
+		return fmt.Errorf("invalid run ID '%s', expected e.g. 20250127095523", runID)
+	}
+
+	runLogger := log.WithField("run_id", runID)
+	journalPath := RunJournalFilePath(belegManagerDirectory.Name(), runID)
+	entries, readErr := readRunJournal(journalPath)
+	if os.IsNotExist(readErr) {
+		if _, statErr := os.Stat(journalPath + undoneRunJournalFileEnding); statErr == nil {
+			return fmt.Errorf("%w: %s", ErrRunAlreadyUndone, runID)
+		}
+		return fmt.Errorf("no journal of run %s found in %s", runID, belegManagerDirectory.Name())
+	} else if readErr != nil {
+		runLogger.WithError(readErr).Errorf("Failed to read run journal %s", journalPath)
+		return readErr
+	}
+
+	changes, undoErr := undoJournalEntries(runLogger, db, entries, dryRun)
+	if undoErr != nil {
+		return undoErr
+	}
+
+	if dryRun {
+		for _, change := range changes {
+			runLogger.WithFields(change.logFields()).Info("Dry run, planned change")
+		}
+		return nil
+	}
+
+	for _, change := range changes {
+		if change.Operation != importChangeRemove {
+			continue
+		}
+		if removeErr := os.Remove(change.TargetPath); removeErr != nil && !os.IsNotExist(removeErr) {
+			runLogger.WithError(removeErr).Warnf("Failed to remove copied file %s", change.TargetPath)
+		}
+	}
+	if renameErr := os.Rename(journalPath, journalPath+undoneRunJournalFileEnding); renameErr != nil {
+		runLogger.WithError(renameErr).Warnf("Failed to mark run journal %s as undone", journalPath)
+	}
+
+	runLogger.Infof("Undid run %s, reverting %d change(s)", runID, len(changes))
+	return nil
+}
+
+// undoJournalEntries reverts the database changes of the entries in reverse order within a single transaction. It
+// returns the changes made, including the files to remove once committed.
+func undoJournalEntries(logger *log.Entry, db *sqlx.DB, entries []runJournalEntry, dryRun bool) (changes []importChange, err error) {
+	tx, beginTxErr := beginTransaction(db, dryRun)
+	if beginTxErr != nil {
+		return nil, beginTxErr
+	}
+	defer func() {
+		p := recover()
+		// Roll back instead of committing a partial undo
+		if err != nil && p == nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				logger.WithError(rollbackErr).Warn("Failed to rollback transaction")
+			}
+			return
+		}
+		if finishErr := finishTransaction(tx, p); finishErr != nil {
+			changes, err = nil, finishErr
+		}
+	}()
+
+	for _, entry := range slices.Backward(entries) {
+		entryLogger := logger.WithField("file_to_import_full_path", entry.PathOfFileToImport)
+		for _, change := range slices.Backward(entry.Changes) {
+			if undoErr := undoChange(entryLogger, tx, change); undoErr != nil {
+				return nil, undoErr
+			}
+		}
+	}
+
+	return tx.changes, nil
+}
+
+func undoChange(logger *log.Entry, tx *importTx, change importChange) error {
+	switch {
+	case change.Operation == importChangeCopy:
+		tx.recordChange(logger, importChange{Operation: importChangeRemove, TargetPath: change.TargetPath})
+		return nil
+	case change.Operation == importChangeUpdate && change.Table == "BmDoc_Beleg":
+		return restoreBmDocBeleg(logger, tx, change)
+	case change.Operation != importChangeInsert:
+		return fmt.Errorf("cannot undo %s of %s", change.Operation, change.Table)
+	}
+
+	switch change.Table {
+	case "BmDoc_LinkTable":
+		sourceUUID, _ := change.Values["sourceUuid"].(string)
+		targetUUID, _ := change.Values["targetUuid"].(string)
+		return deleteJournaledRow(logger, tx, change, deleteBmDocLinkTableQuery, change.ID, sourceUUID, targetUUID)
+	case "BmDoc_Beleg":
+		return deleteJournaledBmDocEntity(logger, tx, change, deleteBmDocBelegQuery)
+	case "BmDoc_Asset":
+		return deleteJournaledBmDocEntity(logger, tx, change, deleteBmDocAssetQuery)
+	case "BmDoc_Kategorie":
+		return deleteJournaledUnusedSource(logger.WithField("category", change.Values["name"]), tx, change, deleteBmDocCategoryQuery)
+	case "BmDoc_Person":
+		return deleteJournaledUnusedSource(logger.WithField("person", change.Values["name"]), tx, change, deleteBmDocPersonQuery)
+	case "BmDoc_Label":
+		return deleteJournaledUnusedSource(logger.WithField("label", change.Values["name"]), tx, change, deleteBmDocLabelQuery)
+	default:
+		return fmt.Errorf("cannot undo %s into %s", change.Operation, change.Table)
+	}
+}
+
+// deleteJournaledUnusedSource deletes a category, person or label, unless other Belege are linked to it meanwhile.
+func deleteJournaledUnusedSource(logger *log.Entry, tx *importTx, change importChange, deleteQuery string) error {
+	var usages int
+	if countErr := tx.Get(&usages, countBmDocLinkTableBySourceUUIDQuery, change.UUID); countErr != nil {
+		logger.WithError(countErr).Warnf("Error when searching BmDoc_LinkTable for %s", change.UUID)
+		return countErr
+	}
+	if usages > 0 {
+		logger.Warnf("Keeping %s, as it is used %d time(s) meanwhile", change.Table, usages)
+		return nil
+	}
+	return deleteJournaledRow(logger, tx, change, deleteQuery, change.ID, change.UUID)
+}
+
+// deleteJournaledBmDocEntity deletes a Beleg or asset, including links to it added meanwhile, e.g. in BelegManager.
+func deleteJournaledBmDocEntity(logger *log.Entry, tx *importTx, change importChange, deleteQuery string) error {
+	result, deleteLinksErr := tx.Exec(deleteBmDocLinkTableByUUIDQuery, change.UUID, change.UUID)
+	if deleteLinksErr != nil {
+		logger.WithError(deleteLinksErr).Warnf("Error when deleting BmDoc_LinkTable of %s", change.UUID)
+		return deleteLinksErr
+	}
+	if deletedLinks, _ := result.RowsAffected(); deletedLinks > 0 {
+		logger.Infof("Deleted %d link(s) of %s %d added after the run", deletedLinks, change.Table, change.ID)
+	}
+
+	return deleteJournaledRow(logger, tx, change, deleteQuery, change.ID, change.UUID)
+}
+
+func deleteJournaledRow(logger *log.Entry, tx *importTx, change importChange, deleteQuery string, args ...any) error {
+	result, deleteErr := tx.Exec(deleteQuery, args...)
+	if deleteErr != nil {
+		logger.WithError(deleteErr).Warnf("Error when deleting %s %d", change.Table, change.ID)
+		return deleteErr
+	}
+	if deleted, _ := result.RowsAffected(); deleted == 0 {
+		logger.Debugf("%s %d does not exist anymore", change.Table, change.ID)
+		return nil
+	}
+
+	tx.recordChange(logger, importChange{Operation: importChangeDelete, Table: change.Table, ID: change.ID, UUID: change.UUID})
+	return nil
+}
+
+func restoreBmDocBeleg(logger *log.Entry, tx *importTx, change importChange) error {
+	previous := change.Previous
+	if previous == nil {
+		return errors.New("previous values of updated BmDoc_Beleg not journaled")
+	}
+
+	_, restoreErr := tx.Exec(
+		tx.schema.restoreBmDocBelegQuery,
+		previous["name"], previous["docDate"], previous["number"], previous["amount"], previous["netto"],
+		previous["vat"], previous["comment"], previous["belegDate"],
+		change.ID, change.UUID,
+	)
+	if restoreErr != nil {
+		logger.WithError(restoreErr).Warnf("Error when restoring BmDoc_Beleg %d", change.ID)
+		return restoreErr
+	}
+
+	tx.recordChange(logger, importChange{
+		Operation: importChangeUpdate,
+		Table:     change.Table,
+		ID:        change.ID,
+		UUID:      change.UUID,
+		Values:    previous,
+	})
+	return nil
+}
@@ -0,0 +1,153 @@
+package hermine
+
+import (
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_UndoRun_createdBeleg(t *testing.T) {
+	t.Parallel()
+
+	// given
+	testLogger, _ := newDebuggingNullLogger(t)
+	testLoggerEntry := testLogger.WithField("test", t.Name())
+
+	belegManagerDirectory, openDirErr := os.Open(t.TempDir())
+	require.NoError(t, openDirErr)
+	t.Cleanup(func() {
+		closeErr := belegManagerDirectory.Close()
+		require.NoError(t, closeErr)
+	})
+
+	database := openDatabaseFixture(t, testLoggerEntry)
+	countsBefore := countBmDocRows(t, database)
+	invoiceFilePath, diAr := getDiResultFixture(t)
+	r := newImportRun(database, nil, belegManagerDirectory, ImportOptions{})
+	outcome, importErr := r.importIntoBelegManager(testLoggerEntry, invoiceFilePath, diAr.AnalyzeResult.Documents[0])
+	require.NoError(t, importErr)
+	require.NotEqual(t, countsBefore, countBmDocRows(t, database))
+	copiedFile := filepath.Join(belegManagerDirectory.Name(), invoiceExampleFileName)
+	require.FileExists(t, copiedFile)
+
+	// when
+	undoErr := UndoRun(database, belegManagerDirectory, r.journal.runID, false)
+
+	// then
+	require.NoError(t, undoErr)
+	assert.Equal(t, countsBefore, countBmDocRows(t, database))
+	_, findErr := findBmDocBelegByID(testLoggerEntry, database, outcome.beleg.ID)
+	assert.Error(t, findErr)
+	assert.NoFileExists(t, copiedFile)
+	assert.NoFileExists(t, r.journal.path)
+
+	// when
+	undoAgainErr := UndoRun(database, belegManagerDirectory, r.journal.runID, false)
+
+	// then
+	assert.ErrorIs(t, undoAgainErr, ErrRunAlreadyUndone)
+}
+
+func Test_UndoRun_updatedBeleg(t *testing.T) {
+	t.Parallel()
+
+	// given
+	testLogger, _ := newDebuggingNullLogger(t)
+	testLoggerEntry := testLogger.WithField("test", t.Name())
+
+	belegManagerDirectory, openDirErr := os.Open(t.TempDir())
+	require.NoError(t, openDirErr)
+	t.Cleanup(func() {
+		closeErr := belegManagerDirectory.Close()
+		require.NoError(t, closeErr)
+	})
+
+	database := openDatabaseFixture(t, testLoggerEntry)
+	invoiceFilePath, diAr := getDiResultFixture(t)
+	firstRun := newImportRun(database, nil, belegManagerDirectory, ImportOptions{})
+	created, createErr := firstRun.importIntoBelegManager(testLoggerEntry, invoiceFilePath, diAr.AnalyzeResult.Documents[0])
+	require.NoError(t, createErr)
+	_, renameErr := database.Exec("UPDATE BmDoc_Beleg SET name = 'Renamed in BelegManager', amount = 1.5 WHERE id = ?", created.beleg.ID)
+	require.NoError(t, renameErr)
+	countsBefore := countBmDocRows(t, database)
+
+	secondRun := newImportRun(database, nil, belegManagerDirectory, ImportOptions{})
+	_, updateErr := secondRun.importIntoBelegManager(testLoggerEntry, invoiceFilePath, diAr.AnalyzeResult.Documents[0])
+	require.NoError(t, updateErr)
+	require.NotEqual(t, firstRun.journal.runID, secondRun.journal.runID)
+
+	// when
+	undoErr := UndoRun(database, belegManagerDirectory, secondRun.journal.runID, false)
+
+	// then
+	require.NoError(t, undoErr)
+	assert.Equal(t, countsBefore, countBmDocRows(t, database))
+	restoredBeleg, findErr := findBmDocBelegByID(testLoggerEntry, database, created.beleg.ID)
+	require.NoError(t, findErr)
+	assert.Equal(t, "Renamed in BelegManager", restoredBeleg.Name)
+	assert.InDelta(t, 1.5, *restoredBeleg.Amount, 0.001)
+	assert.Equal(t, *created.beleg.Number, *restoredBeleg.Number)
+	assert.FileExists(t, filepath.Join(belegManagerDirectory.Name(), invoiceExampleFileName), "file of first run is kept")
+}
+
+func Test_UndoRun_dryRun(t *testing.T) {
+	t.Parallel()
+
+	// given
+	testLogger, _ := newDebuggingNullLogger(t)
+	testLoggerEntry := testLogger.WithField("test", t.Name())
+
+	belegManagerDirectory, openDirErr := os.Open(t.TempDir())
+	require.NoError(t, openDirErr)
+	t.Cleanup(func() {
+		closeErr := belegManagerDirectory.Close()
+		require.NoError(t, closeErr)
+	})
+
+	database := openDatabaseFixture(t, testLoggerEntry)
+	invoiceFilePath, diAr := getDiResultFixture(t)
+	r := newImportRun(database, nil, belegManagerDirectory, ImportOptions{})
+	_, importErr := r.importIntoBelegManager(testLoggerEntry, invoiceFilePath, diAr.AnalyzeResult.Documents[0])
+	require.NoError(t, importErr)
+	countsAfterImport := countBmDocRows(t, database)
+
+	// when
+	undoErr := UndoRun(database, belegManagerDirectory, r.journal.runID, true)
+
+	// then
+	require.NoError(t, undoErr)
+	assert.Equal(t, countsAfterImport, countBmDocRows(t, database))
+	assert.FileExists(t, filepath.Join(belegManagerDirectory.Name(), invoiceExampleFileName))
+	assert.FileExists(t, r.journal.path)
+}
+
+func Test_UndoRun_invalidRunID(t *testing.T) {
+	t.Parallel()
+
+	belegManagerDirectory, openDirErr := os.Open(t.TempDir())
+	require.NoError(t, openDirErr)
+	t.Cleanup(func() {
+		closeErr := belegManagerDirectory.Close()
+		require.NoError(t, closeErr)
+	})
+
+	for _, runID := range []string{"../secret", "", "20250127095523"} {
+		assert.Error(t, UndoRun(nil, belegManagerDirectory, runID, false), runID)
+	}
+}
+
+func countBmDocRows(t *testing.T, db *sqlx.DB) map[string]int {
+	t.Helper()
+
+	counts := make(map[string]int)
+	for _, table := range []string{"BmDoc_Asset", "BmDoc_Beleg", "BmDoc_Kategorie", "BmDoc_Label", "BmDoc_LinkTable", "BmDoc_Person"} {
+		var count int
+		require.NoError(t, db.Get(&count, "SELECT COUNT(*) FROM "+table))
+		counts[table] = count
+	}
+
+	return counts
+}
@@ -0,0 +1,218 @@
+package hermine
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+const selectActiveBmDocCategoriesQuery = "SELECT " + bmDocCategoryColumns + " FROM BmDoc_Kategorie WHERE deleteState IS NULL OR deleteState = 0 ORDER BY id"
+
+// Vendor matches report how the category of a vendor has been chosen.
+const (
+	vendorMatchExact      = "exact"
+	vendorMatchAlias      = "alias"
+	vendorMatchNormalized = "normalized"
+	vendorMatchFuzzy      = "fuzzy"
+	vendorMatchNew        = "new"
+)
+
+// vendorDomain matches web addresses used as vendor name, e.g. "www.amazon.de", to keep the name only.
+var vendorDomain = regexp.MustCompile(`^www\.|\.(com|de|eu|net|org|at|ch|fr|it|nl|es|co\.uk|uk)\b`)
+
+// vendorLegalForms are the legal forms removed from the end of normalized vendor names, written without dots, as
+// "S.a.r.l." becomes "sarl".
+var vendorLegalForms = []string{
+	"ag", "bv", "co", "corp", "eg", "ek", "ev", "gbr", "gmbh", "haftungsbeschränkt", "inc", "kg", "kgaa", "limited", "llc",
+	"ltd", "mbh", "nv", "ohg", "plc", "sa", "sarl", "sas", "se", "spa", "srl", "ug",
+}
+
+// VendorOptions decide the category of a vendor, avoiding a new category for each spelling of the same vendor.
+type VendorOptions struct {
+	// Aliases list the names a vendor appears with in documents, e.g. "Amazon.de" for the category "Amazon".
+	Aliases []VendorAliases `mapstructure:"aliases"`
+	// FuzzyThreshold is the similarity, from 0 to 1, a normalized vendor name needs to the normalized name of an
+	// existing category to be assigned to it, 0 disables fuzzy matching.
+	FuzzyThreshold float64 `mapstructure:"fuzzy-threshold"`
+}
+
+// VendorAliases lists the names a vendor appears with in documents.
+type VendorAliases struct {
+	// Name is the name of the vendor's category in BelegManager.
+	Name    string   `mapstructure:"name"`
+	Aliases []string `mapstructure:"aliases"`
+}
+
+func (o VendorOptions) Validate() error {
+	if o.FuzzyThreshold < 0 || o.FuzzyThreshold > 1 {
+		return fmt.Errorf("fuzzy threshold %v is not between 0 and 1", o.FuzzyThreshold)
+	}
+	for _, vendor := range o.Aliases {
+		if strings.TrimSpace(vendor.Name) == "" {
+			return errors.New("vendor aliases without name")
+		}
+	}
+
+	return nil
+}
+
+// vendorMatch is the category chosen for a vendor.
+type vendorMatch struct {
+	// vendor is the name as analyzed
+	vendor       string
+	categoryName string
+	// category is nil, if it has to be created
+	category *bmDocCategory
+	kind     string
+	// similarity is the similarity of a fuzzy match
+	similarity float64
+}
+
+func (m *vendorMatch) String() string {
+	if m == nil {
+		return ""
+	}
+	if m.kind == vendorMatchFuzzy {
+		return fmt.Sprintf("%s %.2f: %s", m.kind, m.similarity, m.categoryName)
+	}
+
+	return fmt.Sprintf("%s: %s", m.kind, m.categoryName)
+}
+
+// normalizeVendorName folds case, punctuation and whitespace like normalizePersonName, and removes web addresses and
+// legal forms, so "Amazon EU S.a.r.l." equals "AMAZON EU SARL".
+func normalizeVendorName(name string) string {
+	name = vendorDomain.ReplaceAllString(strings.ToLower(name), "")
+	words := strings.Fields(normalizePersonName(strings.ReplaceAll(name, ".", "")))
+	for len(words) > 1 && slices.Contains(vendorLegalForms, words[len(words)-1]) {
+		words = words[:len(words)-1]
+	}
+
+	return strings.Join(words, " ")
+}
+
+// categoryName returns the name of the category the vendor is an alias of, or "".
+func (o VendorOptions) categoryName(vendor string) string {
+	normalizedVendor := normalizeVendorName(vendor)
+	for _, aliases := range o.Aliases {
+		names := append([]string{aliases.Name}, aliases.Aliases...)
+		if slices.ContainsFunc(names, func(name string) bool { return normalizeVendorName(name) == normalizedVendor }) {
+			return aliases.Name
+		}
+	}
+
+	return ""
+}
+
+// resolveVendorCategory chooses the category of the document's vendor: the category the vendor is an alias of, the
+// category named like the vendor, the category whose normalized name equals the normalized vendor, or the most similar
+// category, in this order. Without any, the category is to be created. It returns nil for documents without vendor.
+func resolveVendorCategory(logger *log.Entry, q sqlxSelecter, options VendorOptions, analysedDocument diDocument) (*vendorMatch, error) {
+	vendorField := analysedDocument.fieldMapping().vendor
+	if vendorField == "" || analysedDocument.getContentFieldCommaSeperated(vendorField) == "" {
+		return nil, nil
+	}
+
+	match := &vendorMatch{vendor: analysedDocument.getContentFieldCommaSeperated(vendorField), kind: vendorMatchExact}
+	match.categoryName = match.vendor
+	if aliasCategoryName := options.categoryName(match.vendor); aliasCategoryName != "" {
+		match.categoryName, match.kind = aliasCategoryName, vendorMatchAlias
+	}
+
+	var findErr error
+	if match.category, findErr = findBmDocCategoryByName(logger, q, match.categoryName); findErr != nil {
+		return nil, findErr
+	}
+	if match.category == nil {
+		if findErr = match.findSimilarCategory(logger, q, options.FuzzyThreshold); findErr != nil {
+			return nil, findErr
+		}
+	}
+
+	matchLogger := logger.WithField("vendor", match.vendor).WithField("vendor_match", match.String())
+	if match.kind == vendorMatchExact || match.kind == vendorMatchNew {
+		matchLogger.Debug("Vendor category chosen")
+	} else {
+		matchLogger.Info("Vendor category chosen")
+	}
+	return match, nil
+}
+
+// findSimilarCategory searches the active categories for one with an equal normalized name, or the most similar one
+// reaching the threshold.
+func (m *vendorMatch) findSimilarCategory(logger *log.Entry, q sqlxSelecter, fuzzyThreshold float64) error {
+	categories := make([]*bmDocCategory, 0)
+	if err := q.Select(&categories, selectActiveBmDocCategoriesQuery); err != nil {
+		logger.WithError(err).Warn("Error when searching BmDoc_Kategorie")
+		return err
+	}
+
+	normalizedName := normalizeVendorName(m.categoryName)
+	var bestCategory *bmDocCategory
+	var bestSimilarity float64
+	for _, category := range categories {
+		normalizedCategoryName := normalizeVendorName(category.Name)
+		if normalizedCategoryName == normalizedName {
+			m.category, m.categoryName, m.kind = category, category.Name, vendorMatchNormalized
+			return nil
+		}
+		if similarity := nameSimilarity(normalizedName, normalizedCategoryName); similarity > bestSimilarity {
+			bestCategory, bestSimilarity = category, similarity
+		}
+	}
+
+	if fuzzyThreshold > 0 && bestCategory != nil && bestSimilarity >= fuzzyThreshold {
+		m.category, m.categoryName, m.kind, m.similarity = bestCategory, bestCategory.Name, vendorMatchFuzzy, bestSimilarity
+		return nil
+	}
+	if m.kind != vendorMatchAlias {
+		m.kind = vendorMatchNew
+	}
+	return nil
+}
+
+// nameSimilarity returns 1 minus the Levenshtein distance of the names relative to the longer one, 1 for equal names.
+func nameSimilarity(a, b string) float64 {
+	aRunes, bRunes := []rune(a), []rune(b)
+	longer := max(len(aRunes), len(bRunes))
+	if longer == 0 {
+		return 1
+	}
+
+	previous := make([]int, len(bRunes)+1)
+	current := make([]int, len(bRunes)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(aRunes); i++ {
+		current[0] = i
+		for j := 1; j <= len(bRunes); j++ {
+			substitution := previous[j-1]
+			if aRunes[i-1] != bRunes[j-1] {
+				substitution++
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, substitution)
+		}
+		previous, current = current, previous
+	}
+
+	return 1 - float64(previous[len(bRunes)])/float64(longer)
+}
+
+// linkVendorCategoryToBeleg links the category chosen for the vendor, creating it if required.
+func linkVendorCategoryToBeleg(logger *log.Entry, tx *importTx, match *vendorMatch, beleg *bmDocBeleg) error {
+	if match.category == nil {
+		if createErr := createBmDocCategory(logger, tx, match.categoryName); createErr != nil {
+			return createErr
+		}
+		var findErr error
+		if match.category, findErr = findBmDocCategoryByName(logger, tx, match.categoryName); findErr != nil {
+			return findErr
+		}
+	}
+
+	return createIgnoreBmDocLink(logger, tx, match.category.UUID, beleg.UUID)
+}
@@ -0,0 +1,158 @@
+package hermine
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_normalizeVendorName(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		vendor string
+		want   string
+	}{
+		{vendor: "Amazon EU S.a.r.l.", want: "amazon eu"},
+		{vendor: "AMAZON  EU SARL", want: "amazon eu"},
+		{vendor: "Amazon.de", want: "amazon"},
+		{vendor: "www.amazon.de", want: "amazon"},
+		{vendor: "Bäckerei Müller GmbH & Co. KG", want: "bäckerei müller"},
+		{vendor: "Muster UG (haftungsbeschränkt)", want: "muster"},
+		{vendor: "CONTOSO\nLTD.", want: "contoso"},
+		{vendor: "AG", want: "ag"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.vendor, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tt.want, normalizeVendorName(tt.vendor))
+		})
+	}
+}
+
+func Test_nameSimilarity(t *testing.T) {
+	t.Parallel()
+
+	assert.InDelta(t, 1, nameSimilarity("amazon", "amazon"), 0.001)
+	assert.InDelta(t, 1, nameSimilarity("", ""), 0.001)
+	assert.InDelta(t, 0.875, nameSimilarity("telekom", "telekomm"), 0.001)
+	assert.InDelta(t, 5.0/6, nameSimilarity("amazon", "amazom"), 0.001)
+	assert.InDelta(t, 0, nameSimilarity("abc", "xyz"), 0.001)
+}
+
+func Test_VendorOptions_Validate(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, VendorOptions{FuzzyThreshold: 0.9, Aliases: []VendorAliases{{Name: "Amazon", Aliases: []string{"Amazon.de"}}}}.Validate())
+	assert.Error(t, VendorOptions{FuzzyThreshold: 1.1}.Validate())
+	assert.Error(t, VendorOptions{Aliases: []VendorAliases{{Aliases: []string{"Amazon.de"}}}}.Validate())
+}
+
+func Test_importIntoBelegManager_vendor(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name            string
+		categories      []string
+		options         VendorOptions
+		wantCategory    string
+		wantVendorMatch string
+		wantCreated     bool
+	}{
+		{name: "exact", categories: []string{"CONTOSO"}, wantCategory: "CONTOSO", wantVendorMatch: "exact: CONTOSO"},
+		{name: "new", wantCategory: "CONTOSO", wantVendorMatch: "new: CONTOSO", wantCreated: true},
+		{name: "normalized", categories: []string{"Contoso Ltd."}, wantCategory: "Contoso Ltd.", wantVendorMatch: "normalized: Contoso Ltd."},
+		{name: "fuzzy", categories: []string{"Contosso GmbH", "Handwerkerleistungen"}, options: VendorOptions{FuzzyThreshold: 0.85}, wantCategory: "Contosso GmbH", wantVendorMatch: "fuzzy 0.88: Contosso GmbH"},
+		{name: "fuzzy below threshold", categories: []string{"Contosso GmbH"}, options: VendorOptions{FuzzyThreshold: 0.9}, wantCategory: "CONTOSO", wantVendorMatch: "new: CONTOSO", wantCreated: true},
+		{
+			name:            "alias",
+			categories:      []string{"Contoso Holding", "CONTOSO"},
+			options:         VendorOptions{Aliases: []VendorAliases{{Name: "Contoso Holding", Aliases: []string{"contoso.com", "Contoso"}}}},
+			wantCategory:    "Contoso Holding",
+			wantVendorMatch: "alias: Contoso Holding",
+		},
+		{
+			name:            "alias of a new category",
+			options:         VendorOptions{Aliases: []VendorAliases{{Name: "Contoso Holding", Aliases: []string{"Contoso"}}}},
+			wantCategory:    "Contoso Holding",
+			wantVendorMatch: "alias: Contoso Holding",
+			wantCreated:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			// given
+			testLoggerEntry := newDummyLogEntry(t)
+			belegManagerDirectory, openDirErr := os.Open(t.TempDir())
+			require.NoError(t, openDirErr)
+			t.Cleanup(func() {
+				require.NoError(t, belegManagerDirectory.Close())
+			})
+
+			database := openPlainDatabase(t, copyDatabaseFixtureIntoDirectory(t, t.TempDir()))
+			for _, category := range tt.categories {
+				_, insertErr := database.Exec("INSERT INTO BmDoc_Kategorie (uuid, name, docType, deleteState) VALUES (?, ?, 1, 0)", newBmDocUUID(), category)
+				require.NoError(t, insertErr)
+			}
+			countsBefore := countBmDocRows(t, database)
+			invoiceFilePath, diAr := getDiResultFixture(t)
+			r := newImportRun(database, nil, belegManagerDirectory, ImportOptions{Vendor: tt.options})
+
+			// when
+			outcome, importErr := r.importIntoBelegManager(testLoggerEntry, invoiceFilePath, diAr.AnalyzeResult.Documents[0])
+
+			// then
+			require.NoError(t, importErr)
+			assert.Equal(t, tt.wantVendorMatch, outcome.vendor.String())
+			category, findErr := findBmDocCategoryByName(testLoggerEntry, database, tt.wantCategory)
+			require.NoError(t, findErr)
+			require.NotNil(t, category)
+			links, findLinksErr := findBmDocLinkByBelegAsTarget(testLoggerEntry, database, outcome.beleg)
+			require.NoError(t, findLinksErr)
+			assert.True(t, containsLink(links, category.UUID, outcome.beleg.UUID))
+
+			wantCreatedCategories := 1 // the customer's
+			if tt.wantCreated {
+				wantCreatedCategories++
+			}
+			assert.Equal(t, countsBefore["BmDoc_Kategorie"]+wantCreatedCategories, countBmDocRows(t, database)["BmDoc_Kategorie"])
+		})
+	}
+}
+
+func Test_importIntoBelegManager_vendorDuplicate(t *testing.T) {
+	t.Parallel()
+
+	// given
+	testLoggerEntry := newDummyLogEntry(t)
+	belegManagerDirectory, openDirErr := os.Open(t.TempDir())
+	require.NoError(t, openDirErr)
+	t.Cleanup(func() {
+		require.NoError(t, belegManagerDirectory.Close())
+	})
+
+	database := openPlainDatabase(t, copyDatabaseFixtureIntoDirectory(t, t.TempDir()))
+	invoiceFilePath, diAr := getDiResultFixture(t)
+	document := diAr.AnalyzeResult.Documents[0]
+	options := ImportOptions{DuplicatePolicy: DuplicatePolicySkip}
+	_, importErr := newImportRun(database, nil, belegManagerDirectory, options).importIntoBelegManager(testLoggerEntry, invoiceFilePath, document)
+	require.NoError(t, importErr)
+
+	photoFilePath := filepath.Join(t.TempDir(), "photo.jpg")
+	require.NoError(t, os.WriteFile(photoFilePath, []byte("photo of the invoice"), 0o600))
+	vendorName := document.Fields["VendorName"]
+	vendorName.Content = "Contoso Ltd."
+	document.Fields["VendorName"] = vendorName
+
+	// when
+	outcome, duplicateImportErr := newImportRun(database, nil, belegManagerDirectory, options).importIntoBelegManager(testLoggerEntry, photoFilePath, document)
+
+	// then
+	require.NoError(t, duplicateImportErr)
+	assert.Equal(t, "skip: Beleg 1", outcome.duplicate.String(), "vendor compared by its category")
+}
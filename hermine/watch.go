@@ -0,0 +1,269 @@
+package hermine
+
+import (
+	"context"
+	"errors"
+	"github.com/bmatcuk/doublestar/v4"
+	"github.com/fsnotify/fsnotify"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// WatchOptions configures WatchFiles.
+type WatchOptions struct {
+	// Debounce is the duration a file has to stay unchanged, before it is imported. Scanners and network shares write
+	// files in several steps, so files are imported only once completely written.
+	Debounce time.Duration
+	// LogRotationInterval is the interval of writing the CSV log of the files imported meanwhile, 0 writes a single
+	// CSV log on shutdown.
+	LogRotationInterval time.Duration
+}
+
+func DefaultWatchOptions() WatchOptions {
+	return WatchOptions{
+		Debounce:            5 * time.Second,
+		LogRotationInterval: 24 * time.Hour,
+	}
+}
+
+// pendingFile is a file, which changed recently and is imported once unchanged for the debounce duration.
+type pendingFile struct {
+	lastChange time.Time
+	size       int64
+	modTime    time.Time
+}
+
+// fileWatch imports files matching a glob pattern, as they appear in the directories behind the pattern.
+type fileWatch struct {
+	run     *importRun
+	glob    string
+	options WatchOptions
+	watcher *fsnotify.Watcher
+	pending map[string]*pendingFile
+
+	mu   sync.Mutex
+	pdds []*processingDoneData
+}
+
+// WatchFiles imports new or changed files matching filesToImportGlob until ctx is done. Files already existing when
+// starting are not imported. On shutdown, running imports are finished and the CSV log is written.
+func WatchFiles(ctx context.Context, db *sqlx.DB, analyzer DocumentAnalyzer, belegManagerDirectory *os.File, filesToImportGlob string, importOptions ImportOptions, watchOptions WatchOptions) error {
+	watcher, newWatcherErr := fsnotify.NewWatcher()
+	if newWatcherErr != nil {
+		log.WithError(newWatcherErr).Error("Failed to create file system watcher")
+		return newWatcherErr
+	}
+	defer func() {
+		if closeErr := watcher.Close(); closeErr != nil {
+			log.WithError(closeErr).Debug("Failed to close file system watcher")
+		}
+	}()
+
+	w := &fileWatch{
+		run:     newImportRun(db, analyzer, belegManagerDirectory, importOptions),
+		glob:    filepath.ToSlash(filesToImportGlob),
+		options: watchOptions,
+		watcher: watcher,
+		pending: make(map[string]*pendingFile),
+	}
+
+	baseDirectory, _ := doublestar.SplitPattern(w.glob)
+	if addErr := w.addDirectoryRecursively(filepath.FromSlash(baseDirectory), false); addErr != nil {
+		return addErr
+	}
+	log.WithField("glob_pattern", filesToImportGlob).Infof("Watching %s for new files", filepath.FromSlash(baseDirectory))
+
+	w.watch(ctx)
+	return nil
+}
+
+func (w *fileWatch) watch(ctx context.Context) {
+	// Buffered, so events are still handled while all workers are busy
+	filesToImport := make(chan string, 1024)
+	var wg sync.WaitGroup
+	for range max(w.run.options.AnalysisConcurrency, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for pathOfFileToImport := range filesToImport {
+				if ctx.Err() != nil {
+					w.addResults(skippedFiles([]string{pathOfFileToImport}))
+					continue
+				}
+				w.addResults(w.run.processFile(ctx, pathOfFileToImport))
+			}
+		}()
+	}
+
+	debounceTicker := time.NewTicker(max(w.options.Debounce/2, 100*time.Millisecond))
+	defer debounceTicker.Stop()
+	var logRotation <-chan time.Time
+	if w.options.LogRotationInterval > 0 {
+		logRotationTicker := time.NewTicker(w.options.LogRotationInterval)
+		defer logRotationTicker.Stop()
+		logRotation = logRotationTicker.C
+	}
+
+	for running := true; running; {
+		select {
+		case <-ctx.Done():
+			running = false
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				running = false
+				break
+			}
+			w.handleEvent(event)
+		case watchErr, ok := <-w.watcher.Errors:
+			if !ok {
+				running = false
+				break
+			}
+			log.WithError(watchErr).Warn("File system watcher failed")
+		case now := <-debounceTicker.C:
+			w.queueSettledFiles(now, filesToImport)
+		case <-logRotation:
+			w.writeLog()
+		}
+	}
+
+	if len(w.pending) > 0 {
+		log.Infof("Shutting down, %d file(s) not yet completely written or queued are not imported", len(w.pending))
+	}
+	close(filesToImport)
+	wg.Wait()
+	w.writeLog()
+}
+
+func (w *fileWatch) handleEvent(event fsnotify.Event) {
+	if !event.Has(fsnotify.Create) && !event.Has(fsnotify.Write) {
+		return
+	}
+
+	info, statErr := os.Stat(event.Name)
+	if statErr != nil {
+		// E.g. temporary files removed meanwhile
+		log.WithError(statErr).Tracef("Ignoring %s", event.Name)
+		return
+	}
+	if info.IsDir() {
+		// Files may have been written into a new directory before it is watched, or it may have been moved in
+		if event.Has(fsnotify.Create) {
+			if addErr := w.addDirectoryRecursively(event.Name, true); addErr != nil {
+				log.WithError(addErr).Warnf("Failed to watch new directory %s", event.Name)
+			}
+		}
+		return
+	}
+
+	if w.addPendingFile(event.Name, info) {
+		log.WithField("file_to_import_full_path", event.Name).Tracef("File changed: %s", event.Op)
+	}
+}
+
+// addPendingFile remembers the file to be imported once settled, if it matches the glob pattern.
+func (w *fileWatch) addPendingFile(path string, info fs.FileInfo) bool {
+	if matched, _ := doublestar.Match(w.glob, filepath.ToSlash(path)); !matched || IsAnalysisSidecarFile(path) {
+		return false
+	}
+
+	w.pending[path] = &pendingFile{lastChange: time.Now(), size: info.Size(), modTime: info.ModTime()}
+	return true
+}
+
+// takeSettledFiles returns the pending files, which have not changed for the debounce duration, and forgets them.
+func (w *fileWatch) takeSettledFiles(now time.Time) []string {
+	settledFiles := make([]string, 0)
+	for pathOfFileToImport, pending := range w.pending {
+		info, statErr := os.Stat(pathOfFileToImport)
+		if statErr != nil {
+			log.WithError(statErr).Debugf("Pending file vanished: %s", pathOfFileToImport)
+			delete(w.pending, pathOfFileToImport)
+			continue
+		}
+
+		// Some writers do not cause an event for each write, so file size and modification time are checked as well
+		if info.Size() != pending.size || !info.ModTime().Equal(pending.modTime) {
+			w.pending[pathOfFileToImport] = &pendingFile{lastChange: now, size: info.Size(), modTime: info.ModTime()}
+			continue
+		}
+		if now.Sub(pending.lastChange) >= w.options.Debounce {
+			settledFiles = append(settledFiles, pathOfFileToImport)
+			delete(w.pending, pathOfFileToImport)
+		}
+	}
+
+	return settledFiles
+}
+
+// queueSettledFiles hands the settled files to the workers. Files not fitting into the queue, as all workers are busy,
+// stay pending and are queued on one of the next ticks, so events and shutdown are still handled meanwhile.
+func (w *fileWatch) queueSettledFiles(now time.Time, filesToImport chan<- string) {
+	for _, pathOfFileToImport := range w.takeSettledFiles(now) {
+		select {
+		case filesToImport <- pathOfFileToImport:
+		default:
+			info, statErr := os.Stat(pathOfFileToImport)
+			if statErr != nil {
+				log.WithError(statErr).Debugf("Pending file vanished: %s", pathOfFileToImport)
+				continue
+			}
+			log.WithField("file_to_import_full_path", pathOfFileToImport).Debug("Import queue full, queueing file later")
+			// Settled already, so it is queued on the next tick, unless changed meanwhile
+			w.pending[pathOfFileToImport] = &pendingFile{lastChange: now.Add(-w.options.Debounce), size: info.Size(), modTime: info.ModTime()}
+		}
+	}
+}
+
+// addDirectoryRecursively watches the directory and its subdirectories. If queueFiles is set, the files matching the
+// glob pattern already inside are imported as well, once settled.
+func (w *fileWatch) addDirectoryRecursively(directory string, queueFiles bool) error {
+	return filepath.WalkDir(directory, func(path string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			if errors.Is(walkErr, fs.ErrPermission) {
+				log.WithError(walkErr).Warnf("Not watching %s", path)
+				return fs.SkipDir
+			}
+			return walkErr
+		}
+		if !d.IsDir() {
+			if queueFiles && d.Type().IsRegular() {
+				if info, infoErr := d.Info(); infoErr == nil && w.addPendingFile(path, info) {
+					log.WithField("file_to_import_full_path", path).Trace("File found in new directory")
+				}
+			}
+			return nil
+		}
+
+		if addErr := w.watcher.Add(path); addErr != nil {
+			log.WithError(addErr).Warnf("Failed to watch %s", path)
+			return addErr
+		}
+		log.Tracef("Watching directory %s", path)
+		return nil
+	})
+}
+
+func (w *fileWatch) addResults(pdds []*processingDoneData) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.pdds = append(w.pdds, pdds...)
+}
+
+// writeLog writes the CSV log of the files imported since the last log was written, if any.
+func (w *fileWatch) writeLog() {
+	w.mu.Lock()
+	pdds := w.pdds
+	w.pdds = nil
+	w.mu.Unlock()
+
+	if len(pdds) > 0 {
+		w.run.report(pdds)
+	}
+}
@@ -0,0 +1,170 @@
+package hermine
+
+import (
+	"context"
+	"github.com/fsnotify/fsnotify"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_WatchFiles(t *testing.T) {
+	// given
+	testLogger, _ := newDebuggingNullLogger(t)
+	testLoggerEntry := testLogger.WithField("test", t.Name())
+
+	belegManagerDirectory, openDirErr := os.Open(t.TempDir())
+	require.NoError(t, openDirErr)
+	t.Cleanup(func() {
+		require.NoError(t, belegManagerDirectory.Close())
+	})
+
+	database := openDatabaseFixture(t, testLoggerEntry)
+	invoiceFilePath, diAr := getDiResultFixture(t)
+	analyzer := fixtureDocumentAnalyzer{result: diAr.AnalyzeResult}
+
+	importDirectory := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(importDirectory, "existing.png"), []byte("existing"), 0o600))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	watchDone := make(chan error)
+	go func() {
+		watchOptions := WatchOptions{Debounce: 50 * time.Millisecond}
+		watchDone <- WatchFiles(ctx, database, analyzer, belegManagerDirectory, filepath.Join(importDirectory, "**", "*.png"), ImportOptions{}, watchOptions)
+	}()
+	time.Sleep(200 * time.Millisecond)
+
+	// when
+	subDirectory := filepath.Join(importDirectory, "sub")
+	require.NoError(t, os.Mkdir(subDirectory, 0o700))
+	time.Sleep(100 * time.Millisecond)
+	require.NoError(t, copyFileToTargetIfTargetDoesNotExist(testLoggerEntry, invoiceFilePath, filepath.Join(subDirectory, "new.png")))
+	require.NoError(t, os.WriteFile(filepath.Join(subDirectory, "ignored.txt"), []byte("ignored"), 0o600))
+
+	// then
+	require.Eventually(t, func() bool {
+		var belegCount int
+		return database.Get(&belegCount, "SELECT COUNT(*) FROM BmDoc_Beleg") == nil && belegCount == 1
+	}, 5*time.Second, 50*time.Millisecond)
+
+	// when
+	cancel()
+
+	// then
+	select {
+	case watchErr := <-watchDone:
+		require.NoError(t, watchErr)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "watch did not shut down")
+	}
+	csvLogs, globErr := filepath.Glob(filepath.Join(belegManagerDirectory.Name(), "_import-log-*.csv"))
+	require.NoError(t, globErr)
+	assert.Len(t, csvLogs, 1)
+
+	var belegCount int
+	require.NoError(t, database.Get(&belegCount, "SELECT COUNT(*) FROM BmDoc_Beleg"))
+	assert.Equal(t, 1, belegCount, "existing file is not imported")
+}
+
+func Test_fileWatch_handleEvent_movedDirectory(t *testing.T) {
+	// given
+	importDirectory := t.TempDir()
+	batchDirectory := filepath.Join(t.TempDir(), "scan-batch")
+	require.NoError(t, os.MkdirAll(filepath.Join(batchDirectory, "nested"), 0o700))
+	for _, fileName := range []string{"first.png", filepath.Join("nested", "second.png"), "notes.txt"} {
+		require.NoError(t, os.WriteFile(filepath.Join(batchDirectory, fileName), []byte(fileName), 0o600))
+	}
+
+	watcher, newWatcherErr := fsnotify.NewWatcher()
+	require.NoError(t, newWatcherErr)
+	t.Cleanup(func() {
+		require.NoError(t, watcher.Close())
+	})
+	w := fileWatch{
+		glob:    filepath.ToSlash(filepath.Join(importDirectory, "**", "*.png")),
+		watcher: watcher,
+		pending: make(map[string]*pendingFile),
+	}
+	require.NoError(t, w.addDirectoryRecursively(importDirectory, false))
+
+	// when
+	movedDirectory := filepath.Join(importDirectory, "scan-batch")
+	require.NoError(t, os.Rename(batchDirectory, movedDirectory))
+	w.handleEvent(fsnotify.Event{Name: movedDirectory, Op: fsnotify.Create})
+
+	// then
+	pendingFiles := make([]string, 0, len(w.pending))
+	for pathOfFileToImport := range w.pending {
+		pendingFiles = append(pendingFiles, pathOfFileToImport)
+	}
+	assert.ElementsMatch(t, []string{
+		filepath.Join(movedDirectory, "first.png"),
+		filepath.Join(movedDirectory, "nested", "second.png"),
+	}, pendingFiles)
+	assert.Contains(t, watcher.WatchList(), filepath.Join(movedDirectory, "nested"))
+}
+
+func Test_fileWatch_takeSettledFiles(t *testing.T) {
+	// given
+	filePath := filepath.Join(t.TempDir(), "scan.pdf")
+	require.NoError(t, os.WriteFile(filePath, []byte("partial"), 0o600))
+	info, statErr := os.Stat(filePath)
+	require.NoError(t, statErr)
+
+	start := time.Now()
+	w := fileWatch{
+		options: WatchOptions{Debounce: time.Second},
+		pending: map[string]*pendingFile{
+			filePath: {lastChange: start, size: info.Size(), modTime: info.ModTime()},
+			filepath.Join(t.TempDir(), "vanished.pdf"): {lastChange: start},
+		},
+	}
+
+	// when then
+	assert.Empty(t, w.takeSettledFiles(start.Add(500*time.Millisecond)))
+	assert.Len(t, w.pending, 1, "vanished file is forgotten")
+
+	// when the file grows without event
+	require.NoError(t, os.WriteFile(filePath, []byte("partially written"), 0o600))
+
+	// then
+	assert.Empty(t, w.takeSettledFiles(start.Add(1500*time.Millisecond)))
+	assert.Empty(t, w.takeSettledFiles(start.Add(2000*time.Millisecond)))
+	assert.Equal(t, []string{filePath}, w.takeSettledFiles(start.Add(2500*time.Millisecond)))
+	assert.Empty(t, w.pending)
+}
+
+func Test_fileWatch_queueSettledFiles_queueFull(t *testing.T) {
+	// given
+	directory := t.TempDir()
+	start := time.Now()
+	w := fileWatch{options: WatchOptions{Debounce: time.Second}, pending: make(map[string]*pendingFile)}
+	for _, fileName := range []string{"first.pdf", "second.pdf"} {
+		filePath := filepath.Join(directory, fileName)
+		require.NoError(t, os.WriteFile(filePath, []byte(fileName), 0o600))
+		info, statErr := os.Stat(filePath)
+		require.NoError(t, statErr)
+		w.pending[filePath] = &pendingFile{lastChange: start, size: info.Size(), modTime: info.ModTime()}
+	}
+	filesToImport := make(chan string, 1)
+
+	// when
+	w.queueSettledFiles(start.Add(time.Second), filesToImport)
+
+	// then
+	require.Len(t, filesToImport, 1, "not blocked by the full queue")
+	firstQueued := <-filesToImport
+	require.Len(t, w.pending, 1)
+
+	// when the queue has room again
+	w.queueSettledFiles(start.Add(1500*time.Millisecond), filesToImport)
+
+	// then
+	require.Len(t, filesToImport, 1)
+	assert.NotEqual(t, firstQueued, <-filesToImport)
+	assert.Empty(t, w.pending)
+}